@@ -2,7 +2,15 @@
 # Copyright (c) 2021 Red Hat, Inc.
 # Copyright Contributors to the Open Cluster Management project.
 
+set -e
+
+ROOTDIR="$(cd "$(dirname "$0")/.." ; pwd -P)"
+
+# the kind clusters need the test environment profile, which used to be applied by the operator
+# for the kind cluster id
+kubectl create namespace open-cluster-management-addon-observability --dry-run=client -o yaml | kubectl apply -f -
+kubectl apply -f "${ROOTDIR}/config/e2e/observability-addon-config.yaml"
+
 git clone --depth 1 https://github.com/open-cluster-management/observability-e2e-test.git
 cd observability-e2e-test
 make test-e2e
-
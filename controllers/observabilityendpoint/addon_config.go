@@ -0,0 +1,210 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	addonConfigName = "observability-addon-config"
+	addonConfigKey  = "config.yaml"
+	tlsModeVerify   = "verify"
+	tlsModeDisabled = "disabled"
+	uwlPromURL      = "https://prometheus-user-workload.openshift-user-workload-monitoring.svc:9091"
+)
+
+// AddonConfig is the addon configuration which is not covered by the ObservabilityAddon spec
+type AddonConfig struct {
+	MetricsSource       *MetricsSource       `yaml:"metricsSource,omitempty"`
+	UserWorkloadMetrics *UserWorkloadMetrics `yaml:"userWorkloadMetrics,omitempty"`
+	AlertForwarding     *AlertForwarding     `yaml:"alertForwarding,omitempty"`
+	ExternalLabels      []ExternalLabel      `yaml:"externalLabels,omitempty"`
+	TestEnvironment     *TestEnvironment     `yaml:"testEnvironment,omitempty"`
+}
+
+// AlertForwarding controls the forwarding of the alerts to the hub Alertmanager independently of enableMetrics,
+// the alerts with any of the dropped label values are dropped by the platform Prometheus
+type AlertForwarding struct {
+	// Enabled is true by default, false removes the hub Alertmanager from the monitoring stacks
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// DropAlertNames are the values of the alertname label of the alerts which are dropped, e.g. Watchdog
+	DropAlertNames []string `yaml:"dropAlertNames,omitempty"`
+	// DropSeverities are the values of the severity label of the alerts which are dropped, e.g. info
+	DropSeverities []string `yaml:"dropSeverities,omitempty"`
+	// DropNamespaces are the values of the namespace label of the alerts which are dropped
+	DropNamespaces []string `yaml:"dropNamespaces,omitempty"`
+}
+
+// UserWorkloadMetrics enables the collection from the OpenShift user workload monitoring stack as a second source,
+// the series are selected by the userWorkload section of the allowlist and labeled with source=user-workload
+type UserWorkloadMetrics struct {
+	Enabled bool `yaml:"enabled"`
+	// ServerURL overrides the federate endpoint of the user workload Prometheus
+	ServerURL string `yaml:"serverUrl,omitempty"`
+}
+
+// MetricsSource is the server configuration to get metrics from
+type MetricsSource struct {
+	// ServerURL is the url of the Prometheus compatible server, e.g. Thanos Querier
+	ServerURL string `yaml:"serverUrl"`
+	// TLSConfig refers to the secrets used to verify the server and to authenticate to it
+	TLSConfig *MetricsSourceTLSConfig `yaml:"tlsConfig,omitempty"`
+	// BearerTokenSecret is the name of the secret which contains the bearer token in key token,
+	// the service account token is used if it is empty
+	BearerTokenSecret string `yaml:"bearerTokenSecret,omitempty"`
+}
+
+// MetricsSourceTLSConfig is the tls configuration for the metrics source
+type MetricsSourceTLSConfig struct {
+	// CA is the name of the secret which contains the CA certificate in key ca.crt
+	CA string `yaml:"ca,omitempty"`
+	// Cert is the name of the secret which contains the client certificate in keys tls.crt and tls.key
+	Cert string `yaml:"cert,omitempty"`
+}
+
+// TestEnvironment is the profile for test environments, such as the kind clusters used by the e2e tests,
+// it should not be set in production
+type TestEnvironment struct {
+	// MetricsSourceURL overrides the url of the metrics source
+	MetricsSourceURL string `yaml:"metricsSourceUrl,omitempty"`
+	// HostAliases are added into the metrics collector pod, e.g. to resolve the hub endpoints
+	HostAliases []HostAlias `yaml:"hostAliases,omitempty"`
+	// TLSMode is verify by default, disabled means that the metrics source is served over plain http
+	TLSMode string `yaml:"tlsMode,omitempty"`
+}
+
+// HostAlias is the ip and hostnames added into the hosts file of the metrics collector pod
+type HostAlias struct {
+	IP        string   `yaml:"ip"`
+	Hostnames []string `yaml:"hostnames"`
+}
+
+// getAddonConfig returns the addon configuration, an empty one is returned if the configmap doesn't exist
+func getAddonConfig(ctx context.Context, c client.Client, ns string) (*AddonConfig, error) {
+	config := &AddonConfig{}
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: addonConfigName,
+		Namespace: ns}, cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return config, nil
+		}
+		log.Error(err, "Failed to get the addon config configmap")
+		return nil, err
+	}
+	err = yaml.Unmarshal([]byte(cm.Data[addonConfigKey]), config)
+	if err != nil {
+		log.Error(err, "Failed to unmarshal data in configmap", "name", addonConfigName)
+		return nil, err
+	}
+	if err = validateAddonConfig(config); err != nil {
+		log.Error(err, "Invalid addon config", "name", addonConfigName)
+		return nil, err
+	}
+	return config, nil
+}
+
+// getMetricsSource returns the configured metrics source with the test environment overrides applied,
+// nil means the default OpenShift Prometheus
+func getMetricsSource(config *AddonConfig) *MetricsSource {
+	if config == nil {
+		return nil
+	}
+	if config.TestEnvironment == nil || config.TestEnvironment.MetricsSourceURL == "" {
+		return config.MetricsSource
+	}
+	source := &MetricsSource{}
+	if config.MetricsSource != nil {
+		*source = *config.MetricsSource
+	}
+	source.ServerURL = config.TestEnvironment.MetricsSourceURL
+	return source
+}
+
+// getUserWorkloadSourceURL returns the url of the user workload metrics source,
+// empty means that the user workload metrics are not collected
+func getUserWorkloadSourceURL(config *AddonConfig, platform string) string {
+	if config == nil || config.UserWorkloadMetrics == nil || !config.UserWorkloadMetrics.Enabled ||
+		platform != platformOpenShift {
+		return ""
+	}
+	if config.UserWorkloadMetrics.ServerURL != "" {
+		return config.UserWorkloadMetrics.ServerURL
+	}
+	return uwlPromURL
+}
+
+func validateAddonConfig(config *AddonConfig) error {
+	if config.MetricsSource != nil {
+		if err := validateServerURL("metricsSource.serverUrl", config.MetricsSource.ServerURL); err != nil {
+			return err
+		}
+	}
+	if uwl := config.UserWorkloadMetrics; uwl != nil && uwl.ServerURL != "" {
+		if err := validateServerURL("userWorkloadMetrics.serverUrl", uwl.ServerURL); err != nil {
+			return err
+		}
+	}
+	if af := config.AlertForwarding; af != nil {
+		for field, values := range map[string][]string{
+			"alertForwarding.dropAlertNames": af.DropAlertNames,
+			"alertForwarding.dropSeverities": af.DropSeverities,
+			"alertForwarding.dropNamespaces": af.DropNamespaces,
+		} {
+			for _, v := range values {
+				if v == "" {
+					return fmt.Errorf("invalid %s: empty value", field)
+				}
+			}
+		}
+	}
+	if err := validateExternalLabels(config.ExternalLabels); err != nil {
+		return err
+	}
+	if env := config.TestEnvironment; env != nil {
+		if env.MetricsSourceURL != "" {
+			if err := validateServerURL("testEnvironment.metricsSourceUrl", env.MetricsSourceURL); err != nil {
+				return err
+			}
+		}
+		switch env.TLSMode {
+		case "", tlsModeVerify:
+		case tlsModeDisabled:
+			if !strings.HasPrefix(env.MetricsSourceURL, "http://") {
+				return fmt.Errorf("testEnvironment.metricsSourceUrl must be an http url when tlsMode is %s",
+					tlsModeDisabled)
+			}
+		default:
+			return fmt.Errorf("invalid testEnvironment.tlsMode %q: %s or %s is required",
+				env.TLSMode, tlsModeVerify, tlsModeDisabled)
+		}
+		for _, alias := range env.HostAliases {
+			if net.ParseIP(alias.IP) == nil || len(alias.Hostnames) == 0 {
+				return fmt.Errorf("invalid testEnvironment.hostAliases %v: ip and hostnames are required", alias)
+			}
+		}
+	}
+	return nil
+}
+
+func validateServerURL(field string, serverURL string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", field, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: http or https url is required", field, serverURL)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newAddonConfigCM(data string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      addonConfigName,
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			addonConfigKey: data,
+		},
+	}
+}
+
+func TestGetAddonConfig(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+	config, err := getAddonConfig(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to get addon config when configmap is missing: (%v)", err)
+	}
+	if config.MetricsSource != nil {
+		t.Fatalf("Metrics source should not be set when configmap is missing: (%v)", config.MetricsSource)
+	}
+
+	caseList := []struct {
+		name      string
+		data      string
+		expectErr bool
+		serverURL string
+	}{
+		{
+			name: "valid metrics source",
+			data: `
+metricsSource:
+  serverUrl: https://thanos-querier.monitoring.svc:9091
+  tlsConfig:
+    ca: thanos-querier-ca
+  bearerTokenSecret: thanos-querier-token
+`,
+			serverURL: "https://thanos-querier.monitoring.svc:9091",
+		},
+		{
+			name: "missing scheme",
+			data: `
+metricsSource:
+  serverUrl: thanos-querier.monitoring.svc:9091
+`,
+			expectErr: true,
+		},
+		{
+			name: "unsupported scheme",
+			data: `
+metricsSource:
+  serverUrl: ftp://thanos-querier
+`,
+			expectErr: true,
+		},
+		{
+			name: "test environment",
+			data: `
+testEnvironment:
+  metricsSourceUrl: http://prometheus-k8s.openshift-monitoring.svc:9090
+  hostAliases:
+  - ip: 172.17.0.2
+    hostnames:
+    - observatorium.hub
+  tlsMode: disabled
+`,
+		},
+		{
+			name: "https url with tls disabled",
+			data: `
+testEnvironment:
+  metricsSourceUrl: https://prometheus-k8s.openshift-monitoring.svc:9091
+  tlsMode: disabled
+`,
+			expectErr: true,
+		},
+		{
+			name: "unknown tls mode",
+			data: `
+testEnvironment:
+  tlsMode: insecure
+`,
+			expectErr: true,
+		},
+		{
+			name: "invalid host alias",
+			data: `
+testEnvironment:
+  hostAliases:
+  - ip: observatorium.hub
+`,
+			expectErr: true,
+		},
+		{
+			name: "user workload metrics",
+			data: `
+userWorkloadMetrics:
+  enabled: true
+`,
+		},
+		{
+			name: "invalid user workload metrics url",
+			data: `
+userWorkloadMetrics:
+  enabled: true
+  serverUrl: prometheus-user-workload:9091
+`,
+			expectErr: true,
+		},
+		{
+			name: "alert forwarding",
+			data: `
+alertForwarding:
+  enabled: true
+  dropAlertNames:
+  - Watchdog
+  dropSeverities:
+  - info
+`,
+		},
+		{
+			name: "empty dropped alert name",
+			data: `
+alertForwarding:
+  dropAlertNames:
+  - ""
+`,
+			expectErr: true,
+		},
+		{
+			name: "external labels",
+			data: `
+externalLabels:
+- name: environment
+  value: prod
+- name: region
+  claim: region.open-cluster-management.io
+`,
+		},
+		{
+			name: "reserved external label",
+			data: `
+externalLabels:
+- name: cluster
+  value: other
+`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid yaml",
+			data:      "metricsSource: [",
+			expectErr: true,
+		},
+	}
+	for _, cs := range caseList {
+		t.Run(cs.name, func(t *testing.T) {
+			c := fake.NewFakeClient(newAddonConfigCM(cs.data))
+			config, err := getAddonConfig(ctx, c, testNamespace)
+			if cs.expectErr {
+				if err == nil {
+					t.Fatal("Miss the error for invalid addon config")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to get addon config: (%v)", err)
+			}
+			if cs.serverURL != "" && (config.MetricsSource == nil || config.MetricsSource.ServerURL != cs.serverURL) {
+				t.Fatalf("Wrong metrics source: (%v)", config.MetricsSource)
+			}
+		})
+	}
+}
+
+func TestGetMetricsSource(t *testing.T) {
+	if getMetricsSource(nil) != nil || getMetricsSource(&AddonConfig{}) != nil {
+		t.Fatal("Metrics source should be nil by default")
+	}
+	config := &AddonConfig{
+		MetricsSource: &MetricsSource{
+			ServerURL:         "https://thanos-querier:9091",
+			BearerTokenSecret: "token-secret",
+		},
+		TestEnvironment: &TestEnvironment{
+			MetricsSourceURL: "http://thanos-querier:9090",
+		},
+	}
+	source := getMetricsSource(config)
+	if source.ServerURL != "http://thanos-querier:9090" || source.BearerTokenSecret != "token-secret" {
+		t.Fatalf("Test environment not applied to metrics source: (%v)", source)
+	}
+	if config.MetricsSource.ServerURL != "https://thanos-querier:9091" {
+		t.Fatal("Configured metrics source changed by the test environment")
+	}
+}
+
+func TestGetUserWorkloadSourceURL(t *testing.T) {
+	caseList := []struct {
+		name     string
+		config   *AddonConfig
+		platform string
+		expected string
+	}{
+		{
+			name:     "default",
+			config:   &AddonConfig{},
+			platform: platformOpenShift,
+		},
+		{
+			name:     "disabled",
+			config:   &AddonConfig{UserWorkloadMetrics: &UserWorkloadMetrics{ServerURL: "https://uwl:9091"}},
+			platform: platformOpenShift,
+		},
+		{
+			name:     "enabled",
+			config:   &AddonConfig{UserWorkloadMetrics: &UserWorkloadMetrics{Enabled: true}},
+			platform: platformOpenShift,
+			expected: uwlPromURL,
+		},
+		{
+			name:     "custom url",
+			config:   &AddonConfig{UserWorkloadMetrics: &UserWorkloadMetrics{Enabled: true, ServerURL: "https://uwl:9091"}},
+			platform: platformOpenShift,
+			expected: "https://uwl:9091",
+		},
+		{
+			name:     "kubernetes",
+			config:   &AddonConfig{UserWorkloadMetrics: &UserWorkloadMetrics{Enabled: true}},
+			platform: platformKubernetes,
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			if url := getUserWorkloadSourceURL(c.config, c.platform); url != c.expected {
+				t.Fatalf("Wrong user workload source url, expected: %q, actual: %q", c.expected, url)
+			}
+		})
+	}
+}
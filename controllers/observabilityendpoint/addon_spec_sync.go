@@ -0,0 +1,66 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+)
+
+// hubSyncPeriod is the period to check the observabilityaddon in the hub again, as it's not watched
+const hubSyncPeriod = time.Minute
+
+// syncLocalAddon creates or updates the local observabilityaddon with the spec of the hub one,
+// so that the spec changes made in the hub take effect without the external sync agent
+func syncLocalAddon(ctx context.Context, c client.Client, ns string, hubObsAddon *oav1beta1.ObservabilityAddon,
+	obsAddon *oav1beta1.ObservabilityAddon) (*oav1beta1.ObservabilityAddon, error) {
+	if obsAddon == nil {
+		obsAddon = &oav1beta1.ObservabilityAddon{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      obAddonName,
+				Namespace: ns,
+			},
+			Spec: *hubObsAddon.Spec.DeepCopy(),
+		}
+		err := c.Create(ctx, obsAddon)
+		if err != nil {
+			log.Error(err, "Failed to create the local observabilityaddon", "namespace", ns)
+			return nil, err
+		}
+		log.Info("Created the local observabilityaddon from the hub one", "namespace", ns)
+		return obsAddon, nil
+	}
+
+	if equality.Semantic.DeepEqual(obsAddon.Spec, hubObsAddon.Spec) {
+		return obsAddon, nil
+	}
+	obsAddon.Spec = *hubObsAddon.Spec.DeepCopy()
+	err := c.Update(ctx, obsAddon)
+	if err != nil {
+		log.Error(err, "Failed to update the local observabilityaddon", "namespace", ns)
+		return nil, err
+	}
+	log.Info("Updated the local observabilityaddon with the hub spec", "namespace", ns)
+	return obsAddon, nil
+}
+
+// deleteLocalAddon deletes the local observabilityaddon once the hub one is deleted
+func deleteLocalAddon(ctx context.Context, c client.Client, ns string, obsAddon *oav1beta1.ObservabilityAddon) error {
+	if obsAddon == nil {
+		return nil
+	}
+	err := c.Delete(ctx, obsAddon)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete the local observabilityaddon", "namespace", ns)
+		return err
+	}
+	log.Info("Deleted the local observabilityaddon", "namespace", ns)
+	return nil
+}
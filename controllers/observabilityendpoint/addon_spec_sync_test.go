@@ -0,0 +1,77 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+)
+
+func TestSyncLocalAddon(t *testing.T) {
+	ctx := context.TODO()
+	hubObsAddon := newObservabilityAddon(name, testHubNamspace)
+	hubObsAddon.Spec.Interval = 120
+	c := fake.NewFakeClient()
+
+	// the local observabilityaddon is created if it doesn't exist
+	obsAddon, err := syncLocalAddon(ctx, c, testNamespace, hubObsAddon, nil)
+	if err != nil {
+		t.Fatalf("Failed to create the local observabilityaddon: (%v)", err)
+	}
+	found := &oav1beta1.ObservabilityAddon{}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, found)
+	if err != nil {
+		t.Fatalf("Local observabilityaddon not created: (%v)", err)
+	}
+	if found.Spec.Interval != 120 {
+		t.Fatalf("Hub spec not mirrored: (%v)", found.Spec)
+	}
+
+	// the local observabilityaddon is updated if the hub spec changes
+	hubObsAddon.Spec.EnableMetrics = false
+	resourceVersion := found.ResourceVersion
+	obsAddon, err = syncLocalAddon(ctx, c, testNamespace, hubObsAddon, obsAddon)
+	if err != nil {
+		t.Fatalf("Failed to update the local observabilityaddon: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, found)
+	if err != nil {
+		t.Fatalf("Local observabilityaddon not found: (%v)", err)
+	}
+	if found.Spec.EnableMetrics || found.ResourceVersion == resourceVersion {
+		t.Fatalf("Hub spec change not mirrored: (%v)", found.Spec)
+	}
+
+	// no update if the spec is the same
+	resourceVersion = found.ResourceVersion
+	_, err = syncLocalAddon(ctx, c, testNamespace, hubObsAddon, obsAddon)
+	if err != nil {
+		t.Fatalf("Failed to sync the local observabilityaddon: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, found)
+	if err != nil {
+		t.Fatalf("Local observabilityaddon not found: (%v)", err)
+	}
+	if found.ResourceVersion != resourceVersion {
+		t.Fatal("Local observabilityaddon updated without spec change")
+	}
+
+	err = deleteLocalAddon(ctx, c, testNamespace, found)
+	if err != nil {
+		t.Fatalf("Failed to delete the local observabilityaddon: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, found)
+	if !errors.IsNotFound(err) {
+		t.Fatal("Local observabilityaddon not deleted")
+	}
+	err = deleteLocalAddon(ctx, c, testNamespace, found)
+	if err != nil {
+		t.Fatalf("Run into error when try to delete the local observabilityaddon twice: (%v)", err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// alertRelabelConfigName is the name of the AlertRelabelConfig which drops the alerts filtered by the addon config
+const alertRelabelConfigName = "observability-alert-forwarding-filters"
+
+// alertRelabelConfigGVK is the OpenShift API which adds alert relabel configs to the platform Prometheus,
+// it's served since OpenShift 4.12
+var alertRelabelConfigGVK = schema.GroupVersionKind{
+	Group:   "monitoring.openshift.io",
+	Version: "v1",
+	Kind:    "AlertRelabelConfig",
+}
+
+// errAlertFiltersNotSupported is returned when alert filters are configured and the AlertRelabelConfig API is
+// not served, the alerts are still forwarded without the filters
+var errAlertFiltersNotSupported = errors.New(
+	"the alert filters require the AlertRelabelConfig API of OpenShift 4.12 or later")
+
+// isAlertForwardingEnabled returns true unless the alert forwarding is disabled in the addon config
+func isAlertForwardingEnabled(config *AddonConfig) bool {
+	return config == nil || config.AlertForwarding == nil || config.AlertForwarding.Enabled == nil ||
+		*config.AlertForwarding.Enabled
+}
+
+// getAlertRelabelConfigs returns the relabel configs which drop the alerts filtered by the addon config,
+// nil means that all the alerts are forwarded
+func getAlertRelabelConfigs(config *AddonConfig) []interface{} {
+	if config == nil || config.AlertForwarding == nil {
+		return nil
+	}
+	var relabelConfigs []interface{}
+	for _, filter := range []struct {
+		label  string
+		values []string
+	}{
+		{"alertname", config.AlertForwarding.DropAlertNames},
+		{"severity", config.AlertForwarding.DropSeverities},
+		{"namespace", config.AlertForwarding.DropNamespaces},
+	} {
+		if len(filter.values) == 0 {
+			continue
+		}
+		// the values are matched literally, the regex is anchored by Prometheus
+		quoted := []string{}
+		for _, v := range filter.values {
+			quoted = append(quoted, regexp.QuoteMeta(v))
+		}
+		relabelConfigs = append(relabelConfigs, map[string]interface{}{
+			"sourceLabels": []interface{}{filter.label},
+			"regex":        "(" + strings.Join(quoted, "|") + ")",
+			"action":       "Drop",
+		})
+	}
+	return relabelConfigs
+}
+
+// isAlertRelabelConfigServed returns true if the AlertRelabelConfig API is served in the cluster
+func isAlertRelabelConfigServed(c client.Client) (bool, error) {
+	_, err := c.RESTMapper().RESTMapping(alertRelabelConfigGVK.GroupKind(), alertRelabelConfigGVK.Version)
+	if err == nil {
+		return true, nil
+	}
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	log.Error(err, "Failed to discover the API", "kind", alertRelabelConfigGVK.Kind)
+	return false, err
+}
+
+// createOrUpdateAlertRelabelConfig applies the alert filters of the addon config with an AlertRelabelConfig in
+// the namespace of the platform Prometheus, which is deleted when there is no filter. The dropped alerts don't
+// reach any Alertmanager, the one of the managed cluster included, and the alerts of the user workloads are
+// not filtered.
+func createOrUpdateAlertRelabelConfig(ctx context.Context, c client.Client, config *AddonConfig) error {
+	relabelConfigs := getAlertRelabelConfigs(config)
+	if len(relabelConfigs) == 0 {
+		return deleteAlertRelabelConfig(ctx, c)
+	}
+	served, err := isAlertRelabelConfigServed(c)
+	if err != nil {
+		return err
+	}
+	if !served {
+		return errAlertFiltersNotSupported
+	}
+
+	found := &unstructured.Unstructured{}
+	found.SetGroupVersionKind(alertRelabelConfigGVK)
+	err = c.Get(ctx, types.NamespacedName{Name: alertRelabelConfigName, Namespace: promNamespace}, found)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get the alert relabel config", "name", alertRelabelConfigName)
+		return err
+	}
+	if err != nil {
+		arc := &unstructured.Unstructured{}
+		arc.SetGroupVersionKind(alertRelabelConfigGVK)
+		arc.SetName(alertRelabelConfigName)
+		arc.SetNamespace(promNamespace)
+		if err := unstructured.SetNestedSlice(arc.Object, relabelConfigs, "spec", "configs"); err != nil {
+			return err
+		}
+		if err := c.Create(ctx, arc); err != nil {
+			log.Error(err, "Failed to create the alert relabel config", "name", alertRelabelConfigName)
+			return err
+		}
+		log.Info("Created the alert relabel config", "name", alertRelabelConfigName)
+		return nil
+	}
+
+	configs, _, _ := unstructured.NestedSlice(found.Object, "spec", "configs")
+	if reflect.DeepEqual(configs, relabelConfigs) {
+		return nil
+	}
+	if err := unstructured.SetNestedSlice(found.Object, relabelConfigs, "spec", "configs"); err != nil {
+		return err
+	}
+	if err := c.Update(ctx, found); err != nil {
+		log.Error(err, "Failed to update the alert relabel config", "name", alertRelabelConfigName)
+		return err
+	}
+	log.Info("Updated the alert relabel config", "name", alertRelabelConfigName)
+	return nil
+}
+
+// deleteAlertRelabelConfig deletes the AlertRelabelConfig of the alert filters if it exists
+func deleteAlertRelabelConfig(ctx context.Context, c client.Client) error {
+	served, err := isAlertRelabelConfigServed(c)
+	if err != nil || !served {
+		return err
+	}
+	arc := &unstructured.Unstructured{}
+	arc.SetGroupVersionKind(alertRelabelConfigGVK)
+	arc.SetName(alertRelabelConfigName)
+	arc.SetNamespace(promNamespace)
+	err = c.Delete(ctx, arc)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to delete the alert relabel config", "name", alertRelabelConfigName)
+		return err
+	}
+	if err == nil {
+		log.Info("Deleted the alert relabel config", "name", alertRelabelConfigName)
+	}
+	return nil
+}
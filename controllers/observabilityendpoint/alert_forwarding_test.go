@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIsAlertForwardingEnabled(t *testing.T) {
+	enabled := true
+	disabled := false
+	caseList := []struct {
+		name     string
+		config   *AddonConfig
+		expected bool
+	}{
+		{
+			name:     "no addon config",
+			expected: true,
+		},
+		{
+			name:     "no alert forwarding section",
+			config:   &AddonConfig{},
+			expected: true,
+		},
+		{
+			name:     "enabled",
+			config:   &AddonConfig{AlertForwarding: &AlertForwarding{Enabled: &enabled}},
+			expected: true,
+		},
+		{
+			name:     "disabled",
+			config:   &AddonConfig{AlertForwarding: &AlertForwarding{Enabled: &disabled}},
+			expected: false,
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			if isAlertForwardingEnabled(c.config) != c.expected {
+				t.Fatalf("Wrong alert forwarding switch, expected: %v", c.expected)
+			}
+		})
+	}
+}
+
+func TestGetAlertRelabelConfigs(t *testing.T) {
+	if relabelConfigs := getAlertRelabelConfigs(&AddonConfig{AlertForwarding: &AlertForwarding{}}); relabelConfigs != nil {
+		t.Fatalf("Relabel configs returned without filters: %v", relabelConfigs)
+	}
+	config := &AddonConfig{AlertForwarding: &AlertForwarding{
+		DropAlertNames: []string{"Watchdog", "KubeCPUOvercommit"},
+		DropNamespaces: []string{"openshift-marketplace.*"},
+	}}
+	expected := []interface{}{
+		map[string]interface{}{
+			"sourceLabels": []interface{}{"alertname"},
+			"regex":        "(Watchdog|KubeCPUOvercommit)",
+			"action":       "Drop",
+		},
+		// the values are matched literally
+		map[string]interface{}{
+			"sourceLabels": []interface{}{"namespace"},
+			"regex":        `(openshift-marketplace\.\*)`,
+			"action":       "Drop",
+		},
+	}
+	if relabelConfigs := getAlertRelabelConfigs(config); !reflect.DeepEqual(relabelConfigs, expected) {
+		t.Fatalf("Wrong relabel configs, expected: %v, actual: %v", expected, relabelConfigs)
+	}
+}
+
+func TestCreateOrUpdateAlertRelabelConfig(t *testing.T) {
+	ctx := context.TODO()
+	c := &discoveryClient{Client: fake.NewFakeClient(), openShift: true}
+	getConfigs := func() ([]interface{}, error) {
+		arc := &unstructured.Unstructured{}
+		arc.SetGroupVersionKind(alertRelabelConfigGVK)
+		err := c.Get(ctx, types.NamespacedName{Name: alertRelabelConfigName, Namespace: promNamespace}, arc)
+		if err != nil {
+			return nil, err
+		}
+		configs, _, err := unstructured.NestedSlice(arc.Object, "spec", "configs")
+		return configs, err
+	}
+
+	config := &AddonConfig{AlertForwarding: &AlertForwarding{DropAlertNames: []string{"Watchdog"}}}
+	if err := createOrUpdateAlertRelabelConfig(ctx, c, config); err != nil {
+		t.Fatalf("Failed to create the alert relabel config: (%v)", err)
+	}
+	configs, err := getConfigs()
+	if err != nil || !reflect.DeepEqual(configs, getAlertRelabelConfigs(config)) {
+		t.Fatalf("Wrong alert relabel config: %v (%v)", configs, err)
+	}
+
+	config.AlertForwarding.DropSeverities = []string{"info"}
+	if err := createOrUpdateAlertRelabelConfig(ctx, c, config); err != nil {
+		t.Fatalf("Failed to update the alert relabel config: (%v)", err)
+	}
+	configs, err = getConfigs()
+	if err != nil || len(configs) != 2 {
+		t.Fatalf("Alert relabel config not updated: %v (%v)", configs, err)
+	}
+
+	// the alert relabel config is deleted once there is no filter
+	if err := createOrUpdateAlertRelabelConfig(ctx, c, &AddonConfig{}); err != nil {
+		t.Fatalf("Failed to delete the alert relabel config: (%v)", err)
+	}
+	if _, err := getConfigs(); !errors.IsNotFound(err) {
+		t.Fatalf("Alert relabel config not deleted: (%v)", err)
+	}
+
+	// the filters are reported as not supported before OpenShift 4.12
+	c.openShift = false
+	if err := createOrUpdateAlertRelabelConfig(ctx, c, config); err != errAlertFiltersNotSupported {
+		t.Fatalf("Miss the error for the AlertRelabelConfig API not served: (%v)", err)
+	}
+	if err := deleteAlertRelabelConfig(ctx, c); err != nil {
+		t.Fatalf("Failed to skip the deletion without the AlertRelabelConfig API: (%v)", err)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// externalLabelsAnnotation lists the external labels set by the operator in the monitoring config,
+// the other external labels are configured by the customer and never overwritten
+const externalLabelsAnnotation = "observability.open-cluster-management.io/external-labels"
+
+// clusterClaimGVK is the kind of the cluster claims of the managed cluster, which are reported in the
+// ManagedCluster status in the hub
+var clusterClaimGVK = schema.GroupVersionKind{
+	Group:   "cluster.open-cluster-management.io",
+	Version: "v1alpha1",
+	Kind:    "ClusterClaim",
+}
+
+// reservedLabels are the labels set by the operator itself, they cannot be configured as external labels
+var reservedLabels = map[string]bool{
+	clusterLabelKeyForAlerts: true,
+	"clusterID":              true,
+	"clusterType":            true,
+	sourceLabelKey:           true,
+}
+
+// ExternalLabel is a label added to the forwarded alerts and metrics, its value is either set or read from
+// a cluster claim
+type ExternalLabel struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value,omitempty"`
+	// Claim is the name of the ClusterClaim which has the value, e.g. region.open-cluster-management.io
+	Claim string `yaml:"claim,omitempty"`
+}
+
+func validateExternalLabels(labels []ExternalLabel) error {
+	names := map[string]bool{}
+	for _, l := range labels {
+		if !model.LabelName(l.Name).IsValid() || strings.HasPrefix(l.Name, "__") {
+			return fmt.Errorf("invalid externalLabels name %q", l.Name)
+		}
+		if reservedLabels[l.Name] {
+			return fmt.Errorf("invalid externalLabels name %q: the label is set by the operator", l.Name)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("invalid externalLabels name %q: duplicated", l.Name)
+		}
+		names[l.Name] = true
+		if (l.Value == "") == (l.Claim == "") {
+			return fmt.Errorf("invalid externalLabels %q: either value or claim is required", l.Name)
+		}
+	}
+	return nil
+}
+
+// getExternalLabels returns the external labels of the addon config with the values of the cluster claims,
+// the labels of the missing claims are skipped
+func getExternalLabels(ctx context.Context, c client.Client, config *AddonConfig) (map[string]string, error) {
+	labels := map[string]string{}
+	if config == nil {
+		return labels, nil
+	}
+	for _, l := range config.ExternalLabels {
+		if l.Claim == "" {
+			labels[l.Name] = l.Value
+			continue
+		}
+		claim := &unstructured.Unstructured{}
+		claim.SetGroupVersionKind(clusterClaimGVK)
+		err := c.Get(ctx, types.NamespacedName{Name: l.Claim}, claim)
+		if err != nil {
+			// the cluster claims are not available in the clusters which are not managed by the hub
+			if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				log.Info("Cluster claim not found, skip the external label", "claim", l.Claim, "label", l.Name)
+				continue
+			}
+			log.Error(err, "Failed to get the cluster claim", "claim", l.Claim)
+			return nil, err
+		}
+		value, _, _ := unstructured.NestedString(claim.Object, "spec", "value")
+		if value == "" {
+			log.Info("Cluster claim has no value, skip the external label", "claim", l.Claim, "label", l.Name)
+			continue
+		}
+		labels[l.Name] = value
+	}
+	return labels, nil
+}
+
+// mergeExternalLabels sets the external labels in the ones found in the monitoring config. The labels owned by
+// the operator are updated or removed, the ones configured by the customer are kept and returned as conflicts.
+// It returns the merged labels, the labels now owned by the operator and the conflicts.
+func mergeExternalLabels(found map[string]string, labels map[string]string, owned []string) (map[string]string,
+	[]string, []string) {
+	ownedSet := map[string]bool{}
+	for _, k := range owned {
+		ownedSet[k] = true
+	}
+	merged := map[string]string{}
+	for k, v := range found {
+		if _, ok := labels[k]; !ok && ownedSet[k] {
+			// the label is not configured anymore
+			continue
+		}
+		merged[k] = v
+	}
+	newOwned := []string{}
+	conflicts := []string{}
+	for k, v := range labels {
+		if existing, ok := found[k]; ok && !ownedSet[k] {
+			if existing != v {
+				conflicts = append(conflicts, k)
+			}
+			continue
+		}
+		merged[k] = v
+		newOwned = append(newOwned, k)
+	}
+	sort.Strings(newOwned)
+	sort.Strings(conflicts)
+	return merged, newOwned, conflicts
+}
+
+// getOwnedExternalLabels returns the external labels set by the operator from the annotation
+func getOwnedExternalLabels(annotations map[string]string) []string {
+	if annotations[externalLabelsAnnotation] == "" {
+		return nil
+	}
+	return strings.Split(annotations[externalLabelsAnnotation], ",")
+}
+
+// setOwnedExternalLabels records the external labels set by the operator in the annotations
+func setOwnedExternalLabels(obj client.Object, owned []string) {
+	annotations := obj.GetAnnotations()
+	if len(owned) == 0 {
+		if _, ok := annotations[externalLabelsAnnotation]; ok {
+			delete(annotations, externalLabelsAnnotation)
+			obj.SetAnnotations(annotations)
+		}
+		return
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[externalLabelsAnnotation] = strings.Join(owned, ",")
+	obj.SetAnnotations(annotations)
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	yamltool "github.com/ghodss/yaml"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/open-cluster-management/multicluster-observability-operator/api/shared"
+	cmomanifests "github.com/openshift/cluster-monitoring-operator/pkg/manifests"
+)
+
+func newClusterClaim(name string, value string) *unstructured.Unstructured {
+	claim := &unstructured.Unstructured{}
+	claim.SetGroupVersionKind(clusterClaimGVK)
+	claim.SetName(name)
+	_ = unstructured.SetNestedField(claim.Object, value, "spec", "value")
+	return claim
+}
+
+func TestValidateExternalLabels(t *testing.T) {
+	caseList := []struct {
+		name   string
+		labels []ExternalLabel
+		valid  bool
+	}{
+		{
+			name: "valid",
+			labels: []ExternalLabel{
+				{Name: "environment", Value: "prod"},
+				{Name: "region", Claim: "region.open-cluster-management.io"},
+			},
+			valid: true,
+		},
+		{
+			name:   "invalid name",
+			labels: []ExternalLabel{{Name: "business-unit", Value: "finance"}},
+		},
+		{
+			name:   "internal name",
+			labels: []ExternalLabel{{Name: "__name__", Value: "up"}},
+		},
+		{
+			name:   "reserved name",
+			labels: []ExternalLabel{{Name: "cluster", Value: "other"}},
+		},
+		{
+			name:   "duplicated name",
+			labels: []ExternalLabel{{Name: "region", Value: "us"}, {Name: "region", Value: "eu"}},
+		},
+		{
+			name:   "value and claim",
+			labels: []ExternalLabel{{Name: "region", Value: "us", Claim: "region.open-cluster-management.io"}},
+		},
+		{
+			name:   "no value",
+			labels: []ExternalLabel{{Name: "region"}},
+		},
+	}
+
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateExternalLabels(c.labels)
+			if (err == nil) != c.valid {
+				t.Fatalf("Wrong validation result, expected valid: %v, actual: %v", c.valid, err)
+			}
+		})
+	}
+}
+
+func TestGetExternalLabels(t *testing.T) {
+	config := &AddonConfig{ExternalLabels: []ExternalLabel{
+		{Name: "environment", Value: "prod"},
+		{Name: "region", Claim: "region.open-cluster-management.io"},
+		{Name: "cloud_provider", Claim: "platform.open-cluster-management.io"},
+		{Name: "business_unit", Claim: "missing.open-cluster-management.io"},
+	}}
+	c := fake.NewFakeClient([]runtime.Object{
+		newClusterClaim("region.open-cluster-management.io", "us-east-1"),
+		newClusterClaim("platform.open-cluster-management.io", "AWS"),
+	}...)
+
+	labels, err := getExternalLabels(context.TODO(), c, config)
+	if err != nil {
+		t.Fatalf("Failed to get the external labels: (%v)", err)
+	}
+	expected := map[string]string{"environment": "prod", "region": "us-east-1", "cloud_provider": "AWS"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Wrong external labels, expected: %v, actual: %v", expected, labels)
+	}
+
+	labels, err = getExternalLabels(context.TODO(), c, nil)
+	if err != nil || len(labels) != 0 {
+		t.Fatalf("Wrong external labels without addon config: %v (%v)", labels, err)
+	}
+}
+
+func TestMergeExternalLabels(t *testing.T) {
+	found := map[string]string{"cluster": "c1", "region": "eu", "environment": "dev", "team": "a"}
+	labels := map[string]string{"region": "us", "environment": "prod", "tier": "1"}
+
+	// region is owned by the operator, environment was configured by the customer, team is not configured anymore
+	merged, owned, conflicts := mergeExternalLabels(found, labels, []string{"region", "team"})
+	expected := map[string]string{"cluster": "c1", "region": "us", "environment": "dev", "tier": "1"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Wrong merged labels, expected: %v, actual: %v", expected, merged)
+	}
+	if !reflect.DeepEqual(owned, []string{"region", "tier"}) {
+		t.Fatalf("Wrong owned labels: %v", owned)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"environment"}) {
+		t.Fatalf("Wrong conflicts: %v", conflicts)
+	}
+
+	// the same value configured by the customer is not a conflict, and is still not owned
+	_, owned, conflicts = mergeExternalLabels(map[string]string{"environment": "prod"},
+		map[string]string{"environment": "prod"}, nil)
+	if len(owned) != 0 || len(conflicts) != 0 {
+		t.Fatalf("Wrong owned labels: %v or conflicts: %v", owned, conflicts)
+	}
+}
+
+func getPrometheusK8sExternalLabels(t *testing.T, cm *corev1.ConfigMap) map[string]string {
+	config := &cmomanifests.ClusterMonitoringConfiguration{}
+	err := yamltool.Unmarshal([]byte(cm.Data[clusterMonitoringConfigDataKey]), config)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if config.PrometheusK8sConfig == nil {
+		return nil
+	}
+	return config.PrometheusK8sConfig.ExternalLabels
+}
+
+func TestExternalLabelsClusterMonitoringConfig(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	ctx := context.TODO()
+	c := fake.NewFakeClient(newAMAccessorSecret(), newClusterMonitoringConfigCM(`
+prometheusK8s:
+  externalLabels:
+    environment: staging
+    datacenter: dc1`))
+	getCM := func() *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+		if err != nil {
+			t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+		}
+		return cm
+	}
+
+	conflicts, _, err := createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID,
+		map[string]string{"environment": "prod", "region": "us-east-1"}, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"environment"}) {
+		t.Fatalf("Wrong conflicts: %v", conflicts)
+	}
+	cm := getCM()
+	expected := map[string]string{"cluster": testClusterID, "environment": "staging", "datacenter": "dc1",
+		"region": "us-east-1"}
+	if labels := getPrometheusK8sExternalLabels(t, cm); !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Wrong external labels, expected: %v, actual: %v", expected, labels)
+	}
+	if cm.Annotations[externalLabelsAnnotation] != "region" {
+		t.Fatalf("Wrong owned external labels: %q", cm.Annotations[externalLabelsAnnotation])
+	}
+
+	// the labels removed from the addon config are removed from the config
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	cm = getCM()
+	if _, ok := getPrometheusK8sExternalLabels(t, cm)["region"]; ok {
+		t.Fatalf("The removed external label is kept: %v", getPrometheusK8sExternalLabels(t, cm))
+	}
+	if _, ok := cm.Annotations[externalLabelsAnnotation]; ok {
+		t.Fatalf("The owned external labels annotation is kept")
+	}
+
+	// only the labels set by the operator are reverted
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID,
+		map[string]string{"region": "us-east-1"}, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = revertClusterMonitoringConfig(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to revert the cluster-monitoring-config configmap: (%v)", err)
+	}
+	cm = getCM()
+	expected = map[string]string{"environment": "staging", "datacenter": "dc1"}
+	if labels := getPrometheusK8sExternalLabels(t, cm); !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Wrong reverted external labels, expected: %v, actual: %v", expected, labels)
+	}
+	if _, ok := cm.Annotations[externalLabelsAnnotation]; ok {
+		t.Fatalf("The owned external labels annotation is kept after revert")
+	}
+}
+
+func TestExternalLabelsDeployment(t *testing.T) {
+	hubInfo := HubInfo{ClusterName: "test-cluster", Endpoint: "https://hub/receive"}
+	deployment := createDeployment(newTestConfig(), testClusterID, "", platformOpenShift, oashared.ObservabilityAddonSpec{}, hubInfo,
+		nil, nil, map[string]string{"region": "us-east-1"}, MetricsAllowlist{}, "hash", 1)
+	command := strings.Join(deployment.Spec.Template.Spec.Containers[0].Command, " ")
+	if !strings.Contains(command, `--label="region=us-east-1"`) {
+		t.Fatalf("The external label is not added to the metrics: %s", command)
+	}
+}
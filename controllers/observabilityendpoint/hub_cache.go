@@ -0,0 +1,153 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"encoding/json"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+)
+
+const (
+	hubCacheName     = "observability-hub-cache"
+	hubCacheAddonKey = "observabilityaddon.json"
+	hubCacheInfoKey  = "hub-info.yaml"
+)
+
+// cacheHubAddon records the last known hub observabilityaddon, which is used while the hub is unreachable
+func cacheHubAddon(ctx context.Context, c client.Client, ns string, hubObsAddon *oav1beta1.ObservabilityAddon) error {
+	cached := &oav1beta1.ObservabilityAddon{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       hubObsAddon.Name,
+			Namespace:  hubObsAddon.Namespace,
+			Finalizers: hubObsAddon.Finalizers,
+		},
+		Spec: hubObsAddon.Spec,
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		log.Error(err, "Failed to marshal the hub observabilityaddon")
+		return err
+	}
+	return updateHubCache(ctx, c, ns, hubCacheAddonKey, string(data))
+}
+
+// getCachedHubAddon returns the last known hub observabilityaddon, nil is returned if there is none
+func getCachedHubAddon(ctx context.Context, c client.Client, ns string) (*oav1beta1.ObservabilityAddon, error) {
+	data, err := getHubCache(ctx, c, ns, hubCacheAddonKey)
+	if err != nil || data == "" {
+		return nil, err
+	}
+	hubObsAddon := &oav1beta1.ObservabilityAddon{}
+	err = json.Unmarshal([]byte(data), hubObsAddon)
+	if err != nil {
+		log.Error(err, "Failed to unmarshal the cached hub observabilityaddon")
+		return nil, err
+	}
+	return hubObsAddon, nil
+}
+
+// cacheHubInfo records the last known hub info, which is used if the hub info secret is not available
+func cacheHubInfo(ctx context.Context, c client.Client, ns string, hubInfo *HubInfo) error {
+	data, err := yaml.Marshal(hubInfo)
+	if err != nil {
+		log.Error(err, "Failed to marshal the hub info")
+		return err
+	}
+	return updateHubCache(ctx, c, ns, hubCacheInfoKey, string(data))
+}
+
+// getCachedHubInfo returns the last known hub info, nil is returned if there is none
+func getCachedHubInfo(ctx context.Context, c client.Client, ns string) (*HubInfo, error) {
+	data, err := getHubCache(ctx, c, ns, hubCacheInfoKey)
+	if err != nil || data == "" {
+		return nil, err
+	}
+	hubInfo := &HubInfo{}
+	err = yaml.Unmarshal([]byte(data), hubInfo)
+	if err != nil {
+		log.Error(err, "Failed to unmarshal the cached hub info")
+		return nil, err
+	}
+	return hubInfo, nil
+}
+
+func getHubCache(ctx context.Context, c client.Client, ns string, key string) (string, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: hubCacheName, Namespace: ns}, cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		log.Error(err, "Failed to get the hub cache configmap")
+		return "", err
+	}
+	return cm.Data[key], nil
+}
+
+func updateHubCache(ctx context.Context, c client.Client, ns string, key string, value string) error {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: hubCacheName, Namespace: ns}, cm)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "Failed to get the hub cache configmap")
+			return err
+		}
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      hubCacheName,
+				Namespace: ns,
+				Annotations: map[string]string{
+					ownerLabelKey: ownerLabelValue,
+				},
+			},
+			Data: map[string]string{key: value},
+		}
+		err = c.Create(ctx, cm)
+		if err != nil {
+			log.Error(err, "Failed to create the hub cache configmap")
+			return err
+		}
+		return nil
+	}
+	if cm.Data[key] == value {
+		return nil
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[key] = value
+	err = c.Update(ctx, cm)
+	if err != nil {
+		log.Error(err, "Failed to update the hub cache configmap")
+		return err
+	}
+	return nil
+}
+
+func deleteHubCache(ctx context.Context, c client.Client, ns string) error {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: hubCacheName, Namespace: ns}, cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		log.Error(err, "Failed to check the hub cache configmap")
+		return err
+	}
+	err = c.Delete(ctx, cm)
+	if err != nil {
+		log.Error(err, "Failed to delete the hub cache configmap")
+		return err
+	}
+	log.Info("hub cache configmap deleted")
+	return nil
+}
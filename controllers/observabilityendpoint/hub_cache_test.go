@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestHubCache(t *testing.T) {
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+
+	// nothing is returned before the cache is written
+	cachedAddon, err := getCachedHubAddon(ctx, c, testNamespace)
+	if err != nil || cachedAddon != nil {
+		t.Fatalf("Unexpected cached hub observabilityaddon: %v (%v)", cachedAddon, err)
+	}
+	cachedInfo, err := getCachedHubInfo(ctx, c, testNamespace)
+	if err != nil || cachedInfo != nil {
+		t.Fatalf("Unexpected cached hub info: %v (%v)", cachedInfo, err)
+	}
+
+	hubObsAddon := newObservabilityAddon(name, testHubNamspace)
+	hubObsAddon.Finalizers = []string{obsAddonFinalizer}
+	hubObsAddon.ResourceVersion = "10"
+	hubObsAddon.Spec.EnableMetrics = true
+	hubObsAddon.Spec.Interval = 60
+	err = cacheHubAddon(ctx, c, testNamespace, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to cache the hub observabilityaddon: (%v)", err)
+	}
+	hubInfo := &HubInfo{
+		ClusterName:          "test-cluster",
+		Endpoint:             "http://test-endpoint",
+		AlertmanagerEndpoint: "http://test-alertmanager-endpoint",
+	}
+	err = cacheHubInfo(ctx, c, testNamespace, hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to cache the hub info: (%v)", err)
+	}
+
+	cachedAddon, err = getCachedHubAddon(ctx, c, testNamespace)
+	if err != nil || cachedAddon == nil {
+		t.Fatalf("Failed to get the cached hub observabilityaddon: (%v)", err)
+	}
+	if cachedAddon.Name != name || cachedAddon.Namespace != testHubNamspace ||
+		!contains(cachedAddon.Finalizers, obsAddonFinalizer) || !equality.Semantic.DeepEqual(cachedAddon.Spec, hubObsAddon.Spec) {
+		t.Fatalf("Wrong cached hub observabilityaddon: %+v", cachedAddon)
+	}
+	if cachedAddon.ResourceVersion != "" {
+		t.Fatalf("Resource version cached: %s", cachedAddon.ResourceVersion)
+	}
+	cachedInfo, err = getCachedHubInfo(ctx, c, testNamespace)
+	if err != nil || cachedInfo == nil || *cachedInfo != *hubInfo {
+		t.Fatalf("Wrong cached hub info: %v (%v)", cachedInfo, err)
+	}
+
+	// the cache is updated with the new spec
+	hubObsAddon.Spec.EnableMetrics = false
+	err = cacheHubAddon(ctx, c, testNamespace, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to update the cached hub observabilityaddon: (%v)", err)
+	}
+	cachedAddon, err = getCachedHubAddon(ctx, c, testNamespace)
+	if err != nil || cachedAddon.Spec.EnableMetrics {
+		t.Fatalf("Cached hub observabilityaddon not updated: %+v (%v)", cachedAddon, err)
+	}
+
+	err = deleteHubCache(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to delete the hub cache: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: hubCacheName, Namespace: testNamespace}, &corev1.ConfigMap{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Hub cache configmap not deleted: (%v)", err)
+	}
+	err = deleteHubCache(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to delete the deleted hub cache: (%v)", err)
+	}
+}
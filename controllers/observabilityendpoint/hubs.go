@@ -0,0 +1,196 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/util"
+	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+	cmomanifests "github.com/openshift/cluster-monitoring-operator/pkg/manifests"
+)
+
+const (
+	additionalHubLabelKey   = "observability.open-cluster-management.io/additional-hub"
+	additionalHubLabelValue = "true"
+	additionalHubCertKey    = "tls.crt"
+	additionalHubKeyKey     = "tls.key"
+	additionalHubCAKey      = "ca.crt"
+	additionalHubAmTokenKey = "alertmanager-token"
+	// hubCollectorNamePrefix is the prefix of the name of the metrics collector deployment of an additional hub
+	hubCollectorNamePrefix = "metrics-collector-hub-"
+	// hubCollectorSelectorValue is the component label of the metrics collector pods of the additional hubs
+	hubCollectorSelectorValue = "additional-hub-metrics-collector"
+	// hubNameLabelKey is the label of the metrics collector deployment and pods of an additional hub, its value
+	// is the name of the hub
+	hubNameLabelKey = "observability.open-cluster-management.io/hub-name"
+)
+
+// additionalHub is a hub the metrics and alerts are forwarded to besides the hub of the addon, e.g. a DR hub.
+// It's configured by a secret with the additional hub label, which has the hub info, the client certificate
+// to push the metrics and the token to access the Alertmanager of the hub.
+type additionalHub struct {
+	// Name is the name of the secret
+	Name string
+	Info HubInfo
+	// AmToken is the token to access the Alertmanager of the hub
+	AmToken string
+	// CertHash is the hash of the certificates, the metrics collector is restarted when they're rotated
+	CertHash string
+}
+
+// getAdditionalHubs returns the additional hubs sorted by name, and the errors of the invalid hub secrets
+// which are skipped
+func getAdditionalHubs(ctx context.Context, c client.Client, ns string) ([]additionalHub, map[string]error, error) {
+	secretList := &corev1.SecretList{}
+	err := c.List(ctx, secretList, client.InNamespace(ns),
+		client.MatchingLabels{additionalHubLabelKey: additionalHubLabelValue})
+	if err != nil {
+		log.Error(err, "Failed to list the additional hub secrets")
+		return nil, nil, err
+	}
+	hubs := []additionalHub{}
+	invalid := map[string]error{}
+	for _, secret := range secretList.Items {
+		hub, err := parseAdditionalHub(secret)
+		if err != nil {
+			log.Error(err, "Invalid additional hub secret", "name", secret.Name)
+			invalid[secret.Name] = err
+			continue
+		}
+		hubs = append(hubs, *hub)
+	}
+	sort.Slice(hubs, func(i, j int) bool { return hubs[i].Name < hubs[j].Name })
+	return hubs, invalid, nil
+}
+
+func parseAdditionalHub(secret corev1.Secret) (*additionalHub, error) {
+	// the name is used in the name and the labels of the metrics collector deployment of the hub
+	if errs := validation.IsDNS1123Label(hubCollectorNamePrefix + secret.Name); len(errs) != 0 {
+		return nil, fmt.Errorf("invalid name: %s", strings.Join(errs, ", "))
+	}
+	hub := &additionalHub{Name: secret.Name}
+	err := yaml.Unmarshal(secret.Data[hubInfoKey], &hub.Info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %v", hubInfoKey, err)
+	}
+	if hub.Info.Endpoint == "" {
+		return nil, fmt.Errorf("no endpoint in %s", hubInfoKey)
+	}
+	hash := sha256.New()
+	for _, key := range []string{additionalHubCertKey, additionalHubKeyKey, additionalHubCAKey} {
+		if len(secret.Data[key]) == 0 {
+			return nil, fmt.Errorf("no %s", key)
+		}
+		hash.Write(secret.Data[key])
+	}
+	hub.CertHash = fmt.Sprintf("%x", hash.Sum(nil))
+	if hub.Info.AlertmanagerEndpoint != "" {
+		hub.AmToken = string(secret.Data[additionalHubAmTokenKey])
+		if hub.AmToken == "" {
+			return nil, fmt.Errorf("no %s for the alertmanager endpoint", additionalHubAmTokenKey)
+		}
+	}
+	return hub, nil
+}
+
+// amRouterCASecretName is the name of the secret with the CA of the Alertmanager route of the hub
+func (h *additionalHub) amRouterCASecretName() string {
+	return hubAmRouterCASecretName + "-" + h.Name
+}
+
+// amAccessorSecretName is the name of the secret with the token to access the Alertmanager of the hub
+func (h *additionalHub) amAccessorSecretName() string {
+	return hubAmAccessorSecretName + "-" + h.Name
+}
+
+// collectorName is the name of the metrics collector deployment which pushes the metrics to the hub
+func (h *additionalHub) collectorName() string {
+	return hubCollectorNamePrefix + h.Name
+}
+
+// collectorPodLabels are the labels of the metrics collector pods which push the metrics to the hub
+func (h *additionalHub) collectorPodLabels() map[string]string {
+	return map[string]string{
+		selectorKey:     hubCollectorSelectorValue,
+		hubNameLabelKey: h.Name,
+	}
+}
+
+// isAdditionalHubAmRouterCASecret checks if the secret is the CA of the Alertmanager route of an additional hub
+func isAdditionalHubAmRouterCASecret(name string) bool {
+	return strings.HasPrefix(name, hubAmRouterCASecretName+"-")
+}
+
+// getAdditionalHubAlertmanagerConfigs returns the alertmanager configs of the additional hubs which have
+// an Alertmanager endpoint
+func getAdditionalHubAlertmanagerConfigs(hubs []additionalHub) []cmomanifests.AdditionalAlertmanagerConfig {
+	configs := []cmomanifests.AdditionalAlertmanagerConfig{}
+	for _, hub := range hubs {
+		if hub.Info.AlertmanagerEndpoint != "" {
+			configs = append(configs, newAlertmanagerConfig(hub.Info.AlertmanagerEndpoint,
+				hub.amRouterCASecretName(), hub.amAccessorSecretName()))
+		}
+	}
+	return configs
+}
+
+// createAdditionalHubAmSecrets creates or updates the secrets with the CA of the Alertmanager route and the
+// access token of each additional hub in the namespace
+func createAdditionalHubAmSecrets(ctx context.Context, c client.Client, hubs []additionalHub, ns string) error {
+	for _, hub := range hubs {
+		if hub.Info.AlertmanagerEndpoint == "" {
+			continue
+		}
+		err := createOrUpdateSecret(ctx, c, hub.amRouterCASecretName(), ns,
+			map[string][]byte{hubAmRouterCASecretKey: []byte(hub.Info.AlertmanagerRouterCA)})
+		if err != nil {
+			return err
+		}
+		err = createOrUpdateSecret(ctx, c, hub.amAccessorSecretName(), ns,
+			map[string][]byte{hubAmAccessorSecretKey: []byte(hub.AmToken)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setHubConditions sets the forwarding condition of each additional hub, and removes the ones of the
+// hubs which are gone
+func setHubConditions(obsAddon *oav1beta1.ObservabilityAddon, hubs []additionalHub, invalid map[string]error,
+	getCondition func(hub additionalHub) oav1beta1.StatusCondition) {
+	types := map[string]bool{}
+	for _, hub := range hubs {
+		condition := getCondition(hub)
+		condition.Type = util.HubConditionType(hub.Name)
+		util.SetStatusCondition(&obsAddon.Status.Conditions, condition)
+		types[condition.Type] = true
+	}
+	for name, err := range invalid {
+		util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+			Type:    util.HubConditionType(name),
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidHubInfo",
+			Message: "Additional hub secret is invalid: " + err.Error(),
+		})
+		types[util.HubConditionType(name)] = true
+	}
+	conditions := []oav1beta1.StatusCondition{}
+	for _, c := range obsAddon.Status.Conditions {
+		if !util.IsHubConditionType(c.Type) || types[c.Type] {
+			conditions = append(conditions, c)
+		}
+	}
+	obsAddon.Status.Conditions = conditions
+}
@@ -0,0 +1,353 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/util"
+	oashared "github.com/open-cluster-management/multicluster-observability-operator/api/shared"
+	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+)
+
+func newAdditionalHubSecret(name string, hubInfo string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			Labels:    map[string]string{additionalHubLabelKey: additionalHubLabelValue},
+		},
+		Data: map[string][]byte{
+			hubInfoKey:              []byte(hubInfo),
+			additionalHubCertKey:    []byte("cert-" + name),
+			additionalHubKeyKey:     []byte("key-" + name),
+			additionalHubCAKey:      []byte("ca-" + name),
+			additionalHubAmTokenKey: []byte("token-" + name),
+		},
+	}
+}
+
+func TestGetAdditionalHubs(t *testing.T) {
+	noEndpoint := newAdditionalHubSecret("hub-no-endpoint", "cluster-name: test-cluster")
+	noCert := newAdditionalHubSecret("hub-no-cert", "endpoint: https://hub-c/api/metrics/v1/default/api/v1/receive")
+	delete(noCert.Data, additionalHubCertKey)
+	noToken := newAdditionalHubSecret("hub-no-token", hubInfoYAML)
+	delete(noToken.Data, additionalHubAmTokenKey)
+	unlabeled := newAdditionalHubSecret("hub-unlabeled", hubInfoYAML)
+	unlabeled.Labels = nil
+	c := fake.NewFakeClient(
+		newAdditionalHubSecret("hub-b", "endpoint: https://hub-b/api/metrics/v1/default/api/v1/receive"),
+		newAdditionalHubSecret("hub-a", hubInfoYAML),
+		newAdditionalHubSecret(strings.Repeat("a", 50), hubInfoYAML),
+		noEndpoint, noCert, noToken, unlabeled,
+	)
+
+	hubs, invalid, err := getAdditionalHubs(context.TODO(), c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to get the additional hubs: (%v)", err)
+	}
+	if len(hubs) != 2 || hubs[0].Name != "hub-a" || hubs[1].Name != "hub-b" {
+		t.Fatalf("Wrong additional hubs: %+v", hubs)
+	}
+	if hubs[0].AmToken != "token-hub-a" || hubs[0].Info.AlertmanagerEndpoint == "" || hubs[0].CertHash == "" {
+		t.Fatalf("Wrong additional hub: %+v", hubs[0])
+	}
+	if hubs[1].Info.AlertmanagerEndpoint != "" || hubs[1].AmToken != "" {
+		t.Fatalf("Alertmanager of the additional hub without alertmanager endpoint: %+v", hubs[1])
+	}
+	for _, name := range []string{strings.Repeat("a", 50), "hub-no-endpoint", "hub-no-cert", "hub-no-token"} {
+		if invalid[name] == nil {
+			t.Fatalf("Invalid hub %s not reported: %v", name, invalid)
+		}
+	}
+	if len(invalid) != 4 {
+		t.Fatalf("Wrong invalid hubs: %v", invalid)
+	}
+}
+
+func TestAdditionalHubsDeployment(t *testing.T) {
+	hubInfo := HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	hub := &additionalHub{Name: "hub-a", Info: HubInfo{ClusterName: "cluster-a", Endpoint: "https://hub-a/receive"}}
+	addonConfig := &AddonConfig{UserWorkloadMetrics: &UserWorkloadMetrics{Enabled: true}}
+	deployment := createDeployment(newTestConfig(), testClusterID, "", platformOpenShift, oashared.ObservabilityAddonSpec{},
+		hubInfo, hub, addonConfig, nil, MetricsAllowlist{}, "hash", 1)
+
+	if deployment.Name != "metrics-collector-hub-hub-a" || deployment.Labels[hubNameLabelKey] != "hub-a" ||
+		deployment.Labels[additionalHubLabelKey] != additionalHubLabelValue {
+		t.Fatalf("Wrong metadata of the additional hub deployment: %+v", deployment.ObjectMeta)
+	}
+	if !reflect.DeepEqual(deployment.Spec.Selector.MatchLabels, hub.collectorPodLabels()) ||
+		deployment.Spec.Template.Labels[hubNameLabelKey] != "hub-a" ||
+		deployment.Spec.Template.Labels[selectorKey] != hubCollectorSelectorValue {
+		t.Fatalf("Wrong selector of the additional hub deployment: %v, %v", deployment.Spec.Selector,
+			deployment.Spec.Template.Labels)
+	}
+	// the ports are the same as the ones of the hub of the addon, they don't depend on the other hubs
+	containers := deployment.Spec.Template.Spec.Containers
+	expected := []struct {
+		name string
+		port int32
+	}{
+		{"metrics-collector", collectorMetricsPort},
+		{uwlCollectorName, uwlCollectorMetricsPort},
+	}
+	if len(containers) != len(expected) {
+		t.Fatalf("Wrong number of containers: %d", len(containers))
+	}
+	for i, e := range expected {
+		container := containers[i]
+		if container.Name != e.name || container.Ports[0].ContainerPort != e.port {
+			t.Fatalf("Wrong container %d, expected: %s on %d, actual: %s on %d", i, e.name, e.port,
+				container.Name, container.Ports[0].ContainerPort)
+		}
+		for _, env := range container.Env {
+			if env.Name == "TO" && env.Value != hub.Info.Endpoint {
+				t.Fatalf("Wrong TO of container %s: %s", container.Name, env.Value)
+			}
+		}
+	}
+	if !strings.Contains(strings.Join(containers[0].Command, " "), `--label="cluster=cluster-a"`) {
+		t.Fatalf("Wrong cluster label of the additional hub: %v", containers[0].Command)
+	}
+
+	volumes := map[string]string{}
+	for _, v := range deployment.Spec.Template.Spec.Volumes {
+		if v.Secret != nil {
+			volumes[v.Name] = v.Secret.SecretName
+		}
+	}
+	if volumes["mtlscerts"] != "hub-a" || volumes["mtlsca"] != "hub-a" {
+		t.Fatalf("Wrong volumes of the additional hub: %v", volumes)
+	}
+
+	// the deployment of the hub of the addon is not changed by the additional hubs
+	primary := createDeployment(newTestConfig(), testClusterID, "", platformOpenShift, oashared.ObservabilityAddonSpec{},
+		hubInfo, nil, addonConfig, nil, MetricsAllowlist{}, "hash", 1)
+	if primary.Name != metricsCollectorName || len(primary.Labels) != 0 ||
+		len(primary.Spec.Template.Spec.Containers) != len(expected) {
+		t.Fatalf("Wrong deployment of the hub of the addon: %+v", primary)
+	}
+}
+
+func TestAdditionalHubCollectors(t *testing.T) {
+	hubInfo := HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	hubs := []additionalHub{
+		{Name: "hub-a", Info: HubInfo{Endpoint: "https://hub-a/receive"}, CertHash: "a"},
+		{Name: "hub-b", Info: HubInfo{Endpoint: "https://hub-b/receive"}, CertHash: "b"},
+	}
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+	update := func(hubs []additionalHub) {
+		_, err := updateMetricsCollector(ctx, c, newTestConfig(), oashared.ObservabilityAddonSpec{}, hubInfo, hubs,
+			nil, nil, MetricsAllowlist{}, testClusterID, "", platformOpenShift, 1, false)
+		if err != nil {
+			t.Fatalf("Failed to update the metrics collector: (%v)", err)
+		}
+	}
+	getDeployments := func() map[string]*appsv1.Deployment {
+		deployList := &appsv1.DeploymentList{}
+		err := c.List(ctx, deployList)
+		if err != nil {
+			t.Fatalf("Failed to list the deployments: (%v)", err)
+		}
+		deployments := map[string]*appsv1.Deployment{}
+		for i := range deployList.Items {
+			deployments[deployList.Items[i].Name] = &deployList.Items[i]
+		}
+		return deployments
+	}
+
+	update(hubs)
+	deployments := getDeployments()
+	if len(deployments) != 3 || deployments[metricsCollectorName] == nil || deployments[hubs[0].collectorName()] == nil ||
+		deployments[hubs[1].collectorName()] == nil {
+		t.Fatalf("Wrong deployments: %v", deployments)
+	}
+	resourceVersions := map[string]string{}
+	for name, deploy := range deployments {
+		resourceVersions[name] = deploy.ResourceVersion
+	}
+
+	// the rotated certificates of a hub only restart its own collector
+	hubs[1].CertHash = "b2"
+	update(hubs)
+	deployments = getDeployments()
+	for name, deploy := range deployments {
+		if changed := deploy.ResourceVersion != resourceVersions[name]; changed != (name == hubs[1].collectorName()) {
+			t.Fatalf("Wrong update of deployment %s: %v", name, changed)
+		}
+	}
+
+	// the deployment of the removed hub is deleted
+	update(hubs[1:])
+	deployments = getDeployments()
+	if len(deployments) != 2 || deployments[hubs[0].collectorName()] != nil {
+		t.Fatalf("Deployment of the removed hub not deleted: %v", deployments)
+	}
+
+	err = deleteMetricsCollector(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to delete the metrics collector: (%v)", err)
+	}
+	if deployments = getDeployments(); len(deployments) != 0 {
+		t.Fatalf("Deployments not deleted: %v", deployments)
+	}
+}
+
+func TestAdditionalHubsAlertmanager(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	hubs := []additionalHub{
+		{Name: "hub-a", Info: HubInfo{Endpoint: "https://hub-a/receive",
+			AlertmanagerEndpoint: "https://alertmanager-hub-a", AlertmanagerRouterCA: "ca-hub-a"}, AmToken: "token-hub-a"},
+		{Name: "hub-b", Info: HubInfo{Endpoint: "https://hub-b/receive"}},
+	}
+	ctx := context.TODO()
+	c := fake.NewFakeClient(newAMAccessorSecret(), newUWMNamespace(), newClusterMonitoringConfigCM(`
+prometheusK8s:
+  retention: 1d`))
+
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, hubs, testClusterID, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = createOrUpdateUWMConfig(ctx, hubInfo, hubs, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the user-workload-monitoring-config configmap: (%v)", err)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	config := cm.Data[clusterMonitoringConfigDataKey]
+	if !strings.Contains(config, hubAmRouterCASecretName+"-hub-a") || !strings.Contains(config, "alertmanager-hub-a") ||
+		strings.Contains(config, "-hub-b") {
+		t.Fatalf("Wrong alertmanager configs in cluster-monitoring-config:\n%s", config)
+	}
+	if countHubAlertmanagerConfigs(getUWMConfig(t, c), uwmPrometheusKey) != 2 {
+		t.Fatalf("Wrong alertmanager configs in user-workload-monitoring-config: %v", getUWMConfig(t, c))
+	}
+	for _, ns := range []string{promNamespace, uwmNamespace} {
+		secret := &corev1.Secret{}
+		err = c.Get(ctx, types.NamespacedName{Name: hubAmAccessorSecretName + "-hub-a", Namespace: ns}, secret)
+		if err != nil || string(secret.Data[hubAmAccessorSecretKey]) != "token-hub-a" {
+			t.Fatalf("Wrong access token secret of the additional hub in %s: %v (%v)", ns, secret.Data, err)
+		}
+	}
+
+	// the alertmanager config and the secrets of the removed hub are removed
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, hubs[1:], testClusterID, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = createOrUpdateUWMConfig(ctx, hubInfo, hubs[1:], c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the user-workload-monitoring-config configmap: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	config = cm.Data[clusterMonitoringConfigDataKey]
+	if strings.Contains(config, "-hub-a") || !strings.Contains(config, hubAmRouterCASecretName) ||
+		!strings.Contains(config, "retention") {
+		t.Fatalf("Wrong alertmanager configs in cluster-monitoring-config:\n%s", config)
+	}
+	if countHubAlertmanagerConfigs(getUWMConfig(t, c), uwmThanosRulerKey) != 1 {
+		t.Fatalf("Wrong alertmanager configs in user-workload-monitoring-config: %v", getUWMConfig(t, c))
+	}
+	for _, ns := range []string{promNamespace, uwmNamespace} {
+		for _, name := range []string{hubAmRouterCASecretName + "-hub-a", hubAmAccessorSecretName + "-hub-a"} {
+			err = c.Get(ctx, types.NamespacedName{Name: name, Namespace: ns}, &corev1.Secret{})
+			if !errors.IsNotFound(err) {
+				t.Fatalf("Secret %s of the removed hub not deleted in %s: (%v)", name, ns, err)
+			}
+		}
+	}
+
+	// the alertmanager configs of the additional hubs are reverted with the one of the hub
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, hubs, testClusterID, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = revertClusterMonitoringConfig(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to revert the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if strings.Contains(cm.Data[clusterMonitoringConfigDataKey], "staticConfigs") {
+		t.Fatalf("Alertmanager configs not reverted:\n%s", cm.Data[clusterMonitoringConfigDataKey])
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: hubAmRouterCASecretName + "-hub-a", Namespace: promNamespace},
+		&corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Secret of the additional hub not deleted: (%v)", err)
+	}
+}
+
+func TestSetHubConditions(t *testing.T) {
+	obsAddon := newObservabilityAddon(name, testNamespace)
+	obsAddon.Status.Conditions = []oav1beta1.StatusCondition{
+		{Type: util.ConditionMetricsForwarding, Status: metav1.ConditionTrue},
+		{Type: util.HubConditionType("hub-removed"), Status: metav1.ConditionTrue},
+	}
+	hubs := []additionalHub{{Name: "hub-a"}, {Name: "hub-b"}}
+	now := time.Now()
+	setHubConditions(obsAddon, hubs, map[string]error{"hub-invalid": errors.NewBadRequest("no endpoint")},
+		func(hub additionalHub) oav1beta1.StatusCondition {
+			if hub.Name == "hub-b" {
+				previous := &collectorObservation{stats: collectorStats{Samples: 10}, observed: now, started: now}
+				return getForwardingCondition(&collectorStats{Samples: 10, Failures: 1}, nil, previous, now)
+			}
+			return getForwardingCondition(&collectorStats{Samples: 10}, nil, nil, now)
+		})
+
+	expected := map[string]metav1.ConditionStatus{
+		util.ConditionMetricsForwarding:      metav1.ConditionTrue,
+		util.HubConditionType("hub-a"):       metav1.ConditionTrue,
+		util.HubConditionType("hub-b"):       metav1.ConditionFalse,
+		util.HubConditionType("hub-invalid"): metav1.ConditionFalse,
+	}
+	if len(obsAddon.Status.Conditions) != len(expected) {
+		t.Fatalf("Wrong conditions: %+v", obsAddon.Status.Conditions)
+	}
+	for conditionType, status := range expected {
+		condition := util.FindStatusCondition(obsAddon.Status.Conditions, conditionType)
+		if condition == nil || condition.Status != status {
+			t.Fatalf("Wrong condition %s, expected: %s, actual: %+v", conditionType, status, condition)
+		}
+	}
+
+	// all the conditions of the additional hubs are removed when there is no hub
+	setHubConditions(obsAddon, nil, nil, nil)
+	if len(obsAddon.Status.Conditions) != 1 {
+		t.Fatalf("Conditions of the additional hubs not removed: %+v", obsAddon.Status.Conditions)
+	}
+}
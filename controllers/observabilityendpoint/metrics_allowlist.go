@@ -0,0 +1,425 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	allowlistLabelKey   = "observability.open-cluster-management.io/metrics-allowlist"
+	allowlistLabelValue = "true"
+	// lastValidAllowlistName is the configmap which records the last valid metrics allowlist, it's kept in effect
+	// when an allowlist configmap is invalid
+	lastValidAllowlistName = "observability-last-valid-allowlist"
+)
+
+type MetricsAllowlist struct {
+	NameList     []string              `yaml:"names"`
+	MatchList    []string              `yaml:"matches"`
+	ReNameMap    map[string]string     `yaml:"renames"`
+	RuleList     []Rule                `yaml:"rules"`
+	Denylist     MetricsDenylist       `yaml:"denylist"`
+	UserWorkload UserWorkloadAllowlist `yaml:"userWorkload"`
+}
+
+// UserWorkloadAllowlist is the struct for the series collected from the user workload monitoring stack,
+// the denylist is applied to them as well
+type UserWorkloadAllowlist struct {
+	NameList  []string `yaml:"names"`
+	MatchList []string `yaml:"matches"`
+}
+
+// MetricsDenylist is the struct for the series excluded from the allowlist.
+// Each match in the denylist must be a single label matcher.
+type MetricsDenylist struct {
+	NameList  []string `yaml:"names"`
+	MatchList []string `yaml:"matches"`
+}
+
+// Rule is the struct for recording rules and alert rules
+type Rule struct {
+	Record string `yaml:"record"`
+	Expr   string `yaml:"expr"`
+}
+
+// allowlistSource is the allowlist parsed from one configmap
+type allowlistSource struct {
+	name string
+	list MetricsAllowlist
+}
+
+// getMetricsAllowlist returns the allowlist merged from the default allowlist configmap
+// and the custom allowlist configmaps labeled with allowlistLabelKey.
+// If any of them is invalid or cannot be read, the last valid allowlist recorded in the
+// observability-last-valid-allowlist configmap is returned together with the error.
+func getMetricsAllowlist(ctx context.Context, c client.Client, ns string) (MetricsAllowlist, error) {
+	cms := []corev1.ConfigMap{}
+	errs := []error{}
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: metricsConfigMapName,
+		Namespace: ns}, cm)
+	if err != nil {
+		log.Error(err, "Failed to get configmap")
+		errs = append(errs, fmt.Errorf("configmap %s: %v", metricsConfigMapName, err))
+	} else {
+		cms = append(cms, *cm)
+	}
+
+	cmList := &corev1.ConfigMapList{}
+	err = c.List(ctx, cmList, client.InNamespace(ns),
+		client.MatchingLabels{allowlistLabelKey: allowlistLabelValue})
+	if err != nil {
+		log.Error(err, "Failed to list custom allowlist configmaps")
+		errs = append(errs, fmt.Errorf("custom allowlist configmaps: %v", err))
+	} else {
+		// the default allowlist always wins, custom ones are applied in name order
+		sort.Slice(cmList.Items, func(i, j int) bool {
+			return cmList.Items[i].Name < cmList.Items[j].Name
+		})
+		for _, item := range cmList.Items {
+			if item.Name != metricsConfigMapName {
+				cms = append(cms, item)
+			}
+		}
+	}
+
+	sources := []allowlistSource{}
+	for _, cm := range cms {
+		if cm.Data == nil {
+			continue
+		}
+		l := MetricsAllowlist{}
+		err = yaml.Unmarshal([]byte(cm.Data[metricsConfigMapKey]), &l)
+		if err != nil {
+			log.Error(err, "Failed to unmarshal data in configmap", "name", cm.Name)
+			errs = append(errs, fmt.Errorf("configmap %s: %v", cm.Name, err))
+			continue
+		}
+		if err = validateMetricsAllowlist(l); err != nil {
+			log.Error(err, "Invalid metrics allowlist in configmap", "name", cm.Name)
+			errs = append(errs, fmt.Errorf("configmap %s: %v", cm.Name, err))
+			continue
+		}
+		sources = append(sources, allowlistSource{name: cm.Name, list: l})
+	}
+
+	list, conflicts := mergeMetricsAllowlists(sources)
+	for _, conflict := range conflicts {
+		log.Info("Conflict found in metrics allowlist, ignore it", "conflict", conflict)
+	}
+	if len(errs) != 0 {
+		lastValid, err := getLastValidAllowlist(ctx, c, ns)
+		if err == nil && lastValid != nil {
+			log.Info("Keep the last valid metrics allowlist in effect")
+			list = *lastValid
+		}
+		return list, utilerrors.NewAggregate(errs)
+	}
+	// the last valid allowlist is persisted so that it's still in effect after a restart
+	if err = recordLastValidAllowlist(ctx, c, ns, list); err != nil {
+		log.Error(err, "Failed to record the last valid metrics allowlist")
+	}
+	return list, nil
+}
+
+// recordLastValidAllowlist records the allowlist in the observability-last-valid-allowlist configmap
+func recordLastValidAllowlist(ctx context.Context, c client.Client, ns string, list MetricsAllowlist) error {
+	data, err := yaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: lastValidAllowlistName, Namespace: ns}, cm)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      lastValidAllowlistName,
+				Namespace: ns,
+				Annotations: map[string]string{
+					ownerLabelKey: ownerLabelValue,
+				},
+			},
+			Data: map[string]string{metricsConfigMapKey: string(data)},
+		}
+		return c.Create(ctx, cm)
+	}
+	if cm.Data[metricsConfigMapKey] == string(data) {
+		return nil
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[metricsConfigMapKey] = string(data)
+	return c.Update(ctx, cm)
+}
+
+// getLastValidAllowlist returns the last valid allowlist recorded in the observability-last-valid-allowlist
+// configmap, nil is returned if there is none
+func getLastValidAllowlist(ctx context.Context, c client.Client, ns string) (*MetricsAllowlist, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: lastValidAllowlistName, Namespace: ns}, cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		log.Error(err, "Failed to get the last valid metrics allowlist configmap")
+		return nil, err
+	}
+	data := cm.Data[metricsConfigMapKey]
+	if data == "" {
+		return nil, nil
+	}
+	list := &MetricsAllowlist{}
+	err = yaml.Unmarshal([]byte(data), list)
+	if err != nil {
+		log.Error(err, "Failed to unmarshal the last valid metrics allowlist")
+		return nil, err
+	}
+	return list, nil
+}
+
+// deleteLastValidAllowlist deletes the observability-last-valid-allowlist configmap
+func deleteLastValidAllowlist(ctx context.Context, c client.Client, ns string) error {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: lastValidAllowlistName, Namespace: ns}, cm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		log.Error(err, "Failed to check the last valid metrics allowlist configmap")
+		return err
+	}
+	err = c.Delete(ctx, cm)
+	if err != nil {
+		log.Error(err, "Failed to delete the last valid metrics allowlist configmap")
+		return err
+	}
+	log.Info("last valid metrics allowlist configmap deleted")
+	return nil
+}
+
+// validateMetricsAllowlist checks the metric names, label matchers and rule expressions in the allowlist
+func validateMetricsAllowlist(l MetricsAllowlist) error {
+	errs := []error{}
+	for _, name := range l.NameList {
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			errs = append(errs, fmt.Errorf("invalid metric name %q", name))
+		}
+	}
+	for _, match := range l.MatchList {
+		if err := validateMatch(match); err != nil {
+			errs = append(errs, fmt.Errorf("invalid match %q: %v", match, err))
+		}
+	}
+	keys := []string{}
+	for k := range l.ReNameMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := l.ReNameMap[k]
+		if !model.IsValidMetricName(model.LabelValue(k)) {
+			errs = append(errs, fmt.Errorf("invalid metric name %q in renames", k))
+		}
+		if !model.IsValidMetricName(model.LabelValue(v)) {
+			errs = append(errs, fmt.Errorf("invalid rename target %q for %s", v, k))
+		}
+	}
+	for _, rule := range l.RuleList {
+		if !model.IsValidMetricName(model.LabelValue(rule.Record)) {
+			errs = append(errs, fmt.Errorf("invalid record name %q", rule.Record))
+		}
+		if _, err := parser.ParseExpr(rule.Expr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid expr for rule %s: %v", rule.Record, err))
+		}
+	}
+	for _, name := range l.Denylist.NameList {
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			errs = append(errs, fmt.Errorf("invalid metric name %q in denylist", name))
+		}
+	}
+	for _, name := range l.UserWorkload.NameList {
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			errs = append(errs, fmt.Errorf("invalid metric name %q in userWorkload", name))
+		}
+	}
+	for _, match := range l.UserWorkload.MatchList {
+		if err := validateMatch(match); err != nil {
+			errs = append(errs, fmt.Errorf("invalid match %q in userWorkload: %v", match, err))
+		}
+	}
+	for _, match := range l.Denylist.MatchList {
+		if _, err := parseDenyMatch(match); err != nil {
+			errs = append(errs, fmt.Errorf("invalid match %q in denylist: %v", match, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateMatch checks the match is a list of label matchers which selects at least one series
+func validateMatch(match string) error {
+	expr, err := parser.ParseExpr("{" + match + "}")
+	if err != nil {
+		return err
+	}
+	if _, ok := expr.(*parser.VectorSelector); !ok {
+		return fmt.Errorf("not a series selector")
+	}
+	return nil
+}
+
+// parseDenyMatch parses the denylist match which must be a single label matcher
+func parseDenyMatch(match string) (*labels.Matcher, error) {
+	if err := validateMatch(match); err != nil {
+		return nil, err
+	}
+	matchers, err := parser.ParseMetricSelector("{" + match + "}")
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) != 1 {
+		return nil, fmt.Errorf("only one label matcher is allowed")
+	}
+	return matchers[0], nil
+}
+
+// getMatchSelectors returns the series selectors for the names and matches in the allowlist,
+// the series in the denylist are excluded by appending the inverse matchers to the selectors
+func getMatchSelectors(l MetricsAllowlist) []string {
+	deniedNames := map[string]bool{}
+	denyNameMatchers := []string{}
+	for _, name := range l.Denylist.NameList {
+		deniedNames[name] = true
+		denyNameMatchers = append(denyNameMatchers, fmt.Sprintf("__name__!=\"%s\"", name))
+	}
+	denyMatchers := []string{}
+	for _, match := range l.Denylist.MatchList {
+		matcher, err := parseDenyMatch(match)
+		if err != nil {
+			log.Error(err, "Skip invalid match in denylist", "match", match)
+			continue
+		}
+		inverse, err := matcher.Inverse()
+		if err != nil {
+			log.Error(err, "Skip invalid match in denylist", "match", match)
+			continue
+		}
+		denyMatchers = append(denyMatchers, inverse.String())
+	}
+
+	selectors := []string{}
+	for _, name := range l.NameList {
+		if deniedNames[name] {
+			continue
+		}
+		matchers := append([]string{fmt.Sprintf("__name__=\"%s\"", name)}, denyMatchers...)
+		selectors = append(selectors, "{"+strings.Join(matchers, ",")+"}")
+	}
+	for _, match := range l.MatchList {
+		matchers := append([]string{match}, denyNameMatchers...)
+		matchers = append(matchers, denyMatchers...)
+		selectors = append(selectors, "{"+strings.Join(matchers, ",")+"}")
+	}
+	return selectors
+}
+
+// mergeMetricsAllowlists merges the allowlists in order and removes the duplicated entries.
+// A rename or rule which clashes with an earlier one is dropped and reported as conflict.
+func mergeMetricsAllowlists(sources []allowlistSource) (MetricsAllowlist, []string) {
+	merged := MetricsAllowlist{}
+	conflicts := []string{}
+	names := map[string]bool{}
+	matches := map[string]bool{}
+	deniedNames := map[string]bool{}
+	deniedMatches := map[string]bool{}
+	uwlNames := map[string]bool{}
+	uwlMatches := map[string]bool{}
+	renameSources := map[string]string{}
+	rules := map[string]Rule{}
+	ruleSources := map[string]string{}
+
+	for _, source := range sources {
+		for _, name := range source.list.NameList {
+			if !names[name] {
+				names[name] = true
+				merged.NameList = append(merged.NameList, name)
+			}
+		}
+		for _, match := range source.list.MatchList {
+			if !matches[match] {
+				matches[match] = true
+				merged.MatchList = append(merged.MatchList, match)
+			}
+		}
+		for _, name := range source.list.Denylist.NameList {
+			if !deniedNames[name] {
+				deniedNames[name] = true
+				merged.Denylist.NameList = append(merged.Denylist.NameList, name)
+			}
+		}
+		for _, match := range source.list.Denylist.MatchList {
+			if !deniedMatches[match] {
+				deniedMatches[match] = true
+				merged.Denylist.MatchList = append(merged.Denylist.MatchList, match)
+			}
+		}
+		for _, name := range source.list.UserWorkload.NameList {
+			if !uwlNames[name] {
+				uwlNames[name] = true
+				merged.UserWorkload.NameList = append(merged.UserWorkload.NameList, name)
+			}
+		}
+		for _, match := range source.list.UserWorkload.MatchList {
+			if !uwlMatches[match] {
+				uwlMatches[match] = true
+				merged.UserWorkload.MatchList = append(merged.UserWorkload.MatchList, match)
+			}
+		}
+		for k, v := range source.list.ReNameMap {
+			if merged.ReNameMap == nil {
+				merged.ReNameMap = map[string]string{}
+			}
+			existing, ok := merged.ReNameMap[k]
+			if !ok {
+				merged.ReNameMap[k] = v
+				renameSources[k] = source.name
+			} else if existing != v {
+				conflicts = append(conflicts, fmt.Sprintf(
+					"rename %s=%s in configmap %s conflicts with %s=%s in configmap %s",
+					k, v, source.name, k, existing, renameSources[k]))
+			}
+		}
+		for _, rule := range source.list.RuleList {
+			existing, ok := rules[rule.Record]
+			if !ok {
+				rules[rule.Record] = rule
+				ruleSources[rule.Record] = source.name
+				merged.RuleList = append(merged.RuleList, rule)
+			} else if existing.Expr != rule.Expr {
+				conflicts = append(conflicts, fmt.Sprintf(
+					"rule %s in configmap %s conflicts with the one in configmap %s",
+					rule.Record, source.name, ruleSources[rule.Record]))
+			}
+		}
+	}
+	sort.Strings(conflicts)
+	return merged, conflicts
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newCustomAllowlistCM(name string, labeled bool, data string) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			metricsConfigMapKey: data,
+		},
+	}
+	if labeled {
+		cm.ObjectMeta.Labels = map[string]string{allowlistLabelKey: allowlistLabelValue}
+	}
+	return cm
+}
+
+func TestMergeMetricsAllowlists(t *testing.T) {
+	sources := []allowlistSource{
+		{
+			name: "default",
+			list: MetricsAllowlist{
+				NameList:  []string{"a", "b"},
+				MatchList: []string{"c"},
+				ReNameMap: map[string]string{"d": "e"},
+				RuleList:  []Rule{{Record: "f", Expr: "g"}},
+				Denylist:  MetricsDenylist{NameList: []string{"i"}},
+				UserWorkload: UserWorkloadAllowlist{
+					NameList: []string{"u"},
+				},
+			},
+		},
+		{
+			name: "custom",
+			list: MetricsAllowlist{
+				Denylist:  MetricsDenylist{NameList: []string{"i", "j"}, MatchList: []string{"k"}},
+				NameList:  []string{"b", "x"},
+				MatchList: []string{"c", "y"},
+				ReNameMap: map[string]string{"d": "z", "m": "n"},
+				RuleList:  []Rule{{Record: "f", Expr: "h"}, {Record: "r", Expr: "s"}},
+				UserWorkload: UserWorkloadAllowlist{
+					NameList:  []string{"u", "v"},
+					MatchList: []string{"w"},
+				},
+			},
+		},
+	}
+	expected := MetricsAllowlist{
+		NameList:  []string{"a", "b", "x"},
+		MatchList: []string{"c", "y"},
+		ReNameMap: map[string]string{"d": "e", "m": "n"},
+		RuleList:  []Rule{{Record: "f", Expr: "g"}, {Record: "r", Expr: "s"}},
+		Denylist:  MetricsDenylist{NameList: []string{"i", "j"}, MatchList: []string{"k"}},
+		UserWorkload: UserWorkloadAllowlist{
+			NameList:  []string{"u", "v"},
+			MatchList: []string{"w"},
+		},
+	}
+
+	merged, conflicts := mergeMetricsAllowlists(sources)
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Wrong merged allowlist, expected: %v, actual: %v", expected, merged)
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("Expected 2 conflicts, actual: %v", conflicts)
+	}
+}
+
+func TestGetMetricsAllowlist(t *testing.T) {
+	ctx := context.TODO()
+	custom := newCustomAllowlistCM("custom-allowlist", true, `
+names:
+  - a
+  - custom
+`)
+	c := fake.NewFakeClient(
+		getAllowlistCM(),
+		custom,
+		newCustomAllowlistCM("unlabeled-allowlist", false, `
+names:
+  - unlabeled
+`),
+	)
+
+	list, err := getMetricsAllowlist(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to get metrics allowlist: (%v)", err)
+	}
+	expected := []string{"a", "b", "custom"}
+	if !reflect.DeepEqual(list.NameList, expected) {
+		t.Fatalf("Wrong names in allowlist, expected: %v, actual: %v", expected, list.NameList)
+	}
+	if len(list.MatchList) != 1 || len(list.RuleList) != 1 {
+		t.Fatalf("Default allowlist not included: %v", list)
+	}
+
+	// the last valid allowlist is kept if the custom allowlist becomes invalid
+	custom.Data[metricsConfigMapKey] = `
+names:
+  - invalid-name
+`
+	err = c.Update(ctx, custom)
+	if err != nil {
+		t.Fatalf("Failed to update custom allowlist: (%v)", err)
+	}
+	list, err = getMetricsAllowlist(ctx, c, testNamespace)
+	if err == nil {
+		t.Fatal("Invalid allowlist not reported")
+	}
+	if !reflect.DeepEqual(list.NameList, expected) {
+		t.Fatalf("Last valid allowlist not kept, expected: %v, actual: %v", expected, list.NameList)
+	}
+
+	// the last valid allowlist is persisted, and is not overwritten when the default allowlist cannot be read
+	lastValid := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: lastValidAllowlistName, Namespace: testNamespace}, lastValid)
+	if err != nil || !strings.Contains(lastValid.Data[metricsConfigMapKey], "custom") {
+		t.Fatalf("Last valid allowlist not recorded: %v (%v)", lastValid.Data, err)
+	}
+	// the hub cache is not used for the allowlist, so that it's not lost with the hub state
+	err = deleteHubCache(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to delete the hub cache: (%v)", err)
+	}
+	err = c.Delete(ctx, getAllowlistCM())
+	if err != nil {
+		t.Fatalf("Failed to delete the default allowlist: (%v)", err)
+	}
+	list, err = getMetricsAllowlist(ctx, c, testNamespace)
+	if err == nil {
+		t.Fatal("Missing default allowlist not reported")
+	}
+	if !reflect.DeepEqual(list.NameList, expected) {
+		t.Fatalf("Last valid allowlist not kept, expected: %v, actual: %v", expected, list.NameList)
+	}
+
+	custom.Data[metricsConfigMapKey] = "names: {"
+	err = c.Update(ctx, custom)
+	if err != nil {
+		t.Fatalf("Failed to update custom allowlist: (%v)", err)
+	}
+	_, err = getMetricsAllowlist(ctx, c, testNamespace)
+	if err == nil {
+		t.Fatal("Malformed allowlist not reported")
+	}
+}
+
+func TestValidateMetricsAllowlist(t *testing.T) {
+	caseList := []struct {
+		name  string
+		list  MetricsAllowlist
+		valid bool
+	}{
+		{
+			name: "valid allowlist",
+			list: MetricsAllowlist{
+				NameList:  []string{"up", "kube_pod_info"},
+				MatchList: []string{`__name__="workqueue_depth",job=~"apiserver|etcd"`},
+				ReNameMap: map[string]string{"mixin_pod_workload": "namespace_workload_pod:kube_pod_owner:relabel"},
+				RuleList:  []Rule{{Record: "apiserver_request_duration_seconds:histogram_quantile_99", Expr: `histogram_quantile(0.99,sum(rate(apiserver_request_duration_seconds_bucket{job="apiserver"}[5m])) by (le))`}},
+			},
+			valid: true,
+		},
+		{
+			name:  "invalid metric name",
+			list:  MetricsAllowlist{NameList: []string{"kube-pod-info"}},
+			valid: false,
+		},
+		{
+			name:  "invalid match",
+			list:  MetricsAllowlist{MatchList: []string{`__name__=`}},
+			valid: false,
+		},
+		{
+			name:  "empty match",
+			list:  MetricsAllowlist{MatchList: []string{`__name__=""`}},
+			valid: false,
+		},
+		{
+			name:  "match with expression",
+			list:  MetricsAllowlist{MatchList: []string{`__name__="a"} or {__name__="b"`}},
+			valid: false,
+		},
+		{
+			name:  "valid denylist",
+			list:  MetricsAllowlist{Denylist: MetricsDenylist{NameList: []string{"a"}, MatchList: []string{`namespace=~"test-.*"`}}},
+			valid: true,
+		},
+		{
+			name:  "denylist match with multiple matchers",
+			list:  MetricsAllowlist{Denylist: MetricsDenylist{MatchList: []string{`__name__="a",namespace="b"`}}},
+			valid: false,
+		},
+		{
+			name:  "valid user workload allowlist",
+			list:  MetricsAllowlist{UserWorkload: UserWorkloadAllowlist{NameList: []string{"http_requests_total"}, MatchList: []string{`namespace="app"`}}},
+			valid: true,
+		},
+		{
+			name:  "invalid user workload metric name",
+			list:  MetricsAllowlist{UserWorkload: UserWorkloadAllowlist{NameList: []string{"http-requests-total"}}},
+			valid: false,
+		},
+		{
+			name:  "invalid rename target",
+			list:  MetricsAllowlist{ReNameMap: map[string]string{"a": "b c"}},
+			valid: false,
+		},
+		{
+			name:  "invalid rule expr",
+			list:  MetricsAllowlist{RuleList: []Rule{{Record: "a", Expr: "sum(b"}}},
+			valid: false,
+		},
+	}
+
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateMetricsAllowlist(c.list)
+			if c.valid && err != nil {
+				t.Fatalf("Valid allowlist reported as invalid: (%v)", err)
+			}
+			if !c.valid && err == nil {
+				t.Fatal("Invalid allowlist not reported")
+			}
+		})
+	}
+}
+
+func TestGetMatchSelectors(t *testing.T) {
+	l := MetricsAllowlist{
+		NameList:  []string{"a", "b"},
+		MatchList: []string{`__name__=~"etcd_.*"`},
+		Denylist: MetricsDenylist{
+			NameList:  []string{"b", "etcd_debugging"},
+			MatchList: []string{`namespace="noisy"`, `pod=~"test-.*"`},
+		},
+	}
+	expected := []string{
+		`{__name__="a",namespace!="noisy",pod!~"test-.*"}`,
+		`{__name__=~"etcd_.*",__name__!="b",__name__!="etcd_debugging",namespace!="noisy",pod!~"test-.*"}`,
+	}
+	selectors := getMatchSelectors(l)
+	if !reflect.DeepEqual(selectors, expected) {
+		t.Fatalf("Wrong selectors, expected: %v, actual: %v", expected, selectors)
+	}
+	for _, selector := range selectors {
+		if err := validateMatch(selector[1 : len(selector)-1]); err != nil {
+			t.Fatalf("Invalid selector %s: (%v)", selector, err)
+		}
+	}
+}
@@ -4,20 +4,22 @@ package observabilityendpoint
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
-	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
 	oashared "github.com/open-cluster-management/multicluster-observability-operator/api/shared"
 )
 
@@ -38,30 +40,13 @@ const (
 )
 
 const (
-	kindClusterID   = "kind-cluster-id"
-	kindClusterHost = "observatorium.hub"
-	kindClusterIP   = "172.17.0.2"
-	restartLabel    = "cert/time-restarted"
+	restartLabel      = "cert/time-restarted"
+	ocpPromURL        = "https://prometheus-k8s.openshift-monitoring.svc:9091"
+	uwlCollectorName  = "uwl-metrics-collector"
+	sourceLabelKey    = "source"
+	uwlSourceLabelVal = "user-workload"
 )
 
-var (
-	collectorImage = os.Getenv("COLLECTOR_IMAGE")
-	ocpPromURL     = "https://prometheus-k8s.openshift-monitoring.svc:9091"
-)
-
-type MetricsAllowlist struct {
-	NameList  []string          `yaml:"names"`
-	MatchList []string          `yaml:"matches"`
-	ReNameMap map[string]string `yaml:"renames"`
-	RuleList  []Rule            `yaml:"rules"`
-}
-
-// Rule is the struct for recording rules and alert rules
-type Rule struct {
-	Record string `yaml:"record"`
-	Expr   string `yaml:"expr"`
-}
-
 // HubInfo is the struct for hub info
 type HubInfo struct {
 	ClusterName          string `yaml:"cluster-name"`
@@ -70,9 +55,12 @@ type HubInfo struct {
 	AlertmanagerRouterCA string `yaml:"alertmanager-router-ca"`
 }
 
-func createDeployment(clusterID string, clusterType string,
+// createDeployment renders the metrics collector deployment which pushes the metrics to the hub of the addon,
+// or to the additional hub if hub is set, with the client certificate of the hub
+func createDeployment(cfg *config.OperatorConfig, clusterID string, clusterType string, platform string,
 	obsAddonSpec oashared.ObservabilityAddonSpec,
-	hubInfo HubInfo, allowlist MetricsAllowlist, replicaCount int32) *appsv1.Deployment {
+	hubInfo HubInfo, hub *additionalHub, addonConfig *AddonConfig, externalLabels map[string]string,
+	allowlist MetricsAllowlist, configHash string, replicaCount int32) *appsv1.Deployment {
 	interval := fmt.Sprint(obsAddonSpec.Interval) + "s"
 	if fmt.Sprint(obsAddonSpec.Interval) == "" {
 		interval = defaultInterval
@@ -95,6 +83,16 @@ func createDeployment(clusterID string, clusterType string,
 				},
 			},
 		},
+		{
+			Name: collectorConfigVolName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: collectorConfigName,
+					},
+				},
+			},
+		},
 	}
 	mounts := []corev1.VolumeMount{
 		{
@@ -105,9 +103,26 @@ func createDeployment(clusterID string, clusterType string,
 			Name:      "mtlsca",
 			MountPath: "/tlscerts/ca",
 		},
+		{
+			Name:      collectorConfigVolName,
+			MountPath: collectorConfigMountPath,
+		},
+	}
+	name := metricsCollectorName
+	endpoint := hubInfo.Endpoint
+	podLabels := map[string]string{selectorKey: selectorValue}
+	if hub != nil {
+		// the certificates of the additional hub are mounted in place of the ones of the hub of the addon
+		name = hub.collectorName()
+		endpoint = hub.Info.Endpoint
+		podLabels = hub.collectorPodLabels()
+		volumes = append(getAdditionalHubVolumes(*hub), volumes[2:]...)
 	}
 	caFile := caMounthPath + "/service-ca.crt"
-	if clusterID == "" {
+	if platform == platformKubernetes {
+		// no service CA is injected out of OpenShift, the cluster CA is used instead
+		caFile = serviceAccountCAFile
+	} else if clusterID == "" {
 		clusterID = hubInfo.ClusterName
 		// deprecated ca bundle, only used for ocp 3.11 env
 		caFile = "//run/secrets/kubernetes.io/serviceaccount/service-ca.crt"
@@ -128,46 +143,75 @@ func createDeployment(clusterID string, clusterType string,
 		})
 	}
 
+	fromURL := ocpPromURL
+	source := getMetricsSource(addonConfig)
+	if source != nil {
+		fromURL = source.ServerURL
+	}
 	hostAlias := []corev1.HostAlias{}
-	// patch for e2e test using kind cluster
-	if clusterID == kindClusterID {
-		ocpPromURL = "http://prometheus-k8s.openshift-monitoring.svc:9090"
-		hostAlias = append(hostAlias, corev1.HostAlias{
-			IP:        kindClusterIP,
-			Hostnames: []string{kindClusterHost},
-		})
+	if addonConfig != nil && addonConfig.TestEnvironment != nil {
+		for _, alias := range addonConfig.TestEnvironment.HostAliases {
+			hostAlias = append(hostAlias, corev1.HostAlias{
+				IP:        alias.IP,
+				Hostnames: alias.Hostnames,
+			})
+		}
+		if addonConfig.TestEnvironment.TLSMode == tlsModeDisabled {
+			caFile = ""
+		}
 	}
+	sourceArgs, sourceVolumes, sourceMounts := getMetricsSourceRendering(source, caFile)
+	volumes = append(volumes, sourceVolumes...)
+	mounts = append(mounts, sourceMounts...)
 
-	commands := []string{
-		"/usr/bin/metrics-collector",
-		"--from=$(FROM)",
-		"--to-upload=$(TO)",
-		"--from-ca-file=" + caFile,
-		"--from-token-file=/var/run/secrets/kubernetes.io/serviceaccount/token",
-		"--interval=" + interval,
-		"--limit-bytes=" + strconv.Itoa(limitBytes),
-		fmt.Sprintf("--label=\"cluster=%s\"", hubInfo.ClusterName),
-		fmt.Sprintf("--label=\"clusterID=%s\"", clusterID),
+	labels := map[string]string{
+		"cluster":   hubInfo.ClusterName,
+		"clusterID": clusterID,
 	}
 	if clusterType != "" {
-		commands = append(commands, fmt.Sprintf("--label=\"clusterType=%s\"", clusterType))
+		labels["clusterType"] = clusterType
 	}
-	for _, metrics := range allowlist.NameList {
-		commands = append(commands, fmt.Sprintf("--match={__name__=\"%s\"}", metrics))
+	if hub != nil && hub.Info.ClusterName != "" {
+		labels["cluster"] = hub.Info.ClusterName
 	}
-	for _, match := range allowlist.MatchList {
-		commands = append(commands, fmt.Sprintf("--match={%s}", match))
+	// the external labels are the same as the ones added to the alerts
+	for k, v := range externalLabels {
+		labels[k] = v
 	}
-	for k, v := range allowlist.ReNameMap {
-		commands = append(commands, fmt.Sprintf("--rename=\"%s=%s\"", k, v))
+	// keep the rendered lists in canonical order, so that the same input always renders the same deployment
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Name < mounts[j].Name })
+	uwlURL := getUserWorkloadSourceURL(addonConfig, platform)
+	// the user workload Prometheus is in the same cluster as the platform one, and accepts the service account
+	// token and the service CA, so the custom metrics source settings are not applied to it
+	uwlArgs, _, _ := getMetricsSourceRendering(nil, caFile)
+	// the renames and the recording rules only apply to the platform metrics
+	allowlistArgs := getAllowlistArgs(allowlist)
+	uwlLabels := func(labels map[string]string) map[string]string {
+		uwlLabels := map[string]string{sourceLabelKey: uwlSourceLabelVal}
+		for k, v := range labels {
+			uwlLabels[k] = v
+		}
+		return uwlLabels
 	}
-	for _, rule := range allowlist.RuleList {
-		commands = append(commands, fmt.Sprintf("--recordingrule={\"name\":\"%s\",\"query\":\"%s\"}", rule.Record, rule.Expr))
+	containers := []corev1.Container{
+		newCollectorContainer(cfg.CollectorImage, "metrics-collector", collectorMetricsPortName, collectorMetricsPort,
+			fromURL, endpoint, getCollectorCommands(sourceArgs, interval, collectorMatchFileKey, collectorMetricsPort,
+				allowlistArgs, labels),
+			mounts, obsAddonSpec),
 	}
-	return &appsv1.Deployment{
+	if uwlURL != "" {
+		containers = append(containers, newCollectorContainer(cfg.CollectorImage, uwlCollectorName,
+			uwlCollectorMetricsPortName, uwlCollectorMetricsPort, uwlURL, endpoint,
+			getCollectorCommands(uwlArgs, interval, uwlCollectorMatchFileKey, uwlCollectorMetricsPort, nil,
+				uwlLabels(labels)),
+			mounts, obsAddonSpec))
+	}
+
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      metricsCollectorName,
-			Namespace: namespace,
+			Name:      name,
+			Namespace: cfg.Namespace,
 			Annotations: map[string]string{
 				ownerLabelKey: ownerLabelValue,
 			},
@@ -175,90 +219,163 @@ func createDeployment(clusterID string, clusterType string,
 		Spec: appsv1.DeploymentSpec{
 			Replicas: int32Ptr(replicaCount),
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					selectorKey: selectorValue,
-				},
+				MatchLabels: podLabels,
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						selectorKey: selectorValue,
+					Labels: map[string]string{},
+					Annotations: map[string]string{
+						configHashAnnotation: configHash,
 					},
 				},
 				Spec: corev1.PodSpec{
 					HostAliases:        hostAlias,
-					ServiceAccountName: serviceAccountName,
-					Containers: []corev1.Container{
-						{
-							Name:    "metrics-collector",
-							Image:   collectorImage,
-							Command: commands,
-							Env: []corev1.EnvVar{
-								{
-									Name:  "FROM",
-									Value: ocpPromURL,
-								},
-								{
-									Name:  "TO",
-									Value: hubInfo.Endpoint,
-								},
-							},
-							VolumeMounts:    mounts,
-							ImagePullPolicy: corev1.PullAlways,
-							Resources:       obsAddonSpec.Resources,
-						},
-					},
-					Volumes: volumes,
+					ServiceAccountName: cfg.ServiceAccount,
+					Containers:         containers,
+					Volumes:            volumes,
 				},
 			},
 		},
 	}
+	for k, v := range podLabels {
+		deployment.Spec.Template.Labels[k] = v
+	}
+	if hub != nil {
+		deployment.Labels = map[string]string{
+			additionalHubLabelKey: additionalHubLabelValue,
+			hubNameLabelKey:       hub.Name,
+		}
+	}
+	return deployment
 }
 
-func updateMetricsCollector(ctx context.Context, client client.Client, obsAddonSpec oashared.ObservabilityAddonSpec,
-	hubInfo HubInfo, clusterID string, clusterType string,
-	replicaCount int32, forceRestart bool) (bool, error) {
+func updateMetricsCollector(ctx context.Context, client client.Client, cfg *config.OperatorConfig, obsAddonSpec oashared.ObservabilityAddonSpec,
+	hubInfo HubInfo, additionalHubs []additionalHub, addonConfig *AddonConfig, externalLabels map[string]string,
+	allowlist MetricsAllowlist, clusterID string, clusterType string, platform string, replicaCount int32, forceRestart bool) (bool, error) {
+
+	matchFile := renderMatchFile(allowlist)
+	configData := map[string]string{collectorMatchFileKey: matchFile}
+	configHash := getConfigHash(matchFile)
+	if getUserWorkloadSourceURL(addonConfig, platform) != "" {
+		uwlMatchFile := renderUserWorkloadMatchFile(allowlist)
+		configData[uwlCollectorMatchFileKey] = uwlMatchFile
+		configHash = getConfigHash(matchFile + uwlMatchFile)
+	}
+	err := createOrUpdateCollectorConfig(ctx, client, cfg.Namespace, configData)
+	if err != nil {
+		return false, err
+	}
 
-	list := getMetricsAllowlist(ctx, client)
-	deployment := createDeployment(clusterID, clusterType, obsAddonSpec, hubInfo, list, replicaCount)
+	deployment := createDeployment(cfg, clusterID, clusterType, platform, obsAddonSpec, hubInfo, nil, addonConfig,
+		externalLabels, allowlist, configHash, replicaCount)
+	err = createOrUpdateCollectorDeployment(ctx, client, deployment, forceRestart)
+	if err != nil {
+		return false, err
+	}
+	// each additional hub has its own deployment, so that the failures of a hub don't disrupt the others, and
+	// its collectors are restarted when the certificates of the hub are rotated
+	for i := range additionalHubs {
+		hub := &additionalHubs[i]
+		deployment := createDeployment(cfg, clusterID, clusterType, platform, obsAddonSpec, hubInfo, hub, addonConfig,
+			externalLabels, allowlist, getConfigHash(configHash+hub.CertHash), replicaCount)
+		err = createOrUpdateCollectorDeployment(ctx, client, deployment, forceRestart)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = deleteHubCollectors(ctx, client, cfg.Namespace, additionalHubs)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// createOrUpdateCollectorDeployment creates the metrics collector deployment, or updates it when the rendered spec
+// changes or the metrics collector is forced to restart
+func createOrUpdateCollectorDeployment(ctx context.Context, client client.Client, deployment *appsv1.Deployment,
+	forceRestart bool) error {
+	specHash, err := getSpecHash(deployment.Spec)
+	if err != nil {
+		log.Error(err, "Failed to hash the metrics collector deployment spec", "name", deployment.Name)
+		return err
+	}
+	deployment.Annotations[specHashAnnotation] = specHash
 	found := &appsv1.Deployment{}
-	err := client.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
-		Namespace: namespace}, found)
+	err = client.Get(ctx, types.NamespacedName{Name: deployment.Name,
+		Namespace: deployment.Namespace}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = client.Create(ctx, deployment)
 			if err != nil {
-				log.Error(err, "Failed to create metrics-collector deployment")
-				return false, err
+				log.Error(err, "Failed to create metrics collector deployment", "name", deployment.Name)
+				return err
 			}
-			log.Info("Created metrics-collector deployment ")
-		} else {
-			log.Error(err, "Failed to check the metrics-collector deployment")
-			return false, err
+			log.Info("Created metrics collector deployment", "name", deployment.Name)
+			return nil
 		}
-	} else {
-		if !reflect.DeepEqual(deployment.Spec.Template.Spec, found.Spec.Template.Spec) ||
-			!reflect.DeepEqual(deployment.Spec.Replicas, found.Spec.Replicas) ||
-			forceRestart {
-			deployment.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
-			if forceRestart {
-				deployment.Spec.Template.ObjectMeta.Labels[restartLabel] = time.Now().Format("2006-1-2.1504")
-			}
-			err = client.Update(ctx, deployment)
-			if err != nil {
-				log.Error(err, "Failed to update metrics-collector deployment")
-				return false, err
-			}
-			log.Info("Updated metrics-collector deployment ")
+		log.Error(err, "Failed to check the metrics collector deployment", "name", deployment.Name)
+		return err
+	}
+	// the found deployment has the defaulted fields, the removed or emptied fields are detected by the hash
+	// of the rendered spec, and the fields changed by others by comparing the non empty rendered fields
+	if found.Annotations[specHashAnnotation] != specHash ||
+		!equality.Semantic.DeepDerivative(deployment.Spec.Template.Spec, found.Spec.Template.Spec) ||
+		!reflect.DeepEqual(deployment.Spec.Replicas, found.Spec.Replicas) ||
+		forceRestart {
+		deployment.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+		if forceRestart {
+			deployment.Spec.Template.ObjectMeta.Labels[restartLabel] = time.Now().Format("2006-1-2.1504")
+		} else if restarted, ok := found.Spec.Template.ObjectMeta.Labels[restartLabel]; ok {
+			// keep the restart label, otherwise removing it rolls the pod again
+			deployment.Spec.Template.ObjectMeta.Labels[restartLabel] = restarted
 		}
+		err = client.Update(ctx, deployment)
+		if err != nil {
+			log.Error(err, "Failed to update metrics collector deployment", "name", deployment.Name)
+			return err
+		}
+		log.Info("Updated metrics collector deployment", "name", deployment.Name)
 	}
-	return true, nil
+	return nil
 }
 
-func deleteMetricsCollector(ctx context.Context, client client.Client) error {
+// deleteHubCollectors deletes the metrics collector deployments of the additional hubs which are not in hubs
+func deleteHubCollectors(ctx context.Context, c client.Client, ns string, hubs []additionalHub) error {
+	keep := map[string]bool{}
+	for _, hub := range hubs {
+		keep[hub.collectorName()] = true
+	}
+	deployList := &appsv1.DeploymentList{}
+	err := c.List(ctx, deployList, client.InNamespace(ns),
+		client.MatchingLabels{additionalHubLabelKey: additionalHubLabelValue})
+	if err != nil {
+		log.Error(err, "Failed to list the metrics collector deployments of the additional hubs")
+		return err
+	}
+	for i := range deployList.Items {
+		deploy := &deployList.Items[i]
+		if keep[deploy.Name] {
+			continue
+		}
+		err = c.Delete(ctx, deploy)
+		if err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete the metrics collector deployment", "name", deploy.Name)
+			return err
+		}
+		log.Info("Deleted the metrics collector deployment of the removed hub", "name", deploy.Name)
+	}
+	return nil
+}
+
+func deleteMetricsCollector(ctx context.Context, client client.Client, ns string) error {
+	// the metrics collectors of the additional hubs are deleted with the one of the hub
+	err := deleteHubCollectors(ctx, client, ns, nil)
+	if err != nil {
+		return err
+	}
 	found := &appsv1.Deployment{}
-	err := client.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
-		Namespace: namespace}, found)
+	err = client.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
+		Namespace: ns}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("The metrics collector deployment does not exist")
@@ -278,20 +395,106 @@ func deleteMetricsCollector(ctx context.Context, client client.Client) error {
 
 func int32Ptr(i int32) *int32 { return &i }
 
-func getMetricsAllowlist(ctx context.Context, client client.Client) MetricsAllowlist {
-	l := &MetricsAllowlist{}
-	cm := &corev1.ConfigMap{}
-	err := client.Get(ctx, types.NamespacedName{Name: metricsConfigMapName,
-		Namespace: namespace}, cm)
+// getSpecHash returns the hash of the rendered deployment spec
+func getSpecHash(spec appsv1.DeploymentSpec) (string, error) {
+	data, err := json.Marshal(spec)
 	if err != nil {
-		log.Error(err, "Failed to get configmap")
-	} else {
-		if cm.Data != nil {
-			err = yaml.Unmarshal([]byte(cm.Data[metricsConfigMapKey]), l)
-			if err != nil {
-				log.Error(err, "Failed to unmarshal data in configmap")
-			}
-		}
+		return "", err
+	}
+	return getConfigHash(string(data)), nil
+}
+
+// newCollectorContainer returns a metrics collector container which pushes the metrics from the source URL
+// to the hub endpoint
+func newCollectorContainer(image string, name string, portName string, port int, from string, to string, commands []string,
+	mounts []corev1.VolumeMount, obsAddonSpec oashared.ObservabilityAddonSpec) corev1.Container {
+	return corev1.Container{
+		Name:    name,
+		Image:   image,
+		Command: commands,
+		Env: []corev1.EnvVar{
+			{
+				Name:  "FROM",
+				Value: from,
+			},
+			{
+				Name:  "TO",
+				Value: to,
+			},
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          portName,
+				ContainerPort: int32(port),
+			},
+		},
+		VolumeMounts:    mounts,
+		ImagePullPolicy: corev1.PullAlways,
+		Resources:       obsAddonSpec.Resources,
+	}
+}
+
+// getAdditionalHubVolumes returns the volumes of the client certificate and the CA of the additional hub, they
+// have the names of the volumes of the hub of the addon so that they're mounted in the same paths
+func getAdditionalHubVolumes(hub additionalHub) []corev1.Volume {
+	return []corev1.Volume{
+		{
+			Name: "mtlscerts",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: hub.Name,
+					Items: []corev1.KeyToPath{
+						{Key: additionalHubCertKey, Path: additionalHubCertKey},
+						{Key: additionalHubKeyKey, Path: additionalHubKeyKey},
+					},
+				},
+			},
+		},
+		{
+			Name: "mtlsca",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: hub.Name,
+					Items: []corev1.KeyToPath{
+						{Key: additionalHubCAKey, Path: additionalHubCAKey},
+					},
+				},
+			},
+		},
+	}
+}
+
+// getCollectorCommands returns the metrics collector command for a metrics source, the series are selected by
+// the match file with the key in the collector config configmap, and the metrics of the metrics collector itself
+// are served on the listen port
+func getCollectorCommands(sourceArgs []string, interval string, matchFileKey string, listenPort int,
+	allowlistArgs []string, labels map[string]string) []string {
+	commands := []string{
+		"/usr/bin/metrics-collector",
+		"--from=$(FROM)",
+		"--to-upload=$(TO)",
+		"--listen=:" + strconv.Itoa(listenPort),
+	}
+	commands = append(commands, sourceArgs...)
+	commands = append(commands,
+		"--interval="+interval,
+		"--limit-bytes="+strconv.Itoa(limitBytes),
+		"--match-file="+collectorConfigMountPath+"/"+matchFileKey,
+	)
+	commands = append(commands, allowlistArgs...)
+	return append(commands, getLabelArgs(labels)...)
+}
+
+// getLabelArgs returns the --label args sorted by the label name
+func getLabelArgs(labels map[string]string) []string {
+	keys := []string{}
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := []string{}
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("--label=\"%s=%s\"", k, labels[k]))
 	}
-	return *l
+	return args
 }
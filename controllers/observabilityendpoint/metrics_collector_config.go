@@ -0,0 +1,150 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	collectorConfigName      = "metrics-collector-config"
+	collectorMatchFileKey    = "match-file"
+	uwlCollectorMatchFileKey = "uwl-match-file"
+	collectorConfigVolName   = "collector-config"
+	collectorConfigMountPath = "/etc/metrics-collector"
+	configHashAnnotation     = "observability.open-cluster-management.io/config-hash"
+	// specHashAnnotation is the hash of the deployment spec rendered by the operator, the API server defaults the
+	// fields of the found deployment, so the rendered spec is compared through its hash
+	specHashAnnotation = "observability.open-cluster-management.io/spec-hash"
+)
+
+// renderMatchFile renders the file passed to the --match-file flag of the metrics collector, which has one series
+// selector per line. The selectors are sorted so that the same allowlist always renders the same content.
+func renderMatchFile(allowlist MetricsAllowlist) string {
+	matches := getMatchSelectors(allowlist)
+	sort.Strings(matches)
+	if len(matches) == 0 {
+		return ""
+	}
+	return strings.Join(matches, "\n") + "\n"
+}
+
+// renderUserWorkloadMatchFile renders the match file for the user workload metrics
+func renderUserWorkloadMatchFile(allowlist MetricsAllowlist) string {
+	return renderMatchFile(MetricsAllowlist{
+		NameList:  allowlist.UserWorkload.NameList,
+		MatchList: allowlist.UserWorkload.MatchList,
+		Denylist:  allowlist.Denylist,
+	})
+}
+
+// getAllowlistArgs returns the --rename and --recordingrule args of the metrics collector for the allowlist,
+// sorted by the metric name and the rule name. The metrics collector only reads the series selectors from a file,
+// it has no file flag for the renames and the recording rules, so they are still passed as args. Only the
+// selectors, which make up most of the allowlist, are moved out of the pod template.
+func getAllowlistArgs(allowlist MetricsAllowlist) []string {
+	args := []string{}
+	keys := []string{}
+	for k := range allowlist.ReNameMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("--rename=\"%s=%s\"", k, allowlist.ReNameMap[k]))
+	}
+	rules := append([]Rule{}, allowlist.RuleList...)
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Record < rules[j].Record })
+	for _, rule := range rules {
+		data, err := json.Marshal(struct {
+			Name  string `json:"name"`
+			Query string `json:"query"`
+		}{Name: rule.Record, Query: rule.Expr})
+		if err != nil {
+			log.Error(err, "Skip the recording rule which cannot be marshaled", "rule", rule.Record)
+			continue
+		}
+		args = append(args, "--recordingrule="+string(data))
+	}
+	return args
+}
+
+// getConfigHash returns the hash of the config content, which is used to roll the metrics collector
+func getConfigHash(content string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+}
+
+// createOrUpdateCollectorConfig creates or updates the configmap which contains the match files of the collectors
+func createOrUpdateCollectorConfig(ctx context.Context, c client.Client, ns string, data map[string]string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      collectorConfigName,
+			Namespace: ns,
+			Annotations: map[string]string{
+				ownerLabelKey: ownerLabelValue,
+			},
+		},
+		Data: data,
+	}
+
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: collectorConfigName,
+		Namespace: ns}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			err = c.Create(ctx, cm)
+			if err != nil {
+				log.Error(err, "Failed to create the metrics collector config configmap")
+				return err
+			}
+			log.Info("Created the metrics collector config configmap")
+			return nil
+		}
+		log.Error(err, "Failed to check the metrics collector config configmap")
+		return err
+	}
+
+	if reflect.DeepEqual(found.Data, cm.Data) {
+		return nil
+	}
+	cm.ObjectMeta.ResourceVersion = found.ObjectMeta.ResourceVersion
+	err = c.Update(ctx, cm)
+	if err != nil {
+		log.Error(err, "Failed to update the metrics collector config configmap")
+		return err
+	}
+	log.Info("Updated the metrics collector config configmap")
+	return nil
+}
+
+func deleteCollectorConfig(ctx context.Context, c client.Client, ns string) error {
+	found := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Name: collectorConfigName,
+		Namespace: ns}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("The metrics collector config configmap does not exist")
+			return nil
+		}
+		log.Error(err, "Failed to check the metrics collector config configmap")
+		return err
+	}
+	err = c.Delete(ctx, found)
+	if err != nil {
+		log.Error(err, "Failed to delete the metrics collector config configmap")
+		return err
+	}
+	log.Info("metrics collector config configmap deleted")
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	oashared "github.com/open-cluster-management/multicluster-observability-operator/api/shared"
+)
+
+func TestRenderMatchFile(t *testing.T) {
+	list := MetricsAllowlist{
+		NameList:  []string{"b", "a"},
+		MatchList: []string{`__name__="c"`},
+	}
+	reordered := MetricsAllowlist{
+		NameList:  []string{"a", "b"},
+		MatchList: []string{`__name__="c"`},
+	}
+	expected := `{__name__="a"}
+{__name__="b"}
+{__name__="c"}
+`
+
+	matchFile := renderMatchFile(list)
+	if matchFile != expected {
+		t.Fatalf("Wrong match file, expected:\n%s\nactual:\n%s", expected, matchFile)
+	}
+	if getConfigHash(matchFile) != getConfigHash(renderMatchFile(reordered)) {
+		t.Fatal("Config hash changed for the reordered allowlist")
+	}
+}
+
+func TestRenderUserWorkloadMatchFile(t *testing.T) {
+	list := MetricsAllowlist{
+		NameList:  []string{"a"},
+		ReNameMap: map[string]string{"b": "c"},
+		RuleList:  []Rule{{Record: "d", Expr: "e"}},
+		Denylist:  MetricsDenylist{MatchList: []string{`namespace="test"`}},
+		UserWorkload: UserWorkloadAllowlist{
+			NameList:  []string{"g"},
+			MatchList: []string{`namespace="app"`},
+		},
+	}
+	expected := `{__name__="g",namespace!="test"}
+{namespace="app",namespace!="test"}
+`
+
+	matchFile := renderUserWorkloadMatchFile(list)
+	if matchFile != expected {
+		t.Fatalf("Wrong user workload match file, expected:\n%s\nactual:\n%s", expected, matchFile)
+	}
+}
+
+func TestGetAllowlistArgs(t *testing.T) {
+	list := MetricsAllowlist{
+		ReNameMap: map[string]string{"e": "f", "d": "g"},
+		RuleList:  []Rule{{Record: "i", Expr: `sum(up{job="j"})`}, {Record: "h", Expr: "k"}},
+	}
+	expected := []string{
+		`--rename="d=g"`,
+		`--rename="e=f"`,
+		`--recordingrule={"name":"h","query":"k"}`,
+		`--recordingrule={"name":"i","query":"sum(up{job=\"j\"})"}`,
+	}
+	if args := getAllowlistArgs(list); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Wrong allowlist args, expected: %v, actual: %v", expected, args)
+	}
+}
+
+func TestCollectorConfigRollout(t *testing.T) {
+	hubInfo := HubInfo{
+		ClusterName: "test-cluster",
+		Endpoint:    "http://test-endpoint",
+	}
+	obsAddon := oashared.ObservabilityAddonSpec{
+		EnableMetrics: true,
+		Interval:      60,
+	}
+	list := MetricsAllowlist{
+		NameList:  []string{"a", "b"},
+		ReNameMap: map[string]string{"c": "d", "e": "f"},
+	}
+
+	ctx := context.TODO()
+	c := fake.NewFakeClient()
+	_, err := updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, hubInfo, nil, nil, nil, list, testClusterID, "", platformOpenShift, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to create metrics collector deployment: (%v)", err)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorConfigName, Namespace: testNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Collector config configmap not created: (%v)", err)
+	}
+	deploy := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not created: (%v)", err)
+	}
+	if deploy.Spec.Template.Annotations[configHashAnnotation] != getConfigHash(cm.Data[collectorMatchFileKey]) {
+		t.Fatal("Wrong config hash in metrics collector deployment")
+	}
+
+	// reordered allowlist should not update the deployment
+	resourceVersion := deploy.ResourceVersion
+	list.NameList = []string{"b", "a"}
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, hubInfo, nil, nil, nil, list, testClusterID, "", platformOpenShift, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not found: (%v)", err)
+	}
+	if deploy.ResourceVersion != resourceVersion {
+		t.Fatal("Metrics collector deployment updated for the reordered allowlist")
+	}
+
+	// changed allowlist should roll the deployment
+	list.NameList = append(list.NameList, "g")
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, hubInfo, nil, nil, nil, list, testClusterID, "", platformOpenShift, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not found: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorConfigName, Namespace: testNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Collector config configmap not found: (%v)", err)
+	}
+	if deploy.Spec.Template.Annotations[configHashAnnotation] != getConfigHash(cm.Data[collectorMatchFileKey]) {
+		t.Fatal("Config hash not updated in metrics collector deployment")
+	}
+
+	// enabled user workload metrics should add the match file and roll the deployment
+	list.UserWorkload.NameList = []string{"h"}
+	addonConfig := &AddonConfig{UserWorkloadMetrics: &UserWorkloadMetrics{Enabled: true}}
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, hubInfo, nil, addonConfig, nil, list, testClusterID, "", platformOpenShift, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorConfigName, Namespace: testNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Collector config configmap not found: (%v)", err)
+	}
+	if cm.Data[uwlCollectorMatchFileKey] == "" {
+		t.Fatal("User workload match file not added")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not found: (%v)", err)
+	}
+	if deploy.Spec.Template.Annotations[configHashAnnotation] !=
+		getConfigHash(cm.Data[collectorMatchFileKey]+cm.Data[uwlCollectorMatchFileKey]) {
+		t.Fatal("Config hash not updated for the user workload match file")
+	}
+	if len(deploy.Spec.Template.Spec.Containers) != 2 {
+		t.Fatal("User workload metrics collector container not added")
+	}
+
+	err = deleteCollectorConfig(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to delete collector config configmap: (%v)", err)
+	}
+	err = deleteCollectorConfig(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Run into error when try to delete collector config configmap twice: (%v)", err)
+	}
+}
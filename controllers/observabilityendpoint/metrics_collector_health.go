@@ -0,0 +1,158 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// collectorHealthCheckPeriod is the period to check the health of the metrics collector again
+	collectorHealthCheckPeriod = time.Minute
+	// collectorRestartThreshold is the restart count from which a recently restarted container is unhealthy
+	collectorRestartThreshold = 3
+	// collectorEventWindow is how long the restarts and the warning events are taken into account
+	collectorEventWindow = 10 * time.Minute
+)
+
+// the states reported in the observabilityaddon status for the metrics collector health
+const (
+	collectorStateHealthy     = "Deployed"
+	collectorStateProgressing = "Progressing"
+	collectorStateUnhealthy   = "CollectorUnhealthy"
+)
+
+// unhealthyWaitingReasons are the reasons of the waiting containers which won't recover by themselves
+var unhealthyWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// getCollectorHealth evaluates the health of the metrics collector from the available replicas of its deployment,
+// the container states and restart counts of its pods and the recent warning events. It returns the state
+// reported in the status and the details of the problems found.
+func getCollectorHealth(ctx context.Context, c client.Client, ns string, now time.Time) (string, string, error) {
+	deploy := &appsv1.Deployment{}
+	err := c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: ns}, deploy)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return collectorStateProgressing, "metrics collector deployment not found", nil
+		}
+		log.Error(err, "Failed to get the metrics collector deployment")
+		return "", "", err
+	}
+
+	podList := &corev1.PodList{}
+	err = c.List(ctx, podList, client.InNamespace(ns), client.MatchingLabels{selectorKey: selectorValue})
+	if err != nil {
+		log.Error(err, "Failed to list the metrics collector pods")
+		return "", "", err
+	}
+	problems := []string{}
+	podNames := map[string]bool{}
+	for _, pod := range podList.Items {
+		podNames[pod.Name] = true
+		for _, status := range pod.Status.ContainerStatuses {
+			if problem := getContainerProblem(status, now); problem != "" {
+				problems = append(problems, fmt.Sprintf("container %s in pod %s %s", status.Name, pod.Name, problem))
+			}
+		}
+	}
+	sort.Strings(problems)
+
+	for _, cond := range deploy.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			problems = append(problems, "deployment "+cond.Message)
+		}
+	}
+
+	eventList := &corev1.EventList{}
+	err = c.List(ctx, eventList, client.InNamespace(ns))
+	if err != nil {
+		log.Error(err, "Failed to list the events")
+		return "", "", err
+	}
+	warning := getLastCollectorWarning(eventList.Items, podNames, now)
+
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	if len(problems) != 0 {
+		if warning != "" {
+			problems = append(problems, "last warning event: "+warning)
+		}
+		return collectorStateUnhealthy, strings.Join(problems, "; "), nil
+	}
+	if deploy.Status.ObservedGeneration < deploy.Generation || deploy.Status.UpdatedReplicas < replicas ||
+		deploy.Status.AvailableReplicas < replicas {
+		details := fmt.Sprintf("%d of %d replicas available", deploy.Status.AvailableReplicas, replicas)
+		if warning != "" {
+			details = details + "; last warning event: " + warning
+		}
+		return collectorStateProgressing, details, nil
+	}
+	return collectorStateHealthy, "", nil
+}
+
+// getContainerProblem returns the problem of the container, empty means that the container is healthy
+func getContainerProblem(status corev1.ContainerStatus, now time.Time) string {
+	if waiting := status.State.Waiting; waiting != nil && unhealthyWaitingReasons[waiting.Reason] {
+		return fmt.Sprintf("is waiting: %s: %s", waiting.Reason, waiting.Message)
+	}
+	if terminated := status.LastTerminationState.Terminated; terminated != nil &&
+		status.RestartCount >= collectorRestartThreshold &&
+		now.Sub(terminated.FinishedAt.Time) < collectorEventWindow {
+		return fmt.Sprintf("restarted %d times, last terminated: %s (exit code %d)",
+			status.RestartCount, terminated.Reason, terminated.ExitCode)
+	}
+	return ""
+}
+
+// getLastCollectorWarning returns the message of the last recent warning event of the metrics collector
+// deployment, its replicasets or its pods
+func getLastCollectorWarning(events []corev1.Event, podNames map[string]bool, now time.Time) string {
+	var last *corev1.Event
+	var lastTime time.Time
+	for i := range events {
+		event := &events[i]
+		if event.Type != corev1.EventTypeWarning {
+			continue
+		}
+		obj := event.InvolvedObject
+		if !(obj.Kind == "Deployment" && obj.Name == metricsCollectorName ||
+			obj.Kind == "ReplicaSet" && strings.HasPrefix(obj.Name, metricsCollectorName+"-") ||
+			obj.Kind == "Pod" && podNames[obj.Name]) {
+			continue
+		}
+		eventTime := event.LastTimestamp.Time
+		if eventTime.IsZero() {
+			eventTime = event.EventTime.Time
+		}
+		if now.Sub(eventTime) > collectorEventWindow {
+			continue
+		}
+		if last == nil || eventTime.After(lastTime) {
+			last = event
+			lastTime = eventTime
+		}
+	}
+	if last == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %s: %s", last.InvolvedObject.Name, last.Reason, last.Message)
+}
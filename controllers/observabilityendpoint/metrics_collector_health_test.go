@@ -0,0 +1,171 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newCollectorDeployment(available int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      metricsCollectorName,
+			Namespace: testNamespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(1),
+		},
+		Status: appsv1.DeploymentStatus{
+			UpdatedReplicas:   1,
+			AvailableReplicas: available,
+		},
+	}
+}
+
+func newCollectorPod(status corev1.ContainerStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      metricsCollectorName + "-5d8f7b6c4-abcde",
+			Namespace: testNamespace,
+			Labels:    map[string]string{selectorKey: selectorValue},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{status},
+		},
+	}
+}
+
+func newCollectorEvent(name string, kind string, eventType string, lastTimestamp time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + ".event",
+			Namespace: testNamespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      kind,
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Type:          eventType,
+		Reason:        "BackOff",
+		Message:       "Back-off restarting failed container",
+		LastTimestamp: metav1.NewTime(lastTimestamp),
+	}
+}
+
+func TestGetCollectorHealth(t *testing.T) {
+	now := time.Now()
+	podName := metricsCollectorName + "-5d8f7b6c4-abcde"
+	running := corev1.ContainerStatus{
+		Name:  "metrics-collector",
+		Ready: true,
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}
+	crashLooping := corev1.ContainerStatus{
+		Name: "metrics-collector",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+			Reason:  "CrashLoopBackOff",
+			Message: "back-off 5m0s restarting failed container",
+		}},
+		RestartCount: 6,
+	}
+	imagePullBackOff := corev1.ContainerStatus{
+		Name: "metrics-collector",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+			Reason: "ImagePullBackOff",
+		}},
+	}
+	restarted := func(finishedAt time.Time) corev1.ContainerStatus {
+		return corev1.ContainerStatus{
+			Name:         "metrics-collector",
+			State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			RestartCount: 4,
+			LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				Reason:     "Error",
+				ExitCode:   1,
+				FinishedAt: metav1.NewTime(finishedAt),
+			}},
+		}
+	}
+
+	caseList := []struct {
+		name            string
+		objs            []runtime.Object
+		expectedState   string
+		expectedDetails string
+	}{
+		{
+			name:          "healthy",
+			objs:          []runtime.Object{newCollectorDeployment(1), newCollectorPod(running)},
+			expectedState: collectorStateHealthy,
+		},
+		{
+			name:            "deployment not found",
+			expectedState:   collectorStateProgressing,
+			expectedDetails: "not found",
+		},
+		{
+			name:            "rolling out",
+			objs:            []runtime.Object{newCollectorDeployment(0)},
+			expectedState:   collectorStateProgressing,
+			expectedDetails: "0 of 1 replicas available",
+		},
+		{
+			name: "crash looping",
+			objs: []runtime.Object{newCollectorDeployment(0), newCollectorPod(crashLooping),
+				newCollectorEvent(podName, "Pod", corev1.EventTypeWarning, now.Add(-time.Minute))},
+			expectedState:   collectorStateUnhealthy,
+			expectedDetails: "CrashLoopBackOff",
+		},
+		{
+			name:            "image pull back off",
+			objs:            []runtime.Object{newCollectorDeployment(0), newCollectorPod(imagePullBackOff)},
+			expectedState:   collectorStateUnhealthy,
+			expectedDetails: "ImagePullBackOff",
+		},
+		{
+			name:            "restarted recently",
+			objs:            []runtime.Object{newCollectorDeployment(1), newCollectorPod(restarted(now.Add(-time.Minute)))},
+			expectedState:   collectorStateUnhealthy,
+			expectedDetails: "restarted 4 times",
+		},
+		{
+			name:          "restarted long ago",
+			objs:          []runtime.Object{newCollectorDeployment(1), newCollectorPod(restarted(now.Add(-time.Hour)))},
+			expectedState: collectorStateHealthy,
+		},
+		{
+			name: "rolling out with warning event",
+			objs: []runtime.Object{newCollectorDeployment(0),
+				newCollectorEvent(metricsCollectorName+"-5d8f7b6c4", "ReplicaSet", corev1.EventTypeWarning, now.Add(-time.Minute)),
+				newCollectorEvent("other-pod", "Pod", corev1.EventTypeWarning, now.Add(-time.Minute))},
+			expectedState:   collectorStateProgressing,
+			expectedDetails: metricsCollectorName + "-5d8f7b6c4 BackOff",
+		},
+	}
+
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewFakeClient(c.objs...)
+			state, details, err := getCollectorHealth(context.TODO(), client, testNamespace, now)
+			if err != nil {
+				t.Fatalf("Failed to get the metrics collector health: (%v)", err)
+			}
+			if state != c.expectedState {
+				t.Fatalf("Wrong state, expected: %s, actual: %s (%s)", c.expectedState, state, details)
+			}
+			if !strings.Contains(details, c.expectedDetails) {
+				t.Fatalf("Wrong details, expected to contain: %q, actual: %q", c.expectedDetails, details)
+			}
+		})
+	}
+}
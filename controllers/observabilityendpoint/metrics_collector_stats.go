@@ -0,0 +1,306 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/util"
+	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+)
+
+const (
+	collectorMetricsPortName    = "metrics"
+	collectorMetricsPort        = 8080
+	uwlCollectorMetricsPortName = "uwl-metrics"
+	uwlCollectorMetricsPort     = 8081
+	collectorMetricsPath        = "/metrics"
+	collectorScrapeTimeout      = 10 * time.Second
+	// pushStaleAfter is the time without a successful push after which failing pushes are reported as stale
+	pushStaleAfter = 10 * time.Minute
+)
+
+// the metrics exposed by the metrics collector
+const (
+	// collectorSamplesMetric is the gauge of the number of samples per federation
+	collectorSamplesMetric = "federate_samples"
+	// collectorErrorsMetric is the gauge of the number of times forwarding federated metrics has failed
+	collectorErrorsMetric = "federate_errors"
+)
+
+// the metrics of the operator for the metrics collectors, labeled with the name of the metrics collector
+var (
+	collectorSamples = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "endpoint_observability_collector_federated_samples",
+		Help: "Number of samples federated by the last federation of the metrics collector.",
+	}, []string{"collector"})
+	collectorFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "endpoint_observability_collector_failures",
+		Help: "Number of failed federations and pushes since the metrics collector started.",
+	}, []string{"collector"})
+	collectorLastPush = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "endpoint_observability_collector_last_push_timestamp_seconds",
+		Help: "Time the metrics collector was last observed pushing without failures.",
+	}, []string{"collector"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(collectorSamples, collectorFailures, collectorLastPush)
+}
+
+// collectorStats is the forwarding state read from the metrics of the metrics collector
+type collectorStats struct {
+	// Samples is the number of samples federated by the last federation
+	Samples int64
+	// Failures is the number of failed federations and pushes since the metrics collector started
+	Failures int64
+	// Missing are the metrics which are not exposed by the metrics collector
+	Missing []string
+}
+
+// collectorObservation is the stats of a metrics collector observed at a time
+type collectorObservation struct {
+	stats    collectorStats
+	observed time.Time
+	// started is the time of the first observation since the metrics collector started
+	started time.Time
+	// pushed is the time of the last observation which found the metrics collector pushing, zero if none did
+	pushed time.Time
+}
+
+// lastPush returns the time of the last successful push, or the time the metrics collector was first observed
+// if it was never found pushing
+func (o *collectorObservation) lastPush() time.Time {
+	if o.pushed.IsZero() {
+		return o.started
+	}
+	return o.pushed
+}
+
+// collectorStatsHistory keeps the last stats observed for each metrics collector, the metrics collector has no
+// metric for the time of the last successful push, so the failures are counted since the last observation and
+// the last push is the last observation without new failures
+type collectorStatsHistory struct {
+	mutex sync.Mutex
+	last  map[string]collectorObservation
+}
+
+// observe records the stats of the metrics collector, which is identified by the name of its deployment and its
+// port, and returns the previous observation. nil is returned for the first observation, or when the metrics
+// collector is restarted since the previous one.
+func (h *collectorStatsHistory) observe(collector string, stats *collectorStats,
+	now time.Time) *collectorObservation {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.last == nil {
+		h.last = map[string]collectorObservation{}
+	}
+	previous, ok := h.last[collector]
+	if stats == nil || len(stats.Missing) != 0 {
+		delete(h.last, collector)
+		deleteCollectorMetrics(collector)
+		return nil
+	}
+	var last *collectorObservation
+	observation := collectorObservation{stats: *stats, observed: now, started: now}
+	if ok && stats.Failures >= previous.stats.Failures {
+		last = &previous
+		observation.started = previous.started
+		observation.pushed = previous.pushed
+	}
+	if isPushing(stats, last) {
+		observation.pushed = now
+	}
+	h.last[collector] = observation
+	recordCollectorMetrics(collector, observation)
+	return last
+}
+
+// isPushing returns true if the metrics collector federated samples without new failures since the previous
+// observation, or without any failure on the first observation
+func isPushing(stats *collectorStats, previous *collectorObservation) bool {
+	if stats.Samples == 0 {
+		return false
+	}
+	if previous == nil {
+		return stats.Failures == 0
+	}
+	return stats.Failures == previous.stats.Failures
+}
+
+var getCollectorStats = scrapeCollectorStats
+
+// scrapeCollectorStats reads the metrics of the metrics collector listening on the port in the running pod
+// which has the pod labels
+func scrapeCollectorStats(ctx context.Context, c client.Client, ns string, podLabels map[string]string,
+	port int) (*collectorStats, error) {
+	podList := &corev1.PodList{}
+	err := c.List(ctx, podList, client.InNamespace(ns), client.MatchingLabels(podLabels))
+	if err != nil {
+		log.Error(err, "Failed to list the metrics collector pods")
+		return nil, err
+	}
+	pods := []corev1.Pod{}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != "" && pod.DeletionTimestamp == nil {
+			pods = append(pods, pod)
+		}
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no running metrics collector pod")
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	url := "http://" + net.JoinHostPort(pods[0].Status.PodIP, strconv.Itoa(port)) + collectorMetricsPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Timeout: collectorScrapeTimeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape the metrics collector pod %s: %v", pods[0].Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics collector pod %s returned status %s", pods[0].Name, resp.Status)
+	}
+	return parseCollectorStats(resp.Body)
+}
+
+// parseCollectorStats parses the metrics of the metrics collector in the text exposition format
+func parseCollectorStats(r io.Reader) (*collectorStats, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the metrics collector metrics: %v", err)
+	}
+	stats := &collectorStats{
+		Samples:  int64(getMetricValue(families[collectorSamplesMetric])),
+		Failures: int64(getMetricValue(families[collectorErrorsMetric])),
+	}
+	for _, name := range []string{collectorSamplesMetric, collectorErrorsMetric} {
+		if families[name] == nil {
+			stats.Missing = append(stats.Missing, name)
+		}
+	}
+	return stats, nil
+}
+
+// getMetricValue returns the sum of the values of the metric family
+func getMetricValue(family *dto.MetricFamily) float64 {
+	if family == nil {
+		return 0
+	}
+	value := 0.0
+	for _, m := range family.Metric {
+		switch {
+		case m.Gauge != nil:
+			value += m.Gauge.GetValue()
+		case m.Counter != nil:
+			value += m.Counter.GetValue()
+		case m.Untyped != nil:
+			value += m.Untyped.GetValue()
+		}
+	}
+	return value
+}
+
+// getForwardingCondition returns the MetricsForwarding condition for the stats of the metrics collector, it's False
+// when the failures increased since the previous observation, and Unknown when the stats are not available.
+// The messages don't carry the stats, which change on every check, so the status is only written when the
+// forwarding state changes.
+func getForwardingCondition(stats *collectorStats, err error, previous *collectorObservation,
+	now time.Time) oav1beta1.StatusCondition {
+	if err != nil {
+		return oav1beta1.StatusCondition{
+			Type:    util.ConditionMetricsForwarding,
+			Status:  metav1.ConditionUnknown,
+			Reason:  "StatsUnavailable",
+			Message: "Failed to read the metrics collector metrics: " + err.Error(),
+		}
+	}
+	if len(stats.Missing) != 0 {
+		return oav1beta1.StatusCondition{
+			Type:    util.ConditionMetricsForwarding,
+			Status:  metav1.ConditionUnknown,
+			Reason:  "StatsUnavailable",
+			Message: "The metrics collector does not expose " + strings.Join(stats.Missing, ", "),
+		}
+	}
+	if previous != nil && stats.Failures > previous.stats.Failures {
+		if now.Sub(previous.lastPush()) > pushStaleAfter {
+			return oav1beta1.StatusCondition{
+				Type:    util.ConditionMetricsForwarding,
+				Status:  metav1.ConditionFalse,
+				Reason:  "PushStale",
+				Message: fmt.Sprintf("No successful push in the last %s", pushStaleAfter),
+			}
+		}
+		return oav1beta1.StatusCondition{
+			Type:    util.ConditionMetricsForwarding,
+			Status:  metav1.ConditionFalse,
+			Reason:  "PushFailing",
+			Message: "Pushing metrics to the hub is failing",
+		}
+	}
+	if stats.Samples == 0 {
+		return oav1beta1.StatusCondition{
+			Type:    util.ConditionMetricsForwarding,
+			Status:  metav1.ConditionUnknown,
+			Reason:  "NoSamples",
+			Message: "No samples federated yet",
+		}
+	}
+	if previous == nil && stats.Failures != 0 {
+		// the failures may be recent, they are known once the metrics collector is observed again
+		return oav1beta1.StatusCondition{
+			Type:    util.ConditionMetricsForwarding,
+			Status:  metav1.ConditionUnknown,
+			Reason:  "Observing",
+			Message: "Checking whether the failures continue",
+		}
+	}
+	return oav1beta1.StatusCondition{
+		Type:    util.ConditionMetricsForwarding,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Pushed",
+		Message: "Metrics pushed to the hub",
+	}
+}
+
+// recordCollectorMetrics exposes the stats and the last successful push of the metrics collector in the metrics
+// of the operator, the status only has conditions
+func recordCollectorMetrics(collector string, observation collectorObservation) {
+	collectorSamples.WithLabelValues(collector).Set(float64(observation.stats.Samples))
+	collectorFailures.WithLabelValues(collector).Set(float64(observation.stats.Failures))
+	if !observation.pushed.IsZero() {
+		collectorLastPush.WithLabelValues(collector).Set(float64(observation.pushed.Unix()))
+	} else {
+		collectorLastPush.DeleteLabelValues(collector)
+	}
+}
+
+// deleteCollectorMetrics removes the metrics of the metrics collector whose stats are not available
+func deleteCollectorMetrics(collector string) {
+	for _, gauge := range []*prometheus.GaugeVec{collectorSamples, collectorFailures, collectorLastPush} {
+		gauge.DeleteLabelValues(collector)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseCollectorStats(t *testing.T) {
+	metrics := `# HELP federate_samples Tracks the number of samples per federation
+# TYPE federate_samples gauge
+federate_samples 1234
+# HELP federate_errors The number of times forwarding federated metrics has failed
+# TYPE federate_errors gauge
+federate_errors 2
+go_goroutines 20
+`
+	stats, err := parseCollectorStats(strings.NewReader(metrics))
+	if err != nil {
+		t.Fatalf("Failed to parse the metrics collector metrics: (%v)", err)
+	}
+	if stats.Samples != 1234 || stats.Failures != 2 || len(stats.Missing) != 0 {
+		t.Fatalf("Wrong stats: %+v", stats)
+	}
+
+	stats, err = parseCollectorStats(strings.NewReader("go_goroutines 20\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse the metrics collector metrics: (%v)", err)
+	}
+	expected := []string{collectorSamplesMetric, collectorErrorsMetric}
+	if !reflect.DeepEqual(stats.Missing, expected) {
+		t.Fatalf("Wrong missing metrics, expected: %v, actual: %v", expected, stats.Missing)
+	}
+
+	_, err = parseCollectorStats(strings.NewReader("federate_samples{"))
+	if err == nil {
+		t.Fatal("Miss the error for invalid metrics")
+	}
+}
+
+func TestCollectorStatsHistory(t *testing.T) {
+	now := time.Now()
+	history := collectorStatsHistory{}
+	if previous := history.observe(metricsCollectorName, &collectorStats{Samples: 10}, now); previous != nil {
+		t.Fatalf("Previous observation returned for the first one: %+v", previous)
+	}
+	previous := history.observe(metricsCollectorName, &collectorStats{Samples: 10, Failures: 2}, now.Add(time.Minute))
+	if previous == nil || previous.stats.Failures != 0 || !previous.observed.Equal(now) {
+		t.Fatalf("Wrong previous observation: %+v", previous)
+	}
+	if previous := history.observe("metrics-collector-hub-a", &collectorStats{Samples: 10}, now); previous != nil {
+		t.Fatalf("Observations are shared between the metrics collectors: %+v", previous)
+	}
+	// the last push is the last observation without new failures
+	previous = history.observe(metricsCollectorName, &collectorStats{Samples: 10, Failures: 2}, now.Add(90*time.Second))
+	if previous == nil || !previous.started.Equal(now) || !previous.lastPush().Equal(now) {
+		t.Fatalf("Wrong last push: %+v", previous)
+	}
+	if v := testutil.ToFloat64(collectorFailures.WithLabelValues(metricsCollectorName)); v != 2 {
+		t.Fatalf("Wrong failures metric: %v", v)
+	}
+	if v := testutil.ToFloat64(collectorLastPush.WithLabelValues(metricsCollectorName)); v != float64(now.Add(
+		90*time.Second).Unix()) {
+		t.Fatalf("Wrong last push metric: %v", v)
+	}
+
+	// the failures are reset when the metrics collector restarts
+	if previous := history.observe(metricsCollectorName, &collectorStats{Samples: 10}, now.Add(2*time.Minute)); previous != nil {
+		t.Fatalf("Previous observation returned after the restart: %+v", previous)
+	}
+	history.observe(metricsCollectorName, nil, now.Add(3*time.Minute))
+	if collectorSamples.DeleteLabelValues(metricsCollectorName) {
+		t.Fatal("Metrics of the unavailable metrics collector not deleted")
+	}
+	if previous := history.observe(metricsCollectorName, &collectorStats{Samples: 10}, now.Add(4*time.Minute)); previous != nil {
+		t.Fatalf("Previous observation returned after the stats are unavailable: %+v", previous)
+	}
+}
+
+func TestGetForwardingCondition(t *testing.T) {
+	now := time.Now()
+	caseList := []struct {
+		name           string
+		stats          *collectorStats
+		previous       *collectorObservation
+		err            error
+		expectedStatus metav1.ConditionStatus
+		expectedReason string
+	}{
+		{
+			name:           "pushed",
+			stats:          &collectorStats{Samples: 10, Failures: 3},
+			previous:       &collectorObservation{stats: collectorStats{Samples: 10, Failures: 3}, observed: now},
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: "Pushed",
+		},
+		{
+			name:           "first observation",
+			stats:          &collectorStats{Samples: 10},
+			expectedStatus: metav1.ConditionTrue,
+			expectedReason: "Pushed",
+		},
+		{
+			name:           "first observation with failures",
+			stats:          &collectorStats{Samples: 10, Failures: 3},
+			expectedStatus: metav1.ConditionUnknown,
+			expectedReason: "Observing",
+		},
+		{
+			name:  "push failing",
+			stats: &collectorStats{Samples: 10, Failures: 5},
+			previous: &collectorObservation{stats: collectorStats{Samples: 10, Failures: 3}, observed: now,
+				started: now.Add(-time.Hour), pushed: now},
+			expectedStatus: metav1.ConditionFalse,
+			expectedReason: "PushFailing",
+		},
+		{
+			name:  "push stale",
+			stats: &collectorStats{Samples: 10, Failures: 5},
+			previous: &collectorObservation{stats: collectorStats{Samples: 10, Failures: 3}, observed: now,
+				started: now.Add(-time.Hour), pushed: now.Add(-pushStaleAfter - time.Minute)},
+			expectedStatus: metav1.ConditionFalse,
+			expectedReason: "PushStale",
+		},
+		{
+			name:           "no samples",
+			stats:          &collectorStats{},
+			expectedStatus: metav1.ConditionUnknown,
+			expectedReason: "NoSamples",
+		},
+		{
+			name:           "missing metrics",
+			stats:          &collectorStats{Missing: []string{collectorErrorsMetric}},
+			expectedStatus: metav1.ConditionUnknown,
+			expectedReason: "StatsUnavailable",
+		},
+		{
+			name:           "scrape failed",
+			err:            errors.New("connection refused"),
+			expectedStatus: metav1.ConditionUnknown,
+			expectedReason: "StatsUnavailable",
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			condition := getForwardingCondition(c.stats, c.err, c.previous, now)
+			if condition.Status != c.expectedStatus || condition.Reason != c.expectedReason {
+				t.Fatalf("Wrong condition, expected: %s %s, actual: %+v", c.expectedStatus, c.expectedReason, condition)
+			}
+			if c.stats == nil || len(c.stats.Missing) != 0 {
+				return
+			}
+			// the condition doesn't change with the stats while the forwarding state is the same
+			stats := *c.stats
+			stats.Samples += 100
+			var previous *collectorObservation
+			if c.previous != nil {
+				moved := *c.previous
+				moved.stats.Failures += 10
+				stats.Failures += 10
+				previous = &moved
+			}
+			if again := getForwardingCondition(&stats, nil, previous, now.Add(time.Second)); c.expectedReason !=
+				"NoSamples" && again != condition {
+				t.Fatalf("Condition changed with the stats: %+v, %+v", condition, again)
+			}
+		})
+	}
+}
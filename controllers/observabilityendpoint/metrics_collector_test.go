@@ -4,10 +4,17 @@ package observabilityendpoint
 
 import (
 	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
+	"github.com/ghodss/yaml"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/kubectl/pkg/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
@@ -16,11 +23,13 @@ import (
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
 )
 
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
 func getAllowlistCM() *corev1.ConfigMap {
 	return &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      metricsConfigMapName,
-			Namespace: namespace,
+			Namespace: testNamespace,
 		},
 		Data: map[string]string{
 			metricsConfigMapKey: `
@@ -28,7 +37,7 @@ names:
   - a
   - b
 matches:
-  - c
+  - __name__="c"
 rules:
   - record: f
     expr: g
@@ -40,9 +49,6 @@ func init() {
 	s := scheme.Scheme
 	addonv1alpha1.AddToScheme(s)
 	oav1beta1.AddToScheme(s)
-
-	namespace = testNamespace
-	hubNamespace = testHubNamspace
 }
 
 func TestMetricsCollector(t *testing.T) {
@@ -58,29 +64,267 @@ func TestMetricsCollector(t *testing.T) {
 
 	ctx := context.TODO()
 	c := fake.NewFakeClient(allowlistCM)
+	list, err := getMetricsAllowlist(ctx, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to get metrics allowlist: (%v)", err)
+	}
 	// Default deployment with instance count 1
-	_, err := updateMetricsCollector(ctx, c, obsAddon, *hubInfo, testClusterID, "", 1, false)
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, *hubInfo, nil, nil, nil, list, testClusterID, "", platformOpenShift, 1, false)
 	if err != nil {
 		t.Fatalf("Failed to create metrics collector deployment: (%v)", err)
 	}
 	// Update deployment to reduce instance count to zero
-	_, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, testClusterID, "", 0, false)
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, *hubInfo, nil, nil, nil, list, testClusterID, "", platformOpenShift, 0, false)
 	if err != nil {
 		t.Fatalf("Failed to create metrics collector deployment: (%v)", err)
 	}
 
-	_, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, testClusterID+"-update", "SNO", 1, false)
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, *hubInfo, nil, nil, nil, list, testClusterID+"-update", "SNO", platformOpenShift, 1, false)
 	if err != nil {
 		t.Fatalf("Failed to create metrics collector deployment: (%v)", err)
 	}
 
-	_, err = updateMetricsCollector(ctx, c, obsAddon, *hubInfo, testClusterID+"-update", "SNO", 1, true)
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, *hubInfo, nil, nil, nil, list, testClusterID+"-update", "SNO", platformOpenShift, 1, true)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
+	}
+
+	// the restart label is kept when the deployment is updated without force restart
+	obsAddon.Interval = 30
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, *hubInfo, nil, nil, nil, list, testClusterID+"-update", "SNO", platformOpenShift, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
+	}
+	deploy := &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not found: (%v)", err)
+	}
+	if deploy.Spec.Template.ObjectMeta.Labels[restartLabel] == "" {
+		t.Fatal("Restart label removed from the metrics collector deployment")
+	}
+
+	// the emptied fields are removed from the deployment
+	obsAddon.Resources = corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+	}
+	addonConfig := &AddonConfig{TestEnvironment: &TestEnvironment{
+		HostAliases: []HostAlias{{IP: "172.17.0.2", Hostnames: []string{"observatorium.hub"}}},
+	}}
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, *hubInfo, nil, addonConfig, nil, list, testClusterID+"-update",
+		"SNO", platformOpenShift, 1, false)
 	if err != nil {
 		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
 	}
+	obsAddon.Resources = corev1.ResourceRequirements{}
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, *hubInfo, nil, nil, nil, list, testClusterID+"-update", "SNO",
+		platformOpenShift, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
+	}
+	deploy = &appsv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not found: (%v)", err)
+	}
+	if len(deploy.Spec.Template.Spec.HostAliases) != 0 || len(deploy.Spec.Template.Spec.Containers[0].Resources.Limits) != 0 {
+		t.Fatalf("The emptied fields are kept in the metrics collector deployment: %v",
+			deploy.Spec.Template.Spec)
+	}
 
-	err = deleteMetricsCollector(ctx, c)
+	// the unchanged spec does not update the deployment
+	resourceVersion := deploy.ResourceVersion
+	_, err = updateMetricsCollector(ctx, c, newTestConfig(), obsAddon, *hubInfo, nil, nil, nil, list, testClusterID+"-update", "SNO",
+		platformOpenShift, 1, false)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not found: (%v)", err)
+	}
+	if deploy.ResourceVersion != resourceVersion {
+		t.Fatal("Metrics collector deployment updated without change")
+	}
+
+	err = deleteMetricsCollector(ctx, c, testNamespace)
 	if err != nil {
 		t.Fatalf("Failed to delete metrics collector deployment: (%v)", err)
 	}
 }
+
+// checkGolden compares the content with the golden file in testdata, or updates it if -update is set
+func checkGolden(t *testing.T, name string, content []byte) {
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(golden, content, 0644); err != nil {
+			t.Fatalf("Failed to update golden file %s: (%v)", golden, err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read golden file %s: (%v)", golden, err)
+	}
+	if string(expected) != string(content) {
+		t.Fatalf("Rendered content does not match golden file %s, expected:\n%s\nactual:\n%s", golden, expected, content)
+	}
+}
+
+func TestRenderDeploymentGolden(t *testing.T) {
+	hubInfo := HubInfo{
+		ClusterName: "test-cluster",
+		Endpoint:    "https://observatorium-api.test-hub/api/metrics/v1/default/api/v1/receive",
+	}
+	list := MetricsAllowlist{
+		NameList:  []string{"up", ":node_memory_MemAvailable_bytes:sum", "cluster_version"},
+		MatchList: []string{`__name__="workqueue_depth",job="apiserver"`},
+		ReNameMap: map[string]string{
+			"mixin_pod_workload":               "namespace_workload_pod:kube_pod_owner:relabel",
+			"etcd_mvcc_db_total_size_in_bytes": "etcd_debugging_mvcc_db_total_size_in_bytes",
+		},
+		RuleList: []Rule{
+			{Record: "apiserver_request_duration_seconds:histogram_quantile_99", Expr: `histogram_quantile(0.99,sum(rate(apiserver_request_duration_seconds_bucket{job="apiserver"}[5m])) by (le))`},
+			{Record: "active_streams_lease:grpc_server_handled_total:sum", Expr: `sum(grpc_server_started_total{job="etcd",grpc_service="etcdserverpb.Lease",grpc_type="bidi_stream"}) - sum(grpc_server_handled_total{job="etcd",grpc_service="etcdserverpb.Lease",grpc_type="bidi_stream"})`},
+		},
+		Denylist: MetricsDenylist{
+			MatchList: []string{`namespace="openshift-test"`},
+		},
+	}
+
+	caseList := []struct {
+		name         string
+		clusterID    string
+		clusterType  string
+		platform     string
+		obsAddonSpec oashared.ObservabilityAddonSpec
+		addonConfig  *AddonConfig
+		replicas     int32
+	}{
+		{
+			name:      "ocp",
+			clusterID: "test-cluster-id",
+			platform:  platformOpenShift,
+			obsAddonSpec: oashared.ObservabilityAddonSpec{
+				EnableMetrics: true,
+				Interval:      30,
+			},
+			replicas: 1,
+		},
+		{
+			name:        "ocp-sno",
+			clusterID:   "test-cluster-id",
+			clusterType: "SNO",
+			platform:    platformOpenShift,
+			obsAddonSpec: oashared.ObservabilityAddonSpec{
+				EnableMetrics: true,
+				Interval:      300,
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("100m"),
+						corev1.ResourceMemory: resource.MustParse("600Mi"),
+					},
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("10m"),
+						corev1.ResourceMemory: resource.MustParse("100Mi"),
+					},
+				},
+			},
+			replicas: 1,
+		},
+		{
+			name:      "custom-source",
+			clusterID: "test-cluster-id",
+			platform:  platformOpenShift,
+			obsAddonSpec: oashared.ObservabilityAddonSpec{
+				EnableMetrics: true,
+				Interval:      60,
+			},
+			addonConfig: &AddonConfig{
+				MetricsSource: &MetricsSource{
+					ServerURL: "https://thanos-querier.monitoring.svc:9091",
+					TLSConfig: &MetricsSourceTLSConfig{
+						CA:   "thanos-querier-ca",
+						Cert: "thanos-querier-client-cert",
+					},
+					BearerTokenSecret: "thanos-querier-token",
+				},
+			},
+			replicas: 1,
+		},
+		{
+			name:      "kubernetes",
+			clusterID: "test-kube-system-uid",
+			platform:  platformKubernetes,
+			obsAddonSpec: oashared.ObservabilityAddonSpec{
+				EnableMetrics: true,
+				Interval:      60,
+			},
+			addonConfig: &AddonConfig{
+				MetricsSource: &MetricsSource{
+					ServerURL: "http://prometheus-operated.monitoring.svc:9090",
+				},
+			},
+			replicas: 1,
+		},
+		{
+			name:      "test-environment",
+			clusterID: "test-cluster-id",
+			platform:  platformOpenShift,
+			obsAddonSpec: oashared.ObservabilityAddonSpec{
+				EnableMetrics: true,
+				Interval:      60,
+			},
+			addonConfig: &AddonConfig{
+				TestEnvironment: &TestEnvironment{
+					MetricsSourceURL: "http://prometheus-k8s.openshift-monitoring.svc:9090",
+					HostAliases: []HostAlias{
+						{IP: "172.17.0.2", Hostnames: []string{"observatorium.hub"}},
+					},
+					TLSMode: tlsModeDisabled,
+				},
+			},
+			replicas: 1,
+		},
+		{
+			name:      "user-workload",
+			clusterID: "test-cluster-id",
+			platform:  platformOpenShift,
+			obsAddonSpec: oashared.ObservabilityAddonSpec{
+				EnableMetrics: true,
+				Interval:      60,
+			},
+			addonConfig: &AddonConfig{
+				UserWorkloadMetrics: &UserWorkloadMetrics{Enabled: true},
+			},
+			replicas: 1,
+		},
+		{
+			name:      "ocp-311-disabled",
+			clusterID: "",
+			platform:  platformOpenShift,
+			obsAddonSpec: oashared.ObservabilityAddonSpec{
+				EnableMetrics: false,
+				Interval:      60,
+			},
+			replicas: 0,
+		},
+	}
+
+	matchFile := renderMatchFile(list)
+	checkGolden(t, "collector-match-file.golden", []byte(matchFile))
+
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			// render twice to make sure the rendering is stable
+			for i := 0; i < 2; i++ {
+				deployment := createDeployment(newTestConfig(), c.clusterID, c.clusterType, c.platform, c.obsAddonSpec, hubInfo, nil, c.addonConfig, nil,
+					list, getConfigHash(matchFile), c.replicas)
+				content, err := yaml.Marshal(deployment)
+				if err != nil {
+					t.Fatalf("Failed to marshal deployment: (%v)", err)
+				}
+				checkGolden(t, c.name+"-deployment.golden", content)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	metricsSourceCAVolName    = "metrics-source-ca"
+	metricsSourceCertVolName  = "metrics-source-cert"
+	metricsSourceTokenVolName = "metrics-source-token"
+	metricsSourceMountPath    = "/etc/metrics-source"
+	metricsSourceCAKey        = "ca.crt"
+	metricsSourceCertKey      = "tls.crt"
+	metricsSourceKeyKey       = "tls.key"
+	metricsSourceTokenKey     = "token"
+	serviceAccountTokenFile   = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCAFile      = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	metricsSourceProbePath    = "/api/v1/query?query=vector(1)"
+	metricsSourceProbeTimeout = 10 * time.Second
+	metricsSourceRetryPeriod  = time.Minute
+)
+
+var (
+	// checkMetricsSource checks if the configured metrics source is reachable
+	checkMetricsSource = probeMetricsSource
+)
+
+// getMetricsSourceRendering returns the args, volumes and mounts for the metrics collector to read
+// from the configured metrics source, the caFile is used if no CA is configured for the source.
+// No CA file is passed to the metrics collector if both are empty.
+func getMetricsSourceRendering(source *MetricsSource, caFile string) ([]string, []corev1.Volume, []corev1.VolumeMount) {
+	args := []string{}
+	volumes := []corev1.Volume{}
+	mounts := []corev1.VolumeMount{}
+	addSecret := func(volName string, secretName string) string {
+		volumes = append(volumes, corev1.Volume{
+			Name: volName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secretName,
+				},
+			},
+		})
+		mountPath := metricsSourceMountPath + "/" + strings.TrimPrefix(volName, "metrics-source-")
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volName,
+			MountPath: mountPath,
+		})
+		return mountPath
+	}
+
+	tokenFile := serviceAccountTokenFile
+	if source != nil {
+		if source.TLSConfig != nil && source.TLSConfig.CA != "" {
+			caFile = addSecret(metricsSourceCAVolName, source.TLSConfig.CA) + "/" + metricsSourceCAKey
+		}
+		if source.TLSConfig != nil && source.TLSConfig.Cert != "" {
+			certPath := addSecret(metricsSourceCertVolName, source.TLSConfig.Cert)
+			args = append(args,
+				"--from-cert-file="+certPath+"/"+metricsSourceCertKey,
+				"--from-key-file="+certPath+"/"+metricsSourceKeyKey)
+		}
+		if source.BearerTokenSecret != "" {
+			tokenFile = addSecret(metricsSourceTokenVolName, source.BearerTokenSecret) + "/" + metricsSourceTokenKey
+		}
+	}
+	args = append([]string{"--from-token-file=" + tokenFile}, args...)
+	if caFile != "" {
+		args = append([]string{"--from-ca-file=" + caFile}, args...)
+	}
+	return args, volumes, mounts
+}
+
+// metricsSourceProbe keeps the result of the last probe of the metrics source, so that the reconciliations
+// triggered by the other changes do not query the metrics source again
+type metricsSourceProbe struct {
+	mutex   sync.Mutex
+	source  string
+	checked time.Time
+	err     error
+}
+
+// check returns the result of the last probe, the metrics source is probed again once metricsSourceRetryPeriod
+// passed, or when its config is changed
+func (p *metricsSourceProbe) check(ctx context.Context, c client.Client, ns string, source *MetricsSource, now time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	data, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+	if p.source == string(data) && now.Sub(p.checked) < metricsSourceRetryPeriod {
+		return p.err
+	}
+	p.err = checkMetricsSource(ctx, c, ns, source)
+	p.source = string(data)
+	p.checked = now
+	return p.err
+}
+
+// probeMetricsSource runs a trivial query against the metrics source with the configured credentials. Without a
+// bearer token secret, the token of the operator's service account is used, which is the service account of the
+// metrics collector unless another one is configured.
+func probeMetricsSource(ctx context.Context, c client.Client, ns string, source *MetricsSource) error {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	tlsConfig := &tls.Config{RootCAs: pool}
+	if source.TLSConfig != nil && source.TLSConfig.CA != "" {
+		ca, err := getSecretData(ctx, c, ns, source.TLSConfig.CA, metricsSourceCAKey)
+		if err != nil {
+			return err
+		}
+		pool.AppendCertsFromPEM(ca)
+	} else {
+		// the service CA bundle is used by default to verify the in-cluster servers
+		cm := &corev1.ConfigMap{}
+		err = c.Get(ctx, types.NamespacedName{Name: caConfigmapName, Namespace: ns}, cm)
+		if err == nil {
+			pool.AppendCertsFromPEM([]byte(cm.Data["service-ca.crt"]))
+		}
+	}
+	if source.TLSConfig != nil && source.TLSConfig.Cert != "" {
+		cert, err := getSecretData(ctx, c, ns, source.TLSConfig.Cert, metricsSourceCertKey)
+		if err != nil {
+			return err
+		}
+		key, err := getSecretData(ctx, c, ns, source.TLSConfig.Cert, metricsSourceKeyKey)
+		if err != nil {
+			return err
+		}
+		keyPair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return fmt.Errorf("invalid client certificate in secret %s: %v", source.TLSConfig.Cert, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{keyPair}
+	}
+
+	var token []byte
+	if source.BearerTokenSecret != "" {
+		token, err = getSecretData(ctx, c, ns, source.BearerTokenSecret, metricsSourceTokenKey)
+		if err != nil {
+			return err
+		}
+	} else {
+		token, _ = ioutil.ReadFile(serviceAccountTokenFile)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(source.ServerURL, "/")+metricsSourceProbePath, nil)
+	if err != nil {
+		return err
+	}
+	if len(token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	httpClient := &http.Client{
+		Timeout:   metricsSourceProbeTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach metrics source %s: %v", source.ServerURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metrics source %s returned status %s", source.ServerURL, resp.Status)
+	}
+	return nil
+}
+
+func getSecretData(ctx context.Context, c client.Client, ns string, name string, key string) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: ns}, secret)
+	if err != nil {
+		log.Error(err, "Failed to get secret", "name", name)
+		return nil, err
+	}
+	data, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("no %s in secret %s", key, name)
+	}
+	return data, nil
+}
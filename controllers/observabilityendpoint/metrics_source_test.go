@@ -0,0 +1,139 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetMetricsSourceRendering(t *testing.T) {
+	args, volumes, mounts := getMetricsSourceRendering(nil, "/ca/service-ca.crt")
+	expected := []string{
+		"--from-ca-file=/ca/service-ca.crt",
+		"--from-token-file=" + serviceAccountTokenFile,
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Wrong args for default metrics source: (%v)", args)
+	}
+	if len(volumes) != 0 || len(mounts) != 0 {
+		t.Fatalf("No volume expected for default metrics source: (%v)", volumes)
+	}
+
+	args, _, _ = getMetricsSourceRendering(nil, "")
+	expected = []string{"--from-token-file=" + serviceAccountTokenFile}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Wrong args for metrics source without CA: (%v)", args)
+	}
+
+	source := &MetricsSource{
+		ServerURL: "https://thanos-querier:9091",
+		TLSConfig: &MetricsSourceTLSConfig{
+			CA:   "ca-secret",
+			Cert: "cert-secret",
+		},
+		BearerTokenSecret: "token-secret",
+	}
+	args, volumes, mounts = getMetricsSourceRendering(source, "/ca/service-ca.crt")
+	expected = []string{
+		"--from-ca-file=/etc/metrics-source/ca/ca.crt",
+		"--from-token-file=/etc/metrics-source/token/token",
+		"--from-cert-file=/etc/metrics-source/cert/tls.crt",
+		"--from-key-file=/etc/metrics-source/cert/tls.key",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Wrong args for custom metrics source: (%v)", args)
+	}
+	if len(volumes) != 3 || len(mounts) != 3 {
+		t.Fatalf("Wrong volumes for custom metrics source: (%v)", volumes)
+	}
+	if volumes[0].Secret.SecretName != "ca-secret" || mounts[0].MountPath != "/etc/metrics-source/ca" {
+		t.Fatalf("Wrong CA volume for custom metrics source: (%v) (%v)", volumes[0], mounts[0])
+	}
+}
+
+func TestProbeMetricsSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testBearerToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "token-secret",
+			Namespace: testNamespace,
+		},
+		Data: map[string][]byte{
+			metricsSourceTokenKey: []byte(testBearerToken + "\n"),
+		},
+	}
+	ctx := context.TODO()
+	c := fake.NewFakeClient(tokenSecret)
+
+	err := probeMetricsSource(ctx, c, testNamespace, &MetricsSource{
+		ServerURL:         server.URL,
+		BearerTokenSecret: "token-secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to probe metrics source: (%v)", err)
+	}
+
+	err = probeMetricsSource(ctx, c, testNamespace, &MetricsSource{
+		ServerURL: server.URL,
+	})
+	if err == nil {
+		t.Fatal("Miss the error for unauthorized metrics source")
+	}
+
+	err = probeMetricsSource(ctx, c, testNamespace, &MetricsSource{
+		ServerURL:         server.URL,
+		BearerTokenSecret: "missing-secret",
+	})
+	if err == nil {
+		t.Fatal("Miss the error for missing bearer token secret")
+	}
+}
+
+func TestMetricsSourceProbeCache(t *testing.T) {
+	savedCheck := checkMetricsSource
+	defer func() { checkMetricsSource = savedCheck }()
+	probes := 0
+	checkMetricsSource = func(ctx context.Context, c client.Client, ns string, source *MetricsSource) error {
+		probes++
+		return fmt.Errorf("connection refused")
+	}
+
+	ctx := context.TODO()
+	now := time.Now()
+	p := &metricsSourceProbe{}
+	source := &MetricsSource{ServerURL: "https://thanos-querier:9091"}
+	if err := p.check(ctx, nil, testNamespace, source, now); err == nil {
+		t.Fatal("Unreachable metrics source not reported")
+	}
+	if err := p.check(ctx, nil, testNamespace, source, now.Add(time.Second)); err == nil || probes != 1 {
+		t.Fatalf("Last probe not kept, probes: %d (%v)", probes, err)
+	}
+	_ = p.check(ctx, nil, testNamespace, &MetricsSource{ServerURL: "https://prometheus:9091"}, now.Add(time.Second))
+	if probes != 2 {
+		t.Fatal("Changed metrics source not probed")
+	}
+	_ = p.check(ctx, nil, testNamespace, &MetricsSource{ServerURL: "https://prometheus:9091"},
+		now.Add(time.Second+metricsSourceRetryPeriod))
+	if probes != 3 {
+		t.Fatal("Metrics source not probed again after the retry period")
+	}
+}
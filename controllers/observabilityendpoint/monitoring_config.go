@@ -0,0 +1,416 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cmomanifests "github.com/openshift/cluster-monitoring-operator/pkg/manifests"
+)
+
+// defaultMonitoringConfigIndent is the indentation of the monitoring configs created by the operator
+const defaultMonitoringConfigIndent = 2
+
+// The monitoring configs are edited as yaml node trees rather than the CMO config structs, so that the fields
+// unknown to the vendored CMO version, the comments and the order of the keys are kept as they are. Only the keys
+// owned by the operator are changed.
+
+// parseMonitoringConfig returns the yaml document of the config, its root is always a mapping
+func parseMonitoringConfig(data string) (*yaml.Node, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(data), doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		// the config is empty or only has comments
+		comment := doc.HeadComment
+		doc = &yaml.Node{Kind: yaml.DocumentNode, HeadComment: comment}
+		doc.Content = []*yaml.Node{newMappingNode()}
+	}
+	switch root := doc.Content[0]; {
+	case root.Kind == yaml.MappingNode:
+	case root.Kind == yaml.ScalarNode && root.ShortTag() == "!!null":
+		doc.Content[0] = newMappingNode()
+	default:
+		return nil, fmt.Errorf("the config is not a mapping")
+	}
+	return doc, nil
+}
+
+// getMonitoringConfigIndent returns the indentation of the nested mappings in the config, so that the config is
+// written back with the indentation of the customer. The default one is returned if there is no nested mapping.
+func getMonitoringConfigIndent(data string) int {
+	indent := 0
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		spaces := len(line) - len(trimmed)
+		// the sequence items and the comments don't tell the indentation of the mappings
+		if spaces == 0 || trimmed == "" || strings.HasPrefix(trimmed, "-") || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if indent == 0 || spaces < indent {
+			indent = spaces
+		}
+	}
+	// the yaml encoder only supports an indentation from 2 to 9 spaces
+	if indent < 2 || indent > 9 {
+		return defaultMonitoringConfigIndent
+	}
+	return indent
+}
+
+// marshalMonitoringConfig returns the yaml of the document with the indentation. The sequences are always
+// indented within their mapping, the compact sequences of the customer are indented when the config is written back.
+func marshalMonitoringConfig(doc *yaml.Node, indent int) (string, error) {
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(doc); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func newMappingNode() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+func newStringNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// resolveNode returns the node referred by an alias
+func resolveNode(n *yaml.Node) *yaml.Node {
+	for n != nil && n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+// getMappingValue returns the value of the key in the mapping node, nil if it's not found
+func getMappingValue(m *yaml.Node, key string) *yaml.Node {
+	m = resolveNode(m)
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return resolveNode(m.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets the value of the key in the mapping node, a new key is added at the end
+func setMappingValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			// the comments of the former value are kept
+			value.HeadComment, value.LineComment, value.FootComment =
+				m.Content[i+1].HeadComment, m.Content[i+1].LineComment, m.Content[i+1].FootComment
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, newStringNode(key), value)
+}
+
+// removeMappingKey removes the key from the mapping node, it returns true if it's removed
+func removeMappingKey(m *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// getOrAddMapping returns the mapping value of the key in the mapping node, it's added if it's missing or null
+func getOrAddMapping(m *yaml.Node, key string) *yaml.Node {
+	value := getMappingValue(m, key)
+	if value == nil || value.Kind != yaml.MappingNode {
+		value = newMappingNode()
+		setMappingValue(m, key, value)
+	}
+	return value
+}
+
+// getNodeValue returns the node as a generic value, normalized as json so that it can be compared with the
+// values of the CMO config structs
+func getNodeValue(n *yaml.Node) interface{} {
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		log.Error(err, "failed to decode the monitoring config")
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(data, &v)
+	}
+	if err != nil {
+		log.Error(err, "failed to convert the monitoring config")
+		return nil
+	}
+	return v
+}
+
+// getAlertmanagerConfigValues returns the alertmanager configs as generic yaml values
+func getAlertmanagerConfigValues(configs []cmomanifests.AdditionalAlertmanagerConfig) ([]map[string]interface{},
+	error) {
+	values := []map[string]interface{}{}
+	for _, c := range configs {
+		data, err := json.Marshal(c)
+		if err != nil {
+			log.Error(err, "failed to marshal the hub alertmanager config")
+			return nil, err
+		}
+		amConfig := map[string]interface{}{}
+		if err := json.Unmarshal(data, &amConfig); err != nil {
+			log.Error(err, "failed to unmarshal the hub alertmanager config")
+			return nil, err
+		}
+		values = append(values, amConfig)
+	}
+	return values, nil
+}
+
+// getAlertmanagerConfigItems returns the alertmanager configs under the key of the component as generic values
+func getAlertmanagerConfigItems(config *yaml.Node, component string, key string) []interface{} {
+	items := []interface{}{}
+	amConfigs := getMappingValue(getMappingValue(config, component), key)
+	if amConfigs == nil || amConfigs.Kind != yaml.SequenceNode {
+		return items
+	}
+	for _, c := range amConfigs.Content {
+		items = append(items, getNodeValue(c))
+	}
+	return items
+}
+
+// addAlertmanagerConfigs adds or updates the hubs' alertmanager configs under the key of each component, and removes
+// the ones of the additional hubs which are gone. It returns true if the config is changed, and the removed configs.
+func addAlertmanagerConfigs(config *yaml.Node, components []string, key string,
+	amConfigs []map[string]interface{}) (bool, []interface{}, error) {
+	keep := map[string]bool{}
+	for _, amConfig := range amConfigs {
+		keep[getAlertmanagerConfigValueCAName(amConfig)] = true
+	}
+	changed, removed := removeAlertmanagerConfigs(config, components, key, keep)
+	for _, component := range components {
+		componentConfig := getOrAddMapping(config, component)
+		existing := getMappingValue(componentConfig, key)
+		if existing == nil || existing.Kind != yaml.SequenceNode {
+			existing = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			setMappingValue(componentConfig, key, existing)
+		}
+		for _, amConfig := range amConfigs {
+			index := -1
+			for i, c := range existing.Content {
+				if getAlertmanagerConfigValueCAName(getNodeValue(c)) == getAlertmanagerConfigValueCAName(amConfig) {
+					index = i
+					break
+				}
+			}
+			if index != -1 && reflect.DeepEqual(getNodeValue(existing.Content[index]), interface{}(amConfig)) {
+				continue
+			}
+			node := &yaml.Node{}
+			if err := node.Encode(amConfig); err != nil {
+				log.Error(err, "failed to encode the hub alertmanager config")
+				return false, nil, err
+			}
+			if index == -1 {
+				existing.Content = append(existing.Content, node)
+			} else {
+				node.HeadComment, node.LineComment = existing.Content[index].HeadComment,
+					existing.Content[index].LineComment
+				existing.Content[index] = node
+			}
+			changed = true
+		}
+	}
+	return changed, removed, nil
+}
+
+// removeAlertmanagerConfigs removes the hubs' alertmanager configs which are not kept under the key of each
+// component, and the sections which become empty. It returns true if the config is changed, and the removed configs.
+func removeAlertmanagerConfigs(config *yaml.Node, components []string, key string,
+	keep map[string]bool) (bool, []interface{}) {
+	changed := false
+	removed := []interface{}{}
+	for _, component := range components {
+		componentConfig := getMappingValue(config, component)
+		amConfigs := getMappingValue(componentConfig, key)
+		if amConfigs == nil || amConfigs.Kind != yaml.SequenceNode {
+			continue
+		}
+		kept := []*yaml.Node{}
+		for _, c := range amConfigs.Content {
+			value := getNodeValue(c)
+			if !isHubAlertmanagerConfig(value) || keep[getAlertmanagerConfigValueCAName(value)] {
+				kept = append(kept, c)
+			} else {
+				removed = append(removed, value)
+			}
+		}
+		if len(kept) == len(amConfigs.Content) {
+			continue
+		}
+		changed = true
+		amConfigs.Content = kept
+		if len(kept) == 0 {
+			removeMappingKey(componentConfig, key)
+		}
+		if len(componentConfig.Content) == 0 {
+			removeMappingKey(config, component)
+		}
+	}
+	return changed, removed
+}
+
+// deleteRemovedAlertmanagerConfigSecrets deletes the secrets of the additional hubs referred by the removed
+// alertmanager configs, the ones of the hub of the addon are managed by the caller
+func deleteRemovedAlertmanagerConfigSecrets(ctx context.Context, client client.Client, removed []interface{},
+	ns string) error {
+	for _, c := range removed {
+		if !isAdditionalHubAmRouterCASecret(getAlertmanagerConfigValueCAName(c)) {
+			continue
+		}
+		amConfig := cmomanifests.AdditionalAlertmanagerConfig{}
+		data, err := json.Marshal(c)
+		if err == nil {
+			err = json.Unmarshal(data, &amConfig)
+		}
+		if err != nil {
+			log.Error(err, "failed to parse the alertmanager config")
+			return err
+		}
+		err = deleteAlertmanagerConfigSecrets(ctx, client, []cmomanifests.AdditionalAlertmanagerConfig{amConfig}, ns)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAlertmanagerConfigValueCAName returns the name of the secret with the CA of the alertmanager config
+func getAlertmanagerConfigValueCAName(c interface{}) string {
+	amConfig, ok := c.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	tlsConfig, ok := amConfig["tlsConfig"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	ca, ok := tlsConfig["ca"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := ca["name"].(string)
+	return name
+}
+
+// isHubAlertmanagerConfig checks if the alertmanager config refers to the hub-alertmanager-router-ca secret,
+// or to the one of an additional hub
+func isHubAlertmanagerConfig(c interface{}) bool {
+	name := getAlertmanagerConfigValueCAName(c)
+	return name == hubAmRouterCASecretName || isAdditionalHubAmRouterCASecret(name)
+}
+
+// setExternalLabelValues sets the labels in the external labels under the key of the component, the other labels
+// are kept as they are. It returns true if the config is changed.
+func setExternalLabelValues(config *yaml.Node, component string, key string, labels map[string]string) bool {
+	externalLabels := getOrAddMapping(getOrAddMapping(config, component), key)
+	changed := false
+	for _, k := range sortedKeys(labels) {
+		if v := getMappingValue(externalLabels, k); v == nil || v.Kind != yaml.ScalarNode || v.Value != labels[k] {
+			setMappingValue(externalLabels, k, newStringNode(labels[k]))
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeExternalLabelValues removes the labels from the external labels under the key of the component, and the
+// sections which become empty. It returns true if the config is changed.
+func removeExternalLabelValues(config *yaml.Node, component string, key string, labels []string) bool {
+	componentConfig := getMappingValue(config, component)
+	externalLabels := getMappingValue(componentConfig, key)
+	if externalLabels == nil || externalLabels.Kind != yaml.MappingNode {
+		return false
+	}
+	changed := false
+	for _, k := range labels {
+		if removeMappingKey(externalLabels, k) {
+			changed = true
+		}
+	}
+	if changed && len(externalLabels.Content) == 0 {
+		removeMappingKey(componentConfig, key)
+		if len(componentConfig.Content) == 0 {
+			removeMappingKey(config, component)
+		}
+	}
+	return changed
+}
+
+// getExternalLabelValues returns the external labels under the key of the component as strings
+func getExternalLabelValues(config *yaml.Node, component string, key string) map[string]string {
+	labels := map[string]string{}
+	externalLabels := getMappingValue(getMappingValue(config, component), key)
+	if externalLabels == nil || externalLabels.Kind != yaml.MappingNode {
+		return labels
+	}
+	for i := 0; i+1 < len(externalLabels.Content); i += 2 {
+		if v := resolveNode(externalLabels.Content[i+1]); v.Kind == yaml.ScalarNode {
+			labels[externalLabels.Content[i].Value] = v.Value
+		} else {
+			labels[externalLabels.Content[i].Value] = fmt.Sprint(getNodeValue(v))
+		}
+	}
+	return labels
+}
+
+// unchangedPrecondition returns the delete option which fails with a conflict when the object is changed
+// since it was read
+func unchangedPrecondition(obj client.Object) client.DeleteOption {
+	resourceVersion := obj.GetResourceVersion()
+	return client.Preconditions{ResourceVersion: &resourceVersion}
+}
+
+// pruneEmptyValues returns the yaml value without the null values and the empty maps and lists, which are the same
+// as the missing ones in the monitoring configs
+func pruneEmptyValues(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		pruned := map[string]interface{}{}
+		for k, e := range value {
+			if e = pruneEmptyValues(e); e != nil {
+				pruned[k] = e
+			}
+		}
+		if len(pruned) == 0 {
+			return nil
+		}
+		return pruned
+	case []interface{}:
+		if len(value) == 0 {
+			return nil
+		}
+		return value
+	}
+	return v
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/util"
+	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+)
+
+const (
+	// configDriftWindow is how long the MonitoringConfigDrift condition is reported after a drift is reapplied
+	configDriftWindow = 10 * time.Minute
+	// configDriftEventReason is the reason of the event emitted when a drift is reapplied
+	configDriftEventReason = "MonitoringConfigDrift"
+)
+
+// getDesiredConfigHash returns the hash of the labels and the alertmanager configs applied in the cluster
+// monitoring config, a drift is only detected when they are already applied
+func getDesiredConfigHash(clusterID string, externalLabels map[string]string,
+	amConfigs []map[string]interface{}) (string, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"clusterID":           clusterID,
+		"externalLabels":      externalLabels,
+		"alertmanagerConfigs": amConfigs,
+	})
+	if err != nil {
+		log.Error(err, "failed to marshal the desired cluster monitoring config")
+		return "", err
+	}
+	return getConfigHash(string(data)), nil
+}
+
+// getClusterMonitoringConfigDrift returns the differences between the cluster monitoring config and the labels
+// and the alertmanager configs applied by the operator
+func getClusterMonitoringConfigDrift(config *yaml.Node, clusterID string,
+	externalLabels map[string]string, owned []string, amConfigs []map[string]interface{}) []string {
+	drift := []string{}
+	found := getExternalLabelValues(config, cmoPrometheusK8sKey, cmoExternalLabelsKey)
+	labels := map[string]string{clusterLabelKeyForAlerts: clusterID}
+	for _, k := range owned {
+		if v, ok := externalLabels[k]; ok {
+			labels[k] = v
+		}
+	}
+	for _, k := range sortedKeys(labels) {
+		if v, ok := found[k]; !ok {
+			drift = append(drift, fmt.Sprintf("external label %s is removed", k))
+		} else if v != labels[k] {
+			drift = append(drift, fmt.Sprintf("external label %s is changed to %q", k, v))
+		}
+	}
+
+	existing := getAlertmanagerConfigItems(config, cmoPrometheusK8sKey, cmoAlertmanagerConfigsKey)
+	for _, amConfig := range amConfigs {
+		name := getAlertmanagerConfigValueCAName(amConfig)
+		var foundConfig interface{}
+		for _, c := range existing {
+			if getAlertmanagerConfigValueCAName(c) == name {
+				foundConfig = c
+				break
+			}
+		}
+		if foundConfig == nil {
+			drift = append(drift, fmt.Sprintf("alertmanager config with the %s CA is removed", name))
+		} else if !reflect.DeepEqual(foundConfig, interface{}(amConfig)) {
+			drift = append(drift, fmt.Sprintf("alertmanager config with the %s CA is changed", name))
+		}
+	}
+	return drift
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setConfigDriftCondition sets the MonitoringConfigDrift condition to True with the drift when it's reapplied,
+// and keeps it True for configDriftWindow after the last drift
+func setConfigDriftCondition(obsAddon *oav1beta1.ObservabilityAddon, drift []string, now time.Time) {
+	if len(drift) != 0 {
+		// the transition time is the time of the last drift
+		util.RemoveStatusCondition(&obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
+		util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+			Type:               util.ConditionMonitoringConfigDrift,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(now),
+			Reason:             "DriftReapplied",
+			Message:            "Changes made by others in cluster-monitoring-config are reverted: " + strings.Join(drift, ", "),
+		})
+		return
+	}
+	c := util.FindStatusCondition(obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
+	if c != nil && c.Status == metav1.ConditionTrue && now.Sub(c.LastTransitionTime.Time) < configDriftWindow {
+		return
+	}
+	util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+		Type:    util.ConditionMonitoringConfigDrift,
+		Status:  metav1.ConditionFalse,
+		Reason:  "InSync",
+		Message: "No drift in cluster-monitoring-config",
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	yamltool "github.com/ghodss/yaml"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/util"
+	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+)
+
+func TestClusterMonitoringConfigDrift(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	ctx := context.TODO()
+	c := fake.NewFakeClient(newAMAccessorSecret(), newClusterMonitoringConfigCM(`
+prometheusK8s:
+  retention: 24h`))
+	externalLabels := map[string]string{"region": "us-east-1"}
+	apply := func(labels map[string]string) []string {
+		_, drift, err := createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID, labels, c, testNamespace)
+		if err != nil {
+			t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+		}
+		return drift
+	}
+
+	if drift := apply(externalLabels); len(drift) != 0 {
+		t.Fatalf("Drift is reported for the first change: %v", drift)
+	}
+	if drift := apply(externalLabels); len(drift) != 0 {
+		t.Fatalf("Drift is reported without change: %v", drift)
+	}
+
+	// the alertmanager config and the cluster label are edited by the customer
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	cm.Data[clusterMonitoringConfigDataKey] = `
+prometheusK8s:
+  externalLabels:
+    cluster: edited
+    region: us-east-1
+  retention: 48h`
+	err = c.Update(ctx, cm)
+	if err != nil {
+		t.Fatalf("Failed to update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	drift := apply(externalLabels)
+	expected := []string{
+		`external label cluster is changed to "edited"`,
+		"alertmanager config with the " + hubAmRouterCASecretName + " CA is removed",
+	}
+	if !reflect.DeepEqual(drift, expected) {
+		t.Fatalf("Wrong drift, expected: %v, actual: %v", expected, drift)
+	}
+	cm = &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	config := map[string]interface{}{}
+	err = yamltool.Unmarshal([]byte(cm.Data[clusterMonitoringConfigDataKey]), &config)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal the cluster-monitoring-config configmap: (%v)", err)
+	}
+	pmK8sConfig := config[cmoPrometheusK8sKey].(map[string]interface{})
+	if pmK8sConfig["retention"] != "48h" ||
+		pmK8sConfig[cmoExternalLabelsKey].(map[string]interface{})["cluster"] != testClusterID ||
+		len(pmK8sConfig[cmoAlertmanagerConfigsKey].([]interface{})) != 1 {
+		t.Fatalf("The drift is not reapplied:\n%s", cm.Data[clusterMonitoringConfigDataKey])
+	}
+
+	// the changes of the desired config are not drift
+	if drift := apply(map[string]string{"region": "eu-west-1"}); len(drift) != 0 {
+		t.Fatalf("Drift is reported for the change of the desired config: %v", drift)
+	}
+}
+
+func TestSetConfigDriftCondition(t *testing.T) {
+	now := time.Now()
+	obsAddon := &oav1beta1.ObservabilityAddon{}
+
+	setConfigDriftCondition(obsAddon, nil, now)
+	c := util.FindStatusCondition(obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
+	if c == nil || c.Status != metav1.ConditionFalse {
+		t.Fatalf("Wrong condition without drift: %v", c)
+	}
+
+	setConfigDriftCondition(obsAddon, []string{"external label cluster is removed"}, now)
+	c = util.FindStatusCondition(obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
+	if c == nil || c.Status != metav1.ConditionTrue || c.Reason != "DriftReapplied" {
+		t.Fatalf("Wrong condition with drift: %v", c)
+	}
+
+	// the condition is kept for the drift window
+	setConfigDriftCondition(obsAddon, nil, now.Add(time.Minute))
+	c = util.FindStatusCondition(obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
+	if c.Status != metav1.ConditionTrue {
+		t.Fatalf("The condition is not kept in the drift window: %v", c)
+	}
+
+	// a new drift restarts the window
+	setConfigDriftCondition(obsAddon, []string{"external label cluster is removed"}, now.Add(5*time.Minute))
+	setConfigDriftCondition(obsAddon, nil, now.Add(configDriftWindow+time.Minute))
+	c = util.FindStatusCondition(obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
+	if c.Status != metav1.ConditionTrue {
+		t.Fatalf("The window is not restarted by the new drift: %v", c)
+	}
+
+	setConfigDriftCondition(obsAddon, nil, now.Add(5*time.Minute+configDriftWindow))
+	c = util.FindStatusCondition(obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
+	if c.Status != metav1.ConditionFalse || c.Reason != "InSync" {
+		t.Fatalf("The condition is not reset after the drift window: %v", c)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetMonitoringConfigIndent(t *testing.T) {
+	caseList := []struct {
+		name     string
+		data     string
+		expected int
+	}{
+		{name: "empty", data: "", expected: defaultMonitoringConfigIndent},
+		{name: "no nested mapping", data: "enableUserWorkload: true\n", expected: defaultMonitoringConfigIndent},
+		{name: "2 spaces", data: "prometheusK8s:\n  retention: 24h\n", expected: 2},
+		{name: "4 spaces", data: "prometheusK8s:\n    externalLabels:\n        env: prod\n", expected: 4},
+		{
+			name:     "compact sequences",
+			data:     "prometheusK8s:\n   tolerations:\n   - key: infra\n     effect: NoSchedule\n",
+			expected: 3,
+		},
+		{name: "indented comment", data: "prometheusK8s:\n # retention\n    retention: 24h\n", expected: 4},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			if indent := getMonitoringConfigIndent(c.data); indent != c.expected {
+				t.Fatalf("Wrong indentation, expected: %d, actual: %d", c.expected, indent)
+			}
+		})
+	}
+}
+
+func TestMonitoringConfigRoundTrip(t *testing.T) {
+	// the configs are written back without any change
+	for _, name := range []string{"monitoring-config-indent-2.golden", "monitoring-config-indent-4.golden"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatalf("Failed to read the config: (%v)", err)
+			}
+			doc, err := parseMonitoringConfig(string(data))
+			if err != nil {
+				t.Fatalf("Failed to parse the config: (%v)", err)
+			}
+			written, err := marshalMonitoringConfig(doc, getMonitoringConfigIndent(string(data)))
+			if err != nil {
+				t.Fatalf("Failed to marshal the config: (%v)", err)
+			}
+			checkGolden(t, name, []byte(written))
+		})
+	}
+
+	// the compact sequences are indented within their mapping
+	data := `prometheusK8s:
+  retention: 24h
+  tolerations:
+  - key: infra
+    effect: NoSchedule
+`
+	doc, err := parseMonitoringConfig(data)
+	if err != nil {
+		t.Fatalf("Failed to parse the config: (%v)", err)
+	}
+	written, err := marshalMonitoringConfig(doc, getMonitoringConfigIndent(data))
+	if err != nil {
+		t.Fatalf("Failed to marshal the config: (%v)", err)
+	}
+	checkGolden(t, "monitoring-config-compact-sequences.golden", []byte(written))
+}
+
+func TestClusterMonitoringConfigIndent(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	ctx := context.TODO()
+	c := fake.NewFakeClient(newAMAccessorSecret(), newClusterMonitoringConfigCM(`prometheusK8s:
+    retention: 48h
+`))
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	config := cm.Data[clusterMonitoringConfigDataKey]
+	if !strings.Contains(config, "\n    retention: 48h\n") || !strings.Contains(config, "\n        cluster: "+testClusterID) {
+		t.Fatalf("Indentation of the config not kept:\n%s", config)
+	}
+}
@@ -4,21 +4,29 @@ package observabilityendpoint
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/IBM/controller-filtered-cache/filteredcache"
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
 	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/util"
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
 )
@@ -38,16 +46,18 @@ const (
 	promNamespace     = "openshift-monitoring"
 )
 
-var (
-	namespace    = os.Getenv("WATCH_NAMESPACE")
-	hubNamespace = os.Getenv("HUB_NAMESPACE")
-)
-
 // ObservabilityAddonReconciler reconciles a ObservabilityAddon object
 type ObservabilityAddonReconciler struct {
 	Client    client.Client
 	Scheme    *runtime.Scheme
 	HubClient client.Client
+	// Config is the operator configuration, it's applied when the controller is set up
+	Config *config.OperatorConfig
+	// Recorder emits the events of the observabilityaddon, e.g. when a drift of the monitoring config is reapplied
+	Recorder record.EventRecorder
+
+	sourceProbe  metricsSourceProbe
+	statsHistory collectorStatsHistory
 }
 
 // +kubebuilder:rbac:groups=observability.open-cluster-management.io.open-cluster-management.io,resources=observabilityaddons,verbs=get;list;watch;create;update;patch;delete
@@ -62,124 +72,314 @@ func (r *ObservabilityAddonReconciler) Reconcile(ctx context.Context, req ctrl.R
 	log := log.WithValues("Request.Namespace", req.Namespace, "Request.Name", req.Name)
 	log.Info("Reconciling")
 
-	// Fetch the ObservabilityAddon instance in hub cluster
-	hubObsAddon := &oav1beta1.ObservabilityAddon{}
-	err := r.HubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: hubNamespace}, hubObsAddon)
-	if err != nil {
-		log.Error(err, "Failed to get observabilityaddon", "namespace", hubNamespace)
-		return ctrl.Result{}, err
-	}
-
 	// Fetch the ObservabilityAddon instance in local cluster
 	obsAddon := &oav1beta1.ObservabilityAddon{}
-	err = r.Client.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: namespace}, obsAddon)
+	err := r.Client.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: r.Config.Namespace}, obsAddon)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			obsAddon = nil
 		} else {
-			log.Error(err, "Failed to get observabilityaddon", "namespace", namespace)
+			log.Error(err, "Failed to get observabilityaddon", "namespace", r.Config.Namespace)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Fetch the ObservabilityAddon instance in hub cluster, the last known one is used while the hub is unreachable
+	hubObsAddon := &oav1beta1.ObservabilityAddon{}
+	hubErr := r.HubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: r.Config.HubNamespace}, hubObsAddon)
+	if hubErr != nil {
+		log.Error(hubErr, "Failed to get observabilityaddon", "namespace", r.Config.HubNamespace)
+		if errors.IsNotFound(hubErr) {
+			return ctrl.Result{}, hubErr
+		}
+		hubObsAddon, err = getCachedHubAddon(ctx, r.Client, r.Config.Namespace)
+		if err != nil || hubObsAddon == nil {
+			if obsAddon != nil {
+				setHubConnectedCondition(obsAddon, hubErr)
+				util.UpdateStatus(ctx, r.Client, obsAddon)
+			}
+			return ctrl.Result{}, hubErr
+		}
+		log.Info("Hub cluster is unreachable, reconciling with the last known observabilityaddon")
+	} else {
+		err = cacheHubAddon(ctx, r.Client, r.Config.Namespace, hubObsAddon)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The hub observabilityaddon is the source of truth, the local one is cleaned up once it's deleted
+	deleteFlag := hubObsAddon.GetDeletionTimestamp() != nil
+	if !deleteFlag {
+		obsAddon, err = syncLocalAddon(ctx, r.Client, r.Config.Namespace, hubObsAddon, obsAddon)
+		if err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	// Init finalizers
-	deleteFlag := false
+	// Init finalizers, they are resynchronized once the hub is reachable again
+	if hubErr == nil {
+		deleted, err := r.initFinalization(ctx, deleteFlag, hubObsAddon)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if deleted || deleteFlag {
+			return ctrl.Result{}, deleteLocalAddon(ctx, r.Client, r.Config.Namespace, obsAddon)
+		}
+	}
 	if obsAddon == nil {
-		deleteFlag = true
+		// there is no local observabilityaddon to reconcile while the hub one is being deleted
+		return ctrl.Result{}, hubErr
+	}
+	hubConnected := util.FindStatusCondition(obsAddon.Status.Conditions, util.ConditionHubConnected)
+	wasConnected := hubConnected != nil && hubConnected.Status == metav1.ConditionTrue
+	setHubConnectedCondition(obsAddon, hubErr)
+	if wasConnected != (hubErr == nil) {
+		// the other conditions may not be reported in this reconciliation
+		util.UpdateStatus(ctx, r.Client, obsAddon)
 	}
-	deleted, err := r.initFinalization(ctx, deleteFlag, hubObsAddon)
+
+	addonConfig, err := getAddonConfig(ctx, r.Client, r.Config.Namespace)
 	if err != nil {
+		util.ReportStatusWithMessage(ctx, r.Client, obsAddon, "InvalidAddonConfig", err.Error())
 		return ctrl.Result{}, err
 	}
-	if deleted || deleteFlag {
-		return ctrl.Result{}, nil
-	}
 
-	// If no prometheus service found, set status as NotSupported
-	promSvc := &corev1.Service{}
-	err = r.Client.Get(ctx, types.NamespacedName{Name: promSvcName,
-		Namespace: promNamespace}, promSvc)
+	platform, err := getPlatform(ctx, r.Client)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			log.Error(err, "OCP prometheus service does not exist")
+		return ctrl.Result{}, err
+	}
+
+	// Out of OpenShift, use the Prometheus managed by the Prometheus Operator if no metrics source is configured
+	if getMetricsSource(addonConfig) == nil && platform == platformKubernetes {
+		addonConfig.MetricsSource, err = discoverPrometheus(ctx, r.Client)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		// If no prometheus found, set status as NotSupported and check again later
+		if addonConfig.MetricsSource == nil {
+			log.Info("No prometheus found in the cluster")
 			util.ReportStatus(ctx, r.Client, obsAddon, "NotSupported")
-			return ctrl.Result{}, nil
+			return ctrl.Result{RequeueAfter: metricsSourceRetryPeriod}, nil
 		}
-		log.Error(err, "Failed to check prometheus resource")
-		return ctrl.Result{}, err
 	}
 
-	clusterID, err := getClusterID(ctx, r.Client)
-	if err != nil {
-		// OCP 3.11 has no cluster id, set it as empty string
-		clusterID = ""
+	if source := getMetricsSource(addonConfig); source != nil && obsAddon.Spec.EnableMetrics && !r.Config.DevMode {
+		// If the metrics source is not reachable, report it and check again later
+		err = r.sourceProbe.check(ctx, r.Client, r.Config.Namespace, source, time.Now())
+		if err != nil {
+			log.Error(err, "Metrics source is not reachable", "url", source.ServerURL)
+			util.ReportStatusWithMessage(ctx, r.Client, obsAddon, "MetricsSourceUnreachable", err.Error())
+			return ctrl.Result{RequeueAfter: metricsSourceRetryPeriod}, nil
+		}
 	}
 
+	clusterID := ""
 	clusterType := ""
-	isSNO, err := isSNO(ctx, r.Client)
-	if err == nil && isSNO {
-		clusterType = "SNO"
-	}
+	if platform == platformOpenShift {
+		clusterID, err = getClusterID(ctx, r.Client)
+		if err != nil {
+			// OCP 3.11 has no cluster id, set it as empty string
+			clusterID = ""
+		}
 
-	err = createMonitoringClusterRoleBinding(ctx, r.Client)
-	if err != nil {
-		return ctrl.Result{}, err
+		isSNO, err := isSNO(ctx, r.Client)
+		if err == nil && isSNO {
+			clusterType = "SNO"
+		}
+
+		err = createMonitoringClusterRoleBinding(ctx, r.Client, r.Config.Namespace, r.Config.ServiceAccount)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		err = createCAConfigmap(ctx, r.Client, r.Config.Namespace)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	} else {
+		clusterID, err = getKubeClusterID(ctx, r.Client)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
-	err = createCAConfigmap(ctx, r.Client)
+
+	hubInfo, err := getHubInfo(ctx, r.Client, r.Config.Namespace, hubErr != nil)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	hubSecret := &corev1.Secret{}
-	err = r.Client.Get(ctx, types.NamespacedName{Name: hubConfigName, Namespace: namespace}, hubSecret)
+	// the metrics and the alerts are forwarded to the additional hubs as well, the invalid ones are reported
+	additionalHubs, invalidHubs, err := getAdditionalHubs(ctx, r.Client, r.Config.Namespace)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	hubInfo := &HubInfo{}
-	err = yaml.Unmarshal(hubSecret.Data[hubInfoKey], &hubInfo)
+
+	// the external labels are added to both the alerts and the metrics
+	externalLabels, err := getExternalLabels(ctx, r.Client, addonConfig)
 	if err != nil {
-		log.Error(err, "Failed to unmarshal hub info")
 		return ctrl.Result{}, err
 	}
-	hubInfo.ClusterName = string(hubSecret.Data[clusterNameKey])
 
-	// create or update the cluster-monitoring-config configmap and relevant resources
-	if err := createOrUpdateClusterMonitoringConfig(ctx, hubInfo, clusterID, r.Client); err != nil {
-		return ctrl.Result{}, err
+	// create or update the cluster-monitoring-config and user-workload-monitoring-config configmaps and relevant resources
+	if platform == platformOpenShift && !isAlertForwardingEnabled(addonConfig) {
+		// the alert forwarding is switched off independently of the metrics
+		err = revertClusterMonitoringConfig(ctx, r.Client)
+		if err == nil {
+			err = revertUWMConfig(ctx, r.Client)
+		}
+		if err == nil {
+			err = deleteAlertRelabelConfig(ctx, r.Client)
+		}
+		if err != nil {
+			util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+				Type:    util.ConditionAlertForwarding,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ConfigurationFailed",
+				Message: "Failed to disable the alert forwarding: " + err.Error(),
+			})
+			util.UpdateStatus(ctx, r.Client, obsAddon)
+			return ctrl.Result{}, err
+		}
+		util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+			Type:    util.ConditionAlertForwarding,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Disabled",
+			Message: "Alert forwarding is disabled in the addon config",
+		})
+		util.RemoveStatusCondition(&obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
+	} else if platform == platformOpenShift {
+		conflicts, drift, err := createOrUpdateClusterMonitoringConfig(ctx, hubInfo, additionalHubs, clusterID,
+			externalLabels, r.Client, r.Config.Namespace)
+		if len(drift) != 0 {
+			r.Recorder.Event(obsAddon, corev1.EventTypeWarning, configDriftEventReason,
+				"Changes made by others in cluster-monitoring-config are reverted: "+strings.Join(drift, ", "))
+		}
+		if err == nil {
+			setConfigDriftCondition(obsAddon, drift, time.Now())
+		}
+		// the labels configured by the customer with other values are not added to the metrics either,
+		// so that the alerts and the metrics of the cluster keep the same labels
+		for _, k := range conflicts {
+			log.Info("External label is already configured in the cluster monitoring config, skip it", "label", k)
+			delete(externalLabels, k)
+		}
+		if err == nil {
+			// forward the alerts from the user workload monitoring stack as well
+			err = createOrUpdateUWMConfig(ctx, hubInfo, additionalHubs, r.Client, r.Config.Namespace)
+		}
+		if err == nil {
+			err = createOrUpdateAlertRelabelConfig(ctx, r.Client, addonConfig)
+		}
+		if err == errAlertFiltersNotSupported {
+			// the alerts are still forwarded, only the filters are not applied
+			util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+				Type:    util.ConditionAlertForwarding,
+				Status:  metav1.ConditionTrue,
+				Reason:  "FiltersNotSupported",
+				Message: "Alerts are forwarded to the hub Alertmanager without the filters: " + err.Error(),
+			})
+		} else if err != nil {
+			util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+				Type:    util.ConditionAlertForwarding,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ConfigurationFailed",
+				Message: "Failed to configure the alert forwarding: " + err.Error(),
+			})
+			util.UpdateStatus(ctx, r.Client, obsAddon)
+			return ctrl.Result{}, err
+		} else {
+			util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+				Type:    util.ConditionAlertForwarding,
+				Status:  metav1.ConditionTrue,
+				Reason:  "Configured",
+				Message: "Alerts are forwarded to the hub Alertmanager",
+			})
+		}
+	} else {
+		util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+			Type:    util.ConditionAlertForwarding,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NotSupported",
+			Message: "Alert forwarding is only supported on OpenShift",
+		})
+		util.RemoveStatusCondition(&obsAddon.Status.Conditions, util.ConditionMonitoringConfigDrift)
 	}
 
+	// an invalid allowlist is reported, and the last valid one is kept in effect
+	allowlist, allowlistErr := getMetricsAllowlist(ctx, r.Client, r.Config.Namespace)
+
 	if obsAddon.Spec.EnableMetrics {
 		forceRestart := false
 		if req.Name == mtlsCertName || req.Name == mtlsCaName || req.Name == caConfigmapName {
 			forceRestart = true
 		}
-		created, err := updateMetricsCollector(ctx, r.Client, obsAddon.Spec, *hubInfo, clusterID, clusterType, 1, forceRestart)
+		created, err := updateMetricsCollector(ctx, r.Client, r.Config, obsAddon.Spec, *hubInfo, additionalHubs, addonConfig,
+			externalLabels, allowlist, clusterID, clusterType, platform, 1, forceRestart)
 		if err != nil {
-			util.ReportStatus(ctx, r.Client, obsAddon, "Degraded")
+			util.ReportStatusWithMessage(ctx, r.Client, obsAddon, "Degraded", err.Error())
 			return ctrl.Result{}, err
 		}
 		if created {
-			util.ReportStatus(ctx, r.Client, obsAddon, "Deployed")
+			// the deployment is written, report the actual health of the metrics collector
+			now := time.Now()
+			state, details, err := getCollectorHealth(ctx, r.Client, r.Config.Namespace, now)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			util.SetState(obsAddon, state, details)
+			if state == collectorStateHealthy {
+				// a healthy metrics collector may still fail to push, report what it actually forwards
+				forwardingCondition := func(name string, podLabels map[string]string) oav1beta1.StatusCondition {
+					stats, err := getCollectorStats(ctx, r.Client, r.Config.Namespace, podLabels, collectorMetricsPort)
+					return getForwardingCondition(stats, err, r.statsHistory.observe(name, stats, now), now)
+				}
+				util.SetStatusCondition(&obsAddon.Status.Conditions,
+					forwardingCondition(metricsCollectorName, map[string]string{selectorKey: selectorValue}))
+				// the metrics collector of each additional hub exposes its own metrics
+				setHubConditions(obsAddon, additionalHubs, invalidHubs,
+					func(hub additionalHub) oav1beta1.StatusCondition {
+						return forwardingCondition(hub.collectorName(), hub.collectorPodLabels())
+					})
+			}
+			// the invalid allowlist is reported unless the metrics collector is already degraded
+			degraded := util.FindStatusCondition(obsAddon.Status.Conditions, util.ConditionDegraded)
+			if allowlistErr != nil && (degraded == nil || degraded.Status != metav1.ConditionTrue) {
+				util.SetState(obsAddon, "InvalidAllowlist", allowlistErr.Error())
+			}
+			util.UpdateStatus(ctx, r.Client, obsAddon)
 		}
+		// check the health of the metrics collector again later
+		return ctrl.Result{RequeueAfter: collectorHealthCheckPeriod}, nil
 	} else {
-		deleted, err := updateMetricsCollector(ctx, r.Client, obsAddon.Spec, *hubInfo, clusterID, clusterType, 0, false)
+		deleted, err := updateMetricsCollector(ctx, r.Client, r.Config, obsAddon.Spec, *hubInfo, additionalHubs, addonConfig,
+			externalLabels, allowlist, clusterID, clusterType, platform, 0, false)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 		if deleted {
+			// no metrics are forwarded to the additional hubs either
+			setHubConditions(obsAddon, nil, nil, nil)
 			util.ReportStatus(ctx, r.Client, obsAddon, "Disabled")
 		}
 	}
 
-	//TODO: UPDATE
-	return ctrl.Result{}, nil
+	// check the spec of the hub observabilityaddon again later
+	return ctrl.Result{RequeueAfter: hubSyncPeriod}, nil
 }
 
 func (r *ObservabilityAddonReconciler) initFinalization(
 	ctx context.Context, delete bool, hubObsAddon *oav1beta1.ObservabilityAddon) (bool, error) {
 	if delete && contains(hubObsAddon.GetFinalizers(), obsAddonFinalizer) {
 		log.Info("To clean observability components/configurations in the cluster")
-		err := deleteMetricsCollector(ctx, r.Client)
+		err := deleteMetricsCollector(ctx, r.Client, r.Config.Namespace)
+		if err != nil {
+			return false, err
+		}
+		err = deleteCollectorConfig(ctx, r.Client, r.Config.Namespace)
+		if err != nil {
+			return false, err
+		}
+		err = deleteLastValidAllowlist(ctx, r.Client, r.Config.Namespace)
 		if err != nil {
 			return false, err
 		}
@@ -190,7 +390,7 @@ func (r *ObservabilityAddonReconciler) initFinalization(
 		if err != nil {
 			return false, err
 		}
-		err = deleteCAConfigmap(ctx, r.Client)
+		err = deleteCAConfigmap(ctx, r.Client, r.Config.Namespace)
 		if err != nil {
 			return false, err
 		}
@@ -199,6 +399,18 @@ func (r *ObservabilityAddonReconciler) initFinalization(
 		if err != nil {
 			return false, err
 		}
+		err = revertUWMConfig(ctx, r.Client)
+		if err != nil {
+			return false, err
+		}
+		err = deleteAlertRelabelConfig(ctx, r.Client)
+		if err != nil {
+			return false, err
+		}
+		err = deleteHubCache(ctx, r.Client, r.Config.Namespace)
+		if err != nil {
+			return false, err
+		}
 		hubObsAddon.SetFinalizers(remove(hubObsAddon.GetFinalizers(), obsAddonFinalizer))
 		err = r.HubClient.Update(ctx, hubObsAddon)
 		if err != nil {
@@ -220,10 +432,70 @@ func (r *ObservabilityAddonReconciler) initFinalization(
 	return false, nil
 }
 
+// getHubInfo reads the hub info from the hub info secret, the last known one is used if the secret
+// is not available while the hub is unreachable
+func getHubInfo(ctx context.Context, c client.Client, ns string, hubUnreachable bool) (*HubInfo, error) {
+	hubSecret := &corev1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: hubConfigName, Namespace: ns}, hubSecret)
+	if err != nil {
+		if hubUnreachable {
+			hubInfo, cacheErr := getCachedHubInfo(ctx, c, ns)
+			if cacheErr == nil && hubInfo != nil {
+				log.Info("Hub info secret is not available, using the last known hub info")
+				return hubInfo, nil
+			}
+		}
+		return nil, err
+	}
+	hubInfo := &HubInfo{}
+	err = yaml.Unmarshal(hubSecret.Data[hubInfoKey], &hubInfo)
+	if err != nil {
+		log.Error(err, "Failed to unmarshal hub info")
+		return nil, err
+	}
+	hubInfo.ClusterName = string(hubSecret.Data[clusterNameKey])
+	err = cacheHubInfo(ctx, c, ns, hubInfo)
+	if err != nil {
+		return nil, err
+	}
+	return hubInfo, nil
+}
+
+// setHubConnectedCondition sets the HubConnected condition for the error of the last request to the hub
+func setHubConnectedCondition(obsAddon *oav1beta1.ObservabilityAddon, hubErr error) {
+	if hubErr != nil {
+		util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+			Type:    util.ConditionHubConnected,
+			Status:  metav1.ConditionFalse,
+			Reason:  "HubUnreachable",
+			Message: "Failed to get observabilityaddon in hub cluster: " + hubErr.Error(),
+		})
+		return
+	}
+	util.SetStatusCondition(&obsAddon.Status.Conditions, oav1beta1.StatusCondition{
+		Type:    util.ConditionHubConnected,
+		Status:  metav1.ConditionTrue,
+		Reason:  "HubConnected",
+		Message: "Connected to the hub cluster",
+	})
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ObservabilityAddonReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	if os.Getenv("NAMESPACE") != "" {
-		namespace = os.Getenv("NAMESPACE")
+	namespace := r.Config.Namespace
+	// the cluster-monitoring-config is out of the namespace of the manager cache, it's watched with its own cache
+	// so that the changes made by others are reapplied
+	cmoCache, err := filteredcache.NewFilteredCacheBuilder(map[schema.GroupVersionKind]filteredcache.Selector{
+		corev1.SchemeGroupVersion.WithKind("ConfigMap"): {
+			FieldSelector: fmt.Sprintf("metadata.namespace==%s,metadata.name==%s", promNamespace,
+				clusterMonitoringConfigName),
+		},
+	})(mgr.GetConfig(), cache.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		return err
+	}
+	if err := mgr.Add(cmoCache); err != nil {
+		return err
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&oav1beta1.ObservabilityAddon{}, builder.WithPredicates(getPred(obAddonName, namespace, true, true, true))).
@@ -231,9 +503,16 @@ func (r *ObservabilityAddonReconciler) SetupWithManager(mgr ctrl.Manager) error
 		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(mtlsCertName, namespace, true, true, false))).
 		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(mtlsCaName, namespace, true, true, false))).
 		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(hubAmAccessorSecretName, namespace, true, true, false))).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getLabelPred(additionalHubLabelKey, additionalHubLabelValue, namespace))).
 		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(metricsConfigMapName, namespace, true, true, false))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getLabelPred(allowlistLabelKey, allowlistLabelValue, namespace))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(addonConfigName, namespace, true, true, true))).
 		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(caConfigmapName, namespace, false, true, true))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(collectorConfigName, namespace, false, true, true))).
+		Watches(source.NewKindWithCache(&corev1.ConfigMap{}, cmoCache), &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(clusterMonitoringConfigName, promNamespace, true, true, true))).
 		Watches(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(metricsCollectorName, namespace, true, true, true))).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getHubCollectorPred(namespace))).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getCollectorPodPred(namespace))).
 		Watches(&source.Kind{Type: &rbacv1.ClusterRoleBinding{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(getPred(clusterRoleBindingName, "", false, true, true))).
 		Complete(r)
 }
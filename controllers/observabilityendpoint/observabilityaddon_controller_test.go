@@ -4,22 +4,30 @@ package observabilityendpoint
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"testing"
 
 	ocinfrav1 "github.com/openshift/api/config/v1"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	appv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	addonv1alpha1 "github.com/open-cluster-management/api/addon/v1alpha1"
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/util"
 	oashared "github.com/open-cluster-management/multicluster-observability-operator/api/shared"
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
 )
@@ -30,8 +38,18 @@ const (
 	testHubNamspace = "test-hub-ns"
 	hubInfoName     = "hub-info-secret"
 	testBearerToken = "test-bearer-token"
+	// testServiceAccount is the service account of the metrics collector
+	testServiceAccount = "endpoint-observability-operator-sa"
 )
 
+func newTestConfig() *config.OperatorConfig {
+	return &config.OperatorConfig{
+		Namespace:      testNamespace,
+		HubNamespace:   testHubNamspace,
+		ServiceAccount: testServiceAccount,
+	}
+}
+
 func newObservabilityAddon(name string, ns string) *oav1beta1.ObservabilityAddon {
 	return &oav1beta1.ObservabilityAddon{
 		ObjectMeta: metav1.ObjectMeta{
@@ -96,9 +114,7 @@ func init() {
 	addonv1alpha1.AddToScheme(s)
 	oav1beta1.AddToScheme(s)
 	ocinfrav1.AddToScheme(s)
-
-	namespace = testNamespace
-	hubNamespace = testHubNamspace
+	monitoringv1.AddToScheme(s)
 }
 
 func TestObservabilityAddonController(t *testing.T) {
@@ -118,11 +134,13 @@ alertmanager-router-ca: |
 	objs := []runtime.Object{hubInfo, amAccessSrt, allowList, cv, infra}
 
 	hubClient := fake.NewFakeClient(hubObjs...)
-	c := fake.NewFakeClient(objs...)
+	c := &discoveryClient{Client: fake.NewFakeClient(objs...)}
 
 	r := &ObservabilityAddonReconciler{
 		Client:    c,
 		HubClient: hubClient,
+		Recorder:  record.NewFakeRecorder(10),
+		Config:    newTestConfig(),
 	}
 
 	// test error in reconcile if missing obervabilityaddon
@@ -160,6 +178,7 @@ alertmanager-router-ca: |
 	}
 
 	// test reconcile successfully with all resources installed and finalizer set
+	c.openShift = true
 	promSvc := newPromSvc()
 	err = c.Create(ctx, promSvc)
 	if err != nil {
@@ -183,19 +202,19 @@ alertmanager-router-ca: |
 	}
 	cm := &corev1.ConfigMap{}
 	err = c.Get(ctx, types.NamespacedName{Name: caConfigmapName,
-		Namespace: namespace}, cm)
+		Namespace: testNamespace}, cm)
 	if err != nil {
 		t.Fatalf("Required configmap not created: (%v)", err)
 	}
 	deploy := &appv1.Deployment{}
 	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
-		Namespace: namespace}, deploy)
+		Namespace: testNamespace}, deploy)
 	if err != nil {
 		t.Fatalf("Metrics collector deployment not created: (%v)", err)
 	}
 	foundOba := &oav1beta1.ObservabilityAddon{}
 	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName,
-		Namespace: hubNamespace}, foundOba)
+		Namespace: testHubNamspace}, foundOba)
 	if err != nil {
 		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
 	}
@@ -216,7 +235,7 @@ alertmanager-router-ca: |
 		t.Fatalf("reconcile: (%v)", err)
 	}
 	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
-		Namespace: namespace}, deploy)
+		Namespace: testNamespace}, deploy)
 	if err != nil {
 		t.Fatalf("Metrics collector deployment not created: (%v)", err)
 	}
@@ -239,7 +258,7 @@ alertmanager-router-ca: |
 		t.Fatalf("reconcile for update: (%v)", err)
 	}
 	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
-		Namespace: namespace}, deploy)
+		Namespace: testNamespace}, deploy)
 	if err != nil {
 		t.Fatalf("Metrics collector deployment not found: (%v)", err)
 	}
@@ -247,16 +266,76 @@ alertmanager-router-ca: |
 		t.Fatal("Deployment not updated")
 	}
 
-	// test reconcile  metrics collector's replicas set to 0 if observability disabled
-	err = c.Delete(ctx, oba)
+	// test reconcile the alerts filtered by the platform Prometheus
+	err = c.Create(ctx, newAddonConfigCM(`
+alertForwarding:
+  dropAlertNames:
+  - Watchdog
+`))
 	if err != nil {
-		t.Fatalf("failed to delete obsaddon to disable: (%v)", err)
+		t.Fatalf("Failed to create the addon config: (%v)", err)
 	}
-	oba = newObservabilityAddon(name, testNamespace)
-	oba.Spec.EnableMetrics = false
-	err = c.Create(ctx, oba)
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile for alert filters: (%v)", err)
+	}
+	arc := &unstructured.Unstructured{}
+	arc.SetGroupVersionKind(alertRelabelConfigGVK)
+	err = c.Get(ctx, types.NamespacedName{Name: alertRelabelConfigName, Namespace: promNamespace}, arc)
+	if err != nil {
+		t.Fatalf("Alert relabel config not created: (%v)", err)
+	}
+
+	// test reconcile the alert forwarding switched off independently of the metrics
+	err = c.Update(ctx, newAddonConfigCM(`
+alertForwarding:
+  enabled: false
+  dropAlertNames:
+  - Watchdog
+`))
+	if err != nil {
+		t.Fatalf("Failed to update the addon config: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile for alert forwarding disabled: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: hubAmAccessorSecretName, Namespace: promNamespace}, &corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Alert forwarding not disabled: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: alertRelabelConfigName, Namespace: promNamespace}, arc)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Alert relabel config not deleted with the alert forwarding disabled: (%v)", err)
+	}
+	localOba := &oav1beta1.ObservabilityAddon{}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, localOba)
 	if err != nil {
-		t.Fatalf("failed to create obsaddon to disable: (%v)", err)
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	condition := util.FindStatusCondition(localOba.Status.Conditions, util.ConditionAlertForwarding)
+	if condition == nil || condition.Reason != "Disabled" {
+		t.Fatalf("Wrong alert forwarding condition: %+v", condition)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, deploy)
+	if err != nil || *deploy.Spec.Replicas != 1 {
+		t.Fatalf("Metrics collector deployment not kept: (%v)", err)
+	}
+	err = c.Delete(ctx, newAddonConfigCM(""))
+	if err != nil {
+		t.Fatalf("Failed to delete the addon config: (%v)", err)
+	}
+
+	// test reconcile  metrics collector's replicas set to 0 if observability disabled in hub
+	hubOba := &oav1beta1.ObservabilityAddon{}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testHubNamspace}, hubOba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	hubOba.Spec.EnableMetrics = false
+	err = hubClient.Update(ctx, hubOba)
+	if err != nil {
+		t.Fatalf("failed to update hub obsaddon to disable: (%v)", err)
 	}
 	req = ctrl.Request{
 		NamespacedName: types.NamespacedName{
@@ -269,18 +348,39 @@ alertmanager-router-ca: |
 		t.Fatalf("reconcile for disable: (%v)", err)
 	}
 	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
-		Namespace: namespace}, deploy)
+		Namespace: testNamespace}, deploy)
 	if err != nil {
 		t.Fatalf("Metrics collector deployment not created: (%v)", err)
 	}
 	if *deploy.Spec.Replicas != 0 {
 		t.Fatalf("Replicas for metrics collector deployment is not set as 0, value is (%d)", *deploy.Spec.Replicas)
 	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if oba.Spec.EnableMetrics {
+		t.Fatal("Hub spec not mirrored into the local observabilityAddon")
+	}
 
-	// test reconcile all resources and finalizer are removed
+	// test reconcile the local observabilityaddon recreated if it's deleted
 	err = c.Delete(ctx, oba)
 	if err != nil {
-		t.Fatalf("failed to delete obsaddon to delete: (%v)", err)
+		t.Fatalf("failed to delete obsaddon: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile for recreate: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
+	if err != nil {
+		t.Fatalf("Local observabilityAddon not recreated from the hub one: (%v)", err)
+	}
+
+	// test reconcile all resources and finalizer are removed
+	err = hubClient.Delete(ctx, hubOba)
+	if err != nil {
+		t.Fatalf("failed to delete hub obsaddon to delete: (%v)", err)
 	}
 	req = ctrl.Request{
 		NamespacedName: types.NamespacedName{
@@ -298,22 +398,557 @@ alertmanager-router-ca: |
 		t.Fatalf("Required clusterrolebinding not deleted")
 	}
 	err = c.Get(ctx, types.NamespacedName{Name: caConfigmapName,
-		Namespace: namespace}, cm)
+		Namespace: testNamespace}, cm)
 	if !errors.IsNotFound(err) {
 		t.Fatalf("Required configmap not deleted")
 	}
 	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
-		Namespace: namespace}, deploy)
+		Namespace: testNamespace}, deploy)
 	if !errors.IsNotFound(err) {
 		t.Fatalf("Metrics collector deployment not deleted")
 	}
+	err = c.Get(ctx, types.NamespacedName{Name: collectorConfigName,
+		Namespace: testNamespace}, cm)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Metrics collector config configmap not deleted")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: hubCacheName,
+		Namespace: testNamespace}, cm)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Hub cache configmap not deleted")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: lastValidAllowlistName,
+		Namespace: testNamespace}, cm)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Last valid allowlist configmap not deleted")
+	}
+	// the hub observabilityaddon is gone once the finalizer is removed
 	foundOba1 := &oav1beta1.ObservabilityAddon{}
 	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName,
-		Namespace: hubNamespace}, foundOba1)
+		Namespace: testHubNamspace}, foundOba1)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Finalizer not removed from observabilityAddon: (%v)", foundOba1.Finalizers)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
+	if !errors.IsNotFound(err) {
+		t.Fatal("Local observabilityAddon not deleted")
+	}
+}
+
+func TestObservabilityAddonControllerMetricsSource(t *testing.T) {
+	hubInfoData := []byte(`
+endpoint: "http://test-endpoint"
+`)
+	addonConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      addonConfigName,
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			addonConfigKey: `
+metricsSource:
+  serverUrl: https://thanos-querier.monitoring.svc:9091
+  bearerTokenSecret: thanos-querier-token
+`,
+		},
+	}
+	hubObjs := []runtime.Object{newObservabilityAddon(name, testHubNamspace)}
+	objs := []runtime.Object{newHubInfoSecret(hubInfoData), newAMAccessorSecret(), getAllowlistCM(),
+		newObservabilityAddon(name, testNamespace), addonConfig, newPromSvc(), infra}
+	hubClient := fake.NewFakeClient(hubObjs...)
+	c := &discoveryClient{Client: fake.NewFakeClient(objs...), openShift: true}
+	r := &ObservabilityAddonReconciler{
+		Client:    c,
+		HubClient: hubClient,
+		Recorder:  record.NewFakeRecorder(10),
+		Config:    newTestConfig(),
+	}
+
+	savedCheck := checkMetricsSource
+	defer func() { checkMetricsSource = savedCheck }()
+	checkMetricsSource = func(ctx context.Context, c client.Client, ns string, source *MetricsSource) error {
+		return fmt.Errorf("connection refused")
+	}
+
+	// test reconcile with unreachable metrics source
+	ctx := context.TODO()
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "install",
+			Namespace: testNamespace,
+		},
+	}
+	result, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	if result.RequeueAfter != metricsSourceRetryPeriod {
+		t.Fatalf("Reconcile not requeued for unreachable metrics source: (%v)", result)
+	}
+	oba := &oav1beta1.ObservabilityAddon{}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	degraded := util.FindStatusCondition(oba.Status.Conditions, util.ConditionDegraded)
+	if degraded == nil || degraded.Status != metav1.ConditionTrue || degraded.Reason != "MetricsSourceUnreachable" ||
+		!strings.Contains(degraded.Message, "connection refused") {
+		t.Fatalf("Wrong status reported for unreachable metrics source: (%v)", oba.Status.Conditions)
+	}
+	if c := util.FindStatusCondition(oba.Status.Conditions, util.ConditionHubConnected); c == nil || c.Status != metav1.ConditionTrue {
+		t.Fatalf("HubConnected condition not reported: (%v)", oba.Status.Conditions)
+	}
+
+	// test reconcile in dev mode doesn't check the metrics source
+	r.Config.DevMode = true
+	_, err = r.Reconcile(ctx, req)
+	r.Config.DevMode = false
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, &appv1.Deployment{})
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not created in dev mode: (%v)", err)
+	}
+
+	// test reconcile with reachable metrics source, the last probe is kept for metricsSourceRetryPeriod
+	checkMetricsSource = func(ctx context.Context, c client.Client, ns string, source *MetricsSource) error {
+		return nil
+	}
+	r.sourceProbe = metricsSourceProbe{}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	deploy := &appv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
+		Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not created: (%v)", err)
+	}
+	for _, env := range deploy.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "FROM" && env.Value != "https://thanos-querier.monitoring.svc:9091" {
+			t.Fatalf("Wrong metrics source in deployment: (%s)", env.Value)
+		}
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if c := util.FindStatusCondition(oba.Status.Conditions, util.ConditionProgressing); c == nil || c.Status != metav1.ConditionTrue ||
+		c.Reason != collectorStateProgressing {
+		t.Fatalf("Progressing condition not reported before the metrics collector is available: (%v)", oba.Status.Conditions)
+	}
+
+	// test reconcile with available metrics collector
+	getCollectorStats = func(ctx context.Context, c client.Client, ns string, podLabels map[string]string, port int) (*collectorStats, error) {
+		return &collectorStats{Samples: 100}, nil
+	}
+	deploy.Status.UpdatedReplicas = 1
+	deploy.Status.AvailableReplicas = 1
+	err = c.Status().Update(ctx, deploy)
+	if err != nil {
+		t.Fatalf("Failed to update metrics collector deployment status: (%v)", err)
+	}
+	result, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	if result.RequeueAfter != collectorHealthCheckPeriod {
+		t.Fatalf("Reconcile not requeued to check the metrics collector health: (%v)", result)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	for _, conditionType := range []string{util.ConditionAvailable, util.ConditionMetricsForwarding, util.ConditionAlertForwarding} {
+		if c := util.FindStatusCondition(oba.Status.Conditions, conditionType); c == nil || c.Status != metav1.ConditionTrue {
+			t.Fatalf("Condition %s not reported as True: (%v)", conditionType, oba.Status.Conditions)
+		}
+	}
+	if c := util.FindStatusCondition(oba.Status.Conditions, util.ConditionDegraded); c == nil || c.Status != metav1.ConditionFalse {
+		t.Fatalf("Degraded condition not cleared: (%v)", oba.Status.Conditions)
+	}
+	if v := testutil.ToFloat64(collectorSamples.WithLabelValues(metricsCollectorName)); v != 100 {
+		t.Fatalf("Federated samples not reported: (%v)", v)
+	}
+
+	// test reconcile with more samples federated, the status is not written again
+	getCollectorStats = func(ctx context.Context, c client.Client, ns string, podLabels map[string]string, port int) (*collectorStats, error) {
+		return &collectorStats{Samples: 200}, nil
+	}
+	resourceVersion := oba.ResourceVersion
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
 	if err != nil {
 		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
 	}
-	if contains(foundOba1.Finalizers, obsAddonFinalizer) {
-		t.Fatal("Finalizer not removed from observabilityAddon")
+	if oba.ResourceVersion != resourceVersion {
+		t.Fatalf("Status written for new stats without a forwarding change: (%v)", oba.Status.Conditions)
+	}
+
+	// test reconcile with failing metrics collector
+	getCollectorStats = func(ctx context.Context, c client.Client, ns string, podLabels map[string]string, port int) (*collectorStats, error) {
+		return &collectorStats{Samples: 100, Failures: 3}, nil
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if c := util.FindStatusCondition(oba.Status.Conditions, util.ConditionMetricsForwarding); c.Status != metav1.ConditionFalse {
+		t.Fatalf("Push failures not reported: (%v)", c)
+	}
+
+	// test reconcile with invalid allowlist, the forwarding is still evaluated
+	allowlist := getAllowlistCM()
+	err = c.Get(ctx, types.NamespacedName{Name: metricsConfigMapName, Namespace: testNamespace}, allowlist)
+	if err != nil {
+		t.Fatalf("Failed to get the allowlist: (%v)", err)
+	}
+	allowlist.Data[metricsConfigMapKey] = "names: [invalid-name]"
+	err = c.Update(ctx, allowlist)
+	if err != nil {
+		t.Fatalf("Failed to update the allowlist: (%v)", err)
+	}
+	getCollectorStats = func(ctx context.Context, c client.Client, ns string, podLabels map[string]string, port int) (*collectorStats, error) {
+		return &collectorStats{Samples: 100, Failures: 5}, nil
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, oba)
+	if err != nil {
+		t.Fatalf("Failed to get observabilityAddon: (%v)", err)
+	}
+	if c := util.FindStatusCondition(oba.Status.Conditions, util.ConditionDegraded); c.Status != metav1.ConditionTrue ||
+		c.Reason != "InvalidAllowlist" {
+		t.Fatalf("Invalid allowlist not reported: (%v)", c)
+	}
+	if c := util.FindStatusCondition(oba.Status.Conditions, util.ConditionMetricsForwarding); c.Status != metav1.ConditionFalse {
+		t.Fatalf("Push failures not reported with invalid allowlist: (%v)", c)
+	}
+
+	// test reconcile with invalid addon config
+	addonConfig.Data[addonConfigKey] = `
+metricsSource:
+  serverUrl: thanos-querier:9091
+`
+	err = c.Update(ctx, addonConfig)
+	if err != nil {
+		t.Fatalf("Failed to update addon config: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err == nil {
+		t.Fatal("reconcile: miss the error for invalid addon config")
+	}
+}
+
+func TestObservabilityAddonControllerKubernetes(t *testing.T) {
+	hubInfoData := []byte(`
+endpoint: "http://test-endpoint"
+`)
+	kubeSystem := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: kubeSystemNamespace,
+			UID:  "test-kube-system-uid",
+		},
+	}
+	hubObjs := []runtime.Object{newObservabilityAddon(name, testHubNamspace)}
+	objs := []runtime.Object{newHubInfoSecret(hubInfoData), newAMAccessorSecret(), getAllowlistCM(),
+		newObservabilityAddon(name, testNamespace), kubeSystem}
+	hubClient := fake.NewFakeClient(hubObjs...)
+	c := &discoveryClient{Client: fake.NewFakeClient(objs...)}
+	r := &ObservabilityAddonReconciler{
+		Client:    c,
+		HubClient: hubClient,
+		Recorder:  record.NewFakeRecorder(10),
+		Config:    newTestConfig(),
+	}
+
+	savedCheck := checkMetricsSource
+	defer func() { checkMetricsSource = savedCheck }()
+	checkMetricsSource = func(ctx context.Context, c client.Client, ns string, source *MetricsSource) error {
+		return nil
+	}
+
+	// test reconcile w/o prometheus
+	ctx := context.TODO()
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "install",
+			Namespace: testNamespace,
+		},
+	}
+	result, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	if result.RequeueAfter != metricsSourceRetryPeriod {
+		t.Fatalf("Reconcile not requeued without prometheus: (%v)", result)
+	}
+
+	// test reconcile with the prometheus managed by prometheus operator
+	err = c.Create(ctx, newPrometheus("k8s", "monitoring"))
+	if err != nil {
+		t.Fatalf("Failed to create prometheus: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	deploy := &appv1.Deployment{}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName,
+		Namespace: testNamespace}, deploy)
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not created: (%v)", err)
+	}
+	for _, env := range deploy.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "FROM" && env.Value != "http://prometheus-operated.monitoring.svc:9090" {
+			t.Fatalf("Wrong metrics source in deployment: (%s)", env.Value)
+		}
+	}
+	foundClusterID := false
+	for _, cmd := range deploy.Spec.Template.Spec.Containers[0].Command {
+		if cmd == "--label=\"clusterID=test-kube-system-uid\"" {
+			foundClusterID = true
+		}
+	}
+	if !foundClusterID {
+		t.Fatalf("Cluster id not set from kube-system namespace: (%v)", deploy.Spec.Template.Spec.Containers[0].Command)
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: caConfigmapName,
+		Namespace: testNamespace}, cm)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Service CA configmap created out of OpenShift")
+	}
+	rb := &rbacv1.ClusterRoleBinding{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterRoleBindingName,
+		Namespace: ""}, rb)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Monitoring clusterrolebinding created out of OpenShift")
+	}
+}
+
+// unreachableClient fails the requests with err when it's set, like a hub API server being down
+type unreachableClient struct {
+	client.Client
+	err error
+}
+
+func (c *unreachableClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *unreachableClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestObservabilityAddonControllerHubOutage(t *testing.T) {
+	hubInfoData := []byte(`
+endpoint: "http://test-endpoint"
+`)
+	kubeSystem := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: kubeSystemNamespace,
+			UID:  "test-kube-system-uid",
+		},
+	}
+	hubOba := newObservabilityAddon(name, testHubNamspace)
+	hubOba.Spec.Interval = 60
+	hubObjs := []runtime.Object{hubOba}
+	objs := []runtime.Object{newHubInfoSecret(hubInfoData), newAMAccessorSecret(), getAllowlistCM(),
+		kubeSystem, newPrometheus("k8s", "monitoring")}
+	hubClient := &unreachableClient{Client: fake.NewFakeClient(hubObjs...)}
+	c := &discoveryClient{Client: fake.NewFakeClient(objs...)}
+	r := &ObservabilityAddonReconciler{
+		Client:    c,
+		HubClient: hubClient,
+		Recorder:  record.NewFakeRecorder(10),
+		Config:    newTestConfig(),
+	}
+
+	savedCheck := checkMetricsSource
+	defer func() { checkMetricsSource = savedCheck }()
+	checkMetricsSource = func(ctx context.Context, c client.Client, ns string, source *MetricsSource) error {
+		return nil
+	}
+
+	ctx := context.TODO()
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "install",
+			Namespace: testNamespace,
+		},
+	}
+	hubErr := fmt.Errorf("dial tcp 10.0.0.1:6443: connect: connection refused")
+
+	// test reconcile w/o the cache while the hub is unreachable
+	hubClient.err = hubErr
+	_, err := r.Reconcile(ctx, req)
+	if err == nil {
+		t.Fatal("reconcile: miss the error for unreachable hub w/o cache")
+	}
+
+	// test reconcile with the hub reachable caches the hub observabilityaddon and hub info
+	hubClient.err = nil
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	cachedAddon, err := getCachedHubAddon(ctx, c, testNamespace)
+	if err != nil || cachedAddon == nil || cachedAddon.Spec.Interval != 60 {
+		t.Fatalf("Hub observabilityaddon not cached: %v (%v)", cachedAddon, err)
+	}
+	cachedInfo, err := getCachedHubInfo(ctx, c, testNamespace)
+	if err != nil || cachedInfo == nil || cachedInfo.Endpoint != "http://test-endpoint" {
+		t.Fatalf("Hub info not cached: %v (%v)", cachedInfo, err)
+	}
+
+	// test reconcile with the hub unreachable continues from the cache
+	hubClient.err = hubErr
+	err = c.Delete(ctx, newObservabilityAddon(name, testNamespace))
+	if err != nil {
+		t.Fatalf("Failed to delete the local observabilityaddon: (%v)", err)
+	}
+	err = c.Delete(ctx, newHubInfoSecret(hubInfoData))
+	if err != nil {
+		t.Fatalf("Failed to delete the hub info secret: (%v)", err)
+	}
+	err = c.Delete(ctx, &appv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: metricsCollectorName, Namespace: testNamespace}})
+	if err != nil {
+		t.Fatalf("Failed to delete the metrics collector deployment: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, &appv1.Deployment{})
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not recreated from the cache: (%v)", err)
+	}
+	localOba := &oav1beta1.ObservabilityAddon{}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, localOba)
+	if err != nil {
+		t.Fatalf("Local observabilityaddon not recreated from the cache: (%v)", err)
+	}
+	if localOba.Spec.Interval != 60 {
+		t.Fatalf("Wrong spec of the local observabilityaddon: %+v", localOba.Spec)
+	}
+	condition := util.FindStatusCondition(localOba.Status.Conditions, util.ConditionHubConnected)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != "HubUnreachable" {
+		t.Fatalf("Wrong HubConnected condition while the hub is unreachable: %+v", condition)
+	}
+
+	// test the finalizer is resynchronized once the hub is reachable again
+	hubClient.err = nil
+	foundOba := &oav1beta1.ObservabilityAddon{}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testHubNamspace}, foundOba)
+	if err != nil {
+		t.Fatalf("Failed to get the hub observabilityaddon: (%v)", err)
+	}
+	foundOba.Finalizers = nil
+	err = hubClient.Update(ctx, foundOba)
+	if err != nil {
+		t.Fatalf("Failed to remove the finalizer of the hub observabilityaddon: (%v)", err)
+	}
+	err = c.Create(ctx, newHubInfoSecret(hubInfoData))
+	if err != nil {
+		t.Fatalf("Failed to create the hub info secret: (%v)", err)
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testHubNamspace}, foundOba)
+	if err != nil {
+		t.Fatalf("Failed to get the hub observabilityaddon: (%v)", err)
+	}
+	if !contains(foundOba.Finalizers, obsAddonFinalizer) {
+		t.Fatal("Finalizer not resynchronized in the hub observabilityaddon")
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, localOba)
+	if err != nil {
+		t.Fatalf("Failed to get the local observabilityaddon: (%v)", err)
+	}
+	condition = util.FindStatusCondition(localOba.Status.Conditions, util.ConditionHubConnected)
+	if condition == nil || condition.Status != metav1.ConditionTrue {
+		t.Fatalf("Wrong HubConnected condition once the hub is reachable: %+v", condition)
+	}
+}
+
+func TestObservabilityAddonControllerHubOutageWithoutLocalAddon(t *testing.T) {
+	hubInfoData := []byte(`
+endpoint: "http://test-endpoint"
+`)
+	kubeSystem := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: kubeSystemNamespace,
+			UID:  "test-kube-system-uid",
+		},
+	}
+	objs := []runtime.Object{newHubInfoSecret(hubInfoData), newAMAccessorSecret(), getAllowlistCM(),
+		kubeSystem, newPrometheus("k8s", "monitoring")}
+	hubClient := &unreachableClient{Client: fake.NewFakeClient(),
+		err: fmt.Errorf("dial tcp 10.0.0.1:6443: connect: connection refused")}
+	c := &discoveryClient{Client: fake.NewFakeClient(objs...)}
+	r := &ObservabilityAddonReconciler{
+		Client:    c,
+		HubClient: hubClient,
+		Recorder:  record.NewFakeRecorder(10),
+		Config:    newTestConfig(),
+	}
+
+	savedCheck := checkMetricsSource
+	defer func() { checkMetricsSource = savedCheck }()
+	checkMetricsSource = func(ctx context.Context, c client.Client, ns string, source *MetricsSource) error {
+		return nil
+	}
+
+	// the operator restarts while the hub is unreachable, only the cache is left from the last connection
+	ctx := context.TODO()
+	hubOba := newObservabilityAddon(name, testHubNamspace)
+	hubOba.Spec.Interval = 60
+	hubOba.Spec.EnableMetrics = true
+	err := cacheHubAddon(ctx, c, testNamespace, hubOba)
+	if err != nil {
+		t.Fatalf("Failed to cache the hub observabilityaddon: (%v)", err)
+	}
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "install",
+			Namespace: testNamespace,
+		},
+	}
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("reconcile: (%v)", err)
+	}
+	localOba := &oav1beta1.ObservabilityAddon{}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, localOba)
+	if err != nil {
+		t.Fatalf("Local observabilityaddon not created from the cache: (%v)", err)
+	}
+	condition := util.FindStatusCondition(localOba.Status.Conditions, util.ConditionHubConnected)
+	if condition == nil || condition.Status != metav1.ConditionFalse {
+		t.Fatalf("Wrong HubConnected condition while the hub is unreachable: %+v", condition)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: metricsCollectorName, Namespace: testNamespace}, &appv1.Deployment{})
+	if err != nil {
+		t.Fatalf("Metrics collector deployment not created from the cache: (%v)", err)
 	}
 }
@@ -4,8 +4,8 @@ package observabilityendpoint
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"net/url"
 	"reflect"
 	"strings"
 
@@ -13,10 +13,11 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	"github.com/ghodss/yaml"
 	cmomanifests "github.com/openshift/cluster-monitoring-operator/pkg/manifests"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -27,23 +28,35 @@ const (
 	clusterMonitoringConfigName    = "cluster-monitoring-config"
 	clusterMonitoringConfigDataKey = "config.yaml"
 	clusterLabelKeyForAlerts       = "cluster"
+	cmoPrometheusK8sKey            = "prometheusK8s"
+	cmoExternalLabelsKey           = "externalLabels"
+	cmoAlertmanagerConfigsKey      = "additionalAlertManagerConfigs"
+	// originalConfigAnnotation has the config.yaml of the cluster-monitoring-config before the operator changed it
+	originalConfigAnnotation = "observability.open-cluster-management.io/original-config"
+	// createdConfigAnnotation is set when the cluster-monitoring-config is created by the operator
+	createdConfigAnnotation = "observability.open-cluster-management.io/created"
+	// appliedConfigAnnotation has the hash of the labels and the alertmanager configs last applied by the operator
+	appliedConfigAnnotation = "observability.open-cluster-management.io/applied-config-hash"
 )
 
+// clusterMonitoringConfigComponents are the cluster monitoring components which send alerts to the Hub's Alertmanager
+var clusterMonitoringConfigComponents = []string{cmoPrometheusK8sKey}
+
 // createHubAmRouterCASecret creates the secret that contains CA of the Hub's Alertmanager Route
-func createHubAmRouterCASecret(ctx context.Context, hubInfo *HubInfo, client client.Client) error {
+func createHubAmRouterCASecret(ctx context.Context, hubInfo *HubInfo, client client.Client, ns string) error {
 	hubAmRouterCA := hubInfo.AlertmanagerRouterCA
 	dataMap := map[string][]byte{hubAmRouterCASecretKey: []byte(hubAmRouterCA)}
 	hubAmRouterCASecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      hubAmRouterCASecretName,
-			Namespace: promNamespace,
+			Namespace: ns,
 		},
 		Data: dataMap,
 	}
 
 	found := &corev1.Secret{}
 	err := client.Get(ctx, types.NamespacedName{Name: hubAmRouterCASecretName,
-		Namespace: promNamespace}, found)
+		Namespace: ns}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = client.Create(ctx, hubAmRouterCASecret)
@@ -75,10 +88,10 @@ func createHubAmRouterCASecret(ctx context.Context, hubInfo *HubInfo, client cli
 }
 
 // deleteHubAmRouterCASecret deletes the secret that contains CA of the Hub's Alertmanager Route
-func deleteHubAmRouterCASecret(ctx context.Context, client client.Client) error {
+func deleteHubAmRouterCASecret(ctx context.Context, client client.Client, ns string) error {
 	found := &corev1.Secret{}
 	err := client.Get(ctx, types.NamespacedName{Name: hubAmRouterCASecretName,
-		Namespace: promNamespace}, found)
+		Namespace: ns}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("the hub-alertmanager-router-ca secret is already deleted")
@@ -96,9 +109,10 @@ func deleteHubAmRouterCASecret(ctx context.Context, client client.Client) error
 	return nil
 }
 
-// createHubAmAccessorTokenSecret creates the secret that contains access token of the Hub's Alertmanager
-func createHubAmAccessorTokenSecret(ctx context.Context, client client.Client) error {
-	amAccessorToken, err := getAmAccessorToken(ctx, client)
+// createHubAmAccessorTokenSecret creates the secret that contains access token of the Hub's Alertmanager in ns,
+// the token is read from the secret in the addon namespace
+func createHubAmAccessorTokenSecret(ctx context.Context, client client.Client, addonNs string, ns string) error {
+	amAccessorToken, err := getAmAccessorToken(ctx, client, addonNs)
 	if err != nil {
 		return fmt.Errorf("fail to get the alertmanager accessor token %v", err)
 	}
@@ -107,14 +121,14 @@ func createHubAmAccessorTokenSecret(ctx context.Context, client client.Client) e
 	hubAmAccessorTokenSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      hubAmAccessorSecretName,
-			Namespace: promNamespace,
+			Namespace: ns,
 		},
 		Data: dataMap,
 	}
 
 	found := &corev1.Secret{}
 	err = client.Get(ctx, types.NamespacedName{Name: hubAmAccessorSecretName,
-		Namespace: promNamespace}, found)
+		Namespace: ns}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = client.Create(ctx, hubAmAccessorTokenSecret)
@@ -146,10 +160,10 @@ func createHubAmAccessorTokenSecret(ctx context.Context, client client.Client) e
 }
 
 // deleteHubAmAccessorTokenSecret deletes the secret that contains access token of the Hub's Alertmanager
-func deleteHubAmAccessorTokenSecret(ctx context.Context, client client.Client) error {
+func deleteHubAmAccessorTokenSecret(ctx context.Context, client client.Client, ns string) error {
 	found := &corev1.Secret{}
 	err := client.Get(ctx, types.NamespacedName{Name: hubAmAccessorSecretName,
-		Namespace: promNamespace}, found)
+		Namespace: ns}, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("the observability-alertmanager-accessor secret is already deleted")
@@ -167,11 +181,69 @@ func deleteHubAmAccessorTokenSecret(ctx context.Context, client client.Client) e
 	return nil
 }
 
+// createOrUpdateSecret creates the secret with the data, or updates it if the data is changed
+func createOrUpdateSecret(ctx context.Context, client client.Client, name string, ns string,
+	data map[string][]byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+		},
+		Data: data,
+	}
+	found := &corev1.Secret{}
+	err := client.Get(ctx, types.NamespacedName{Name: name, Namespace: ns}, found)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "failed to check the secret", "name", name, "namespace", ns)
+			return err
+		}
+		err = client.Create(ctx, secret)
+		if err != nil {
+			log.Error(err, "failed to create the secret", "name", name, "namespace", ns)
+			return err
+		}
+		log.Info("the secret is created", "name", name, "namespace", ns)
+		return nil
+	}
+	if reflect.DeepEqual(found.Data, data) {
+		return nil
+	}
+	found.Data = data
+	err = client.Update(ctx, found)
+	if err != nil {
+		log.Error(err, "failed to update the secret", "name", name, "namespace", ns)
+		return err
+	}
+	log.Info("the secret is updated", "name", name, "namespace", ns)
+	return nil
+}
+
+// deleteSecret deletes the secret if it exists
+func deleteSecret(ctx context.Context, client client.Client, name string, ns string) error {
+	found := &corev1.Secret{}
+	err := client.Get(ctx, types.NamespacedName{Name: name, Namespace: ns}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		log.Error(err, "failed to check the secret", "name", name, "namespace", ns)
+		return err
+	}
+	err = client.Delete(ctx, found)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "failed to delete the secret", "name", name, "namespace", ns)
+		return err
+	}
+	log.Info("the secret is deleted", "name", name, "namespace", ns)
+	return nil
+}
+
 // getAmAccessorToken retrieves the alertmanager access token from observability-alertmanager-accessor secret
-func getAmAccessorToken(ctx context.Context, client client.Client) (string, error) {
+func getAmAccessorToken(ctx context.Context, client client.Client, ns string) (string, error) {
 	amAccessorSecret := &corev1.Secret{}
 	if err := client.Get(ctx, types.NamespacedName{Name: hubAmAccessorSecretName,
-		Namespace: namespace}, amAccessorSecret); err != nil {
+		Namespace: ns}, amAccessorSecret); err != nil {
 		return "", err
 	}
 
@@ -183,31 +255,23 @@ func getAmAccessorToken(ctx context.Context, client client.Client) (string, erro
 	return string(amAccessorToken), nil
 }
 
-// createOrUpdateClusterMonitoringConfig creates or updates the configmap cluster-monitoring-config and relevant resources
-// (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift cluster monitoring stack
-func createOrUpdateClusterMonitoringConfig(ctx context.Context, hubInfo *HubInfo, clusterID string, client client.Client) error {
-	// create the hub-alertmanager-router-ca secret if it doesn't exist or update it if needed
-	if err := createHubAmRouterCASecret(ctx, hubInfo, client); err != nil {
-		log.Error(err, "failed to create or update the hub-alertmanager-router-ca secret")
-		return err
-	}
-
-	// create the observability-alertmanager-accessor secret if it doesn't exist or update it if needed
-	if err := createHubAmAccessorTokenSecret(ctx, client); err != nil {
-		log.Error(err, "failed to create or update the observability-alertmanager-accessor secret")
-		return err
-	}
+// newHubAlertmanagerConfig returns the additional alertmanager config which points to the Hub's Alertmanager
+func newHubAlertmanagerConfig(hubInfo *HubInfo) cmomanifests.AdditionalAlertmanagerConfig {
+	return newAlertmanagerConfig(hubInfo.AlertmanagerEndpoint, hubAmRouterCASecretName, hubAmAccessorSecretName)
+}
 
-	// init the prometheus k8s config
-	newExternalLabels := map[string]string{clusterLabelKeyForAlerts: clusterID}
-	newAdditionalAlertmanagerConfig := cmomanifests.AdditionalAlertmanagerConfig{
+// newAlertmanagerConfig returns the additional alertmanager config which points to the Alertmanager endpoint,
+// with the CA of its route and its access token in the secrets
+func newAlertmanagerConfig(endpoint string, caSecretName string,
+	tokenSecretName string) cmomanifests.AdditionalAlertmanagerConfig {
+	return cmomanifests.AdditionalAlertmanagerConfig{
 		Scheme:     "https",
 		PathPrefix: "/",
 		APIVersion: "v2",
 		TLSConfig: cmomanifests.TLSConfig{
 			CA: &corev1.SecretKeySelector{
 				LocalObjectReference: corev1.LocalObjectReference{
-					Name: hubAmRouterCASecretName,
+					Name: caSecretName,
 				},
 				Key: hubAmRouterCASecretKey,
 			},
@@ -215,71 +279,149 @@ func createOrUpdateClusterMonitoringConfig(ctx context.Context, hubInfo *HubInfo
 		},
 		BearerToken: &corev1.SecretKeySelector{
 			LocalObjectReference: corev1.LocalObjectReference{
-				Name: hubAmAccessorSecretName,
+				Name: tokenSecretName,
 			},
 			Key: hubAmAccessorSecretKey,
 		},
-		StaticConfigs: []string{strings.TrimLeft(hubInfo.AlertmanagerEndpoint, "https://")},
+		StaticConfigs: []string{getAlertmanagerHost(endpoint)},
 	}
-	newAlertmanagerConfigs := []cmomanifests.AdditionalAlertmanagerConfig{newAdditionalAlertmanagerConfig}
-	newPmK8sConfig := &cmomanifests.PrometheusK8sConfig{
-		// add cluster label for alerts from managed cluster
-		ExternalLabels: newExternalLabels,
-		// add alertmanager configs
-		AlertmanagerConfigs: newAlertmanagerConfigs,
+}
+
+// getAlertmanagerHost returns the host of the Alertmanager endpoint, which may be set with or without the scheme
+func getAlertmanagerHost(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return endpoint
 	}
+	return u.Host
+}
 
-	// root for CMO configuration
-	newClusterMonitoringConfiguration := cmomanifests.ClusterMonitoringConfiguration{
-		PrometheusK8sConfig: newPmK8sConfig,
+// createOrUpdateClusterMonitoringConfig creates or updates the configmap cluster-monitoring-config and relevant resources
+// (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift cluster monitoring stack.
+// The alerts are sent to the Alertmanager of each additional hub as well.
+// The external labels are added besides the cluster label, the ones already configured by the customer with
+// another value are kept and returned as conflicts. The config is edited as a yaml node tree, only the external
+// labels and the hubs' alertmanager configs are changed, and it's written back only when they change.
+// The changes made by others in the applied labels and alertmanager configs are reapplied and returned as drift.
+func createOrUpdateClusterMonitoringConfig(ctx context.Context, hubInfo *HubInfo, additionalHubs []additionalHub,
+	clusterID string, externalLabels map[string]string, client client.Client, addonNs string) ([]string, []string, error) {
+	// create the hub-alertmanager-router-ca secret if it doesn't exist or update it if needed
+	if err := createHubAmRouterCASecret(ctx, hubInfo, client, promNamespace); err != nil {
+		log.Error(err, "failed to create or update the hub-alertmanager-router-ca secret")
+		return nil, nil, err
 	}
 
-	// marshal new CMO configuration to json then to yaml
-	newClusterMonitoringConfigurationJSONBytes, err := json.Marshal(newClusterMonitoringConfiguration)
-	if err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
-		return err
+	// create the observability-alertmanager-accessor secret if it doesn't exist or update it if needed
+	if err := createHubAmAccessorTokenSecret(ctx, client, addonNs, promNamespace); err != nil {
+		log.Error(err, "failed to create or update the observability-alertmanager-accessor secret")
+		return nil, nil, err
 	}
-	newClusterMonitoringConfigurationYAMLBytes, err := yaml.JSONToYAML(newClusterMonitoringConfigurationJSONBytes)
-	if err != nil {
-		log.Error(err, "failed to transform JSON to YAML", "JSON", newClusterMonitoringConfigurationJSONBytes)
-		return err
+	if err := createAdditionalHubAmSecrets(ctx, client, additionalHubs, promNamespace); err != nil {
+		return nil, nil, err
 	}
 
-	newCusterMonitoringConfigMap := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      clusterMonitoringConfigName,
-			Namespace: promNamespace,
-		},
-		Data: map[string]string{clusterMonitoringConfigDataKey: string(newClusterMonitoringConfigurationYAMLBytes)},
+	amConfigs, err := getAlertmanagerConfigValues(append(
+		[]cmomanifests.AdditionalAlertmanagerConfig{newHubAlertmanagerConfig(hubInfo)},
+		getAdditionalHubAlertmanagerConfigs(additionalHubs)...))
+	if err != nil {
+		return nil, nil, err
 	}
-
-	// try to retrieve the current configmap in the cluster
-	found := &corev1.ConfigMap{}
-	err = client.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName,
-		Namespace: promNamespace}, found)
+	desiredHash, err := getDesiredConfigHash(clusterID, externalLabels, amConfigs)
 	if err != nil {
-		if errors.IsNotFound(err) {
+		return nil, nil, err
+	}
+
+	var conflicts, drift []string
+	// the alertmanager configs of the removed additional hubs
+	var stale []interface{}
+	// the configmap is read again when it's changed by others in the meantime
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		found := &corev1.ConfigMap{}
+		err := client.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName,
+			Namespace: promNamespace}, found)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				log.Error(err, "failed to check configmap", "name", clusterMonitoringConfigName)
+				return err
+			}
 			log.Info("configmap not found, try to create it", "name", clusterMonitoringConfigName)
-			err = client.Create(ctx, newCusterMonitoringConfigMap)
+			doc, _ := parseMonitoringConfig("")
+			config := doc.Content[0]
+			_, owned, _ := setClusterMonitoringExternalLabels(config, clusterID, externalLabels, nil)
+			_, _, err = addAlertmanagerConfigs(config, clusterMonitoringConfigComponents, cmoAlertmanagerConfigsKey,
+				amConfigs)
+			if err != nil {
+				return err
+			}
+			data, err := marshalMonitoringConfig(doc, defaultMonitoringConfigIndent)
+			if err != nil {
+				log.Error(err, "failed to marshal the cluster monitoring config")
+				return err
+			}
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      clusterMonitoringConfigName,
+					Namespace: promNamespace,
+				},
+				Data: map[string]string{clusterMonitoringConfigDataKey: data},
+			}
+			// the configmap is deleted when the changes are reverted
+			cm.Annotations = map[string]string{createdConfigAnnotation: "true", appliedConfigAnnotation: desiredHash}
+			setOwnedExternalLabels(cm, owned)
+			err = client.Create(ctx, cm)
 			if err != nil {
 				log.Error(err, "failed to create configmap", "name", clusterMonitoringConfigName)
 				return err
 			}
 			log.Info("configmap created", "name", clusterMonitoringConfigName)
 			return nil
-		} else {
-			log.Error(err, "failed to check configmap", "name", clusterMonitoringConfigName)
-			return err
 		}
-	}
 
-	log.Info("configmap already exists, check if it needs update", "name", clusterMonitoringConfigName)
-	foundClusterMonitoringConfigurationYAMLString, ok := found.Data[clusterMonitoringConfigDataKey]
-	if !ok {
-		log.Info("configmap data doesn't contain key, try to update it", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-		// replace config.yaml in configmap
-		found.Data[clusterMonitoringConfigDataKey] = string(newClusterMonitoringConfigurationYAMLBytes)
+		doc, err := parseMonitoringConfig(found.Data[clusterMonitoringConfigDataKey])
+		if err != nil {
+			log.Error(err, "failed to unmarshal the cluster monitoring config", "name", clusterMonitoringConfigName)
+			return err
+		}
+		config := doc.Content[0]
+		// the original config is recorded before it's changed for the first time
+		originalRecorded := recordOriginalConfig(found, config)
+		drift = nil
+		if found.Annotations[appliedConfigAnnotation] == desiredHash {
+			// the desired config is already applied, the differences are made by others
+			drift = getClusterMonitoringConfigDrift(config, clusterID, externalLabels,
+				getOwnedExternalLabels(found.Annotations), amConfigs)
+		}
+		labelsChanged, owned, labelConflicts := setClusterMonitoringExternalLabels(config, clusterID, externalLabels,
+			getOwnedExternalLabels(found.Annotations))
+		amChanged, removed, err := addAlertmanagerConfigs(config, clusterMonitoringConfigComponents,
+			cmoAlertmanagerConfigsKey, amConfigs)
+		if err != nil {
+			return err
+		}
+		conflicts, stale = labelConflicts, removed
+		if len(conflicts) != 0 {
+			log.Info("external labels already configured with other values are kept", "labels", conflicts)
+		}
+		if !labelsChanged && !amChanged && !originalRecorded &&
+			found.Annotations[externalLabelsAnnotation] == strings.Join(owned, ",") &&
+			found.Annotations[appliedConfigAnnotation] == desiredHash {
+			log.Info("no change for configmap", "name", clusterMonitoringConfigName)
+			return nil
+		}
+		data, err := marshalMonitoringConfig(doc, getMonitoringConfigIndent(found.Data[clusterMonitoringConfigDataKey]))
+		if err != nil {
+			log.Error(err, "failed to marshal the cluster monitoring config")
+			return err
+		}
+		if found.Data == nil {
+			found.Data = map[string]string{}
+		}
+		found.Data[clusterMonitoringConfigDataKey] = data
+		setOwnedExternalLabels(found, owned)
+		if found.Annotations == nil {
+			found.Annotations = map[string]string{}
+		}
+		found.Annotations[appliedConfigAnnotation] = desiredHash
 		err = client.Update(ctx, found)
 		if err != nil {
 			log.Error(err, "failed to update configmap", "name", clusterMonitoringConfigName)
@@ -287,185 +429,201 @@ func createOrUpdateClusterMonitoringConfig(ctx context.Context, hubInfo *HubInfo
 		}
 		log.Info("configmap updated", "name", clusterMonitoringConfigName)
 		return nil
-	}
-
-	log.Info("configmap already exists and key config.yaml exists, check if the value needs update", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-	foundClusterMonitoringConfigurationJSONBytes, err := yaml.YAMLToJSON([]byte(foundClusterMonitoringConfigurationYAMLString))
+	})
 	if err != nil {
-		log.Error(err, "failed to transform YAML to JSON", "YAML", foundClusterMonitoringConfigurationYAMLString)
-		return err
+		return nil, nil, err
 	}
-	foundClusterMonitoringConfiguration := &cmomanifests.ClusterMonitoringConfiguration{}
-	if err := json.Unmarshal([]byte(foundClusterMonitoringConfigurationJSONBytes), foundClusterMonitoringConfiguration); err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
-		return err
+	if len(drift) != 0 {
+		log.Info("the changes made by others are reverted in configmap", "name", clusterMonitoringConfigName,
+			"drift", drift)
 	}
+	// the secrets of the removed additional hubs are not referenced anymore
+	return conflicts, drift, deleteRemovedAlertmanagerConfigSecrets(ctx, client, stale, promNamespace)
+}
 
-	if foundClusterMonitoringConfiguration.PrometheusK8sConfig == nil {
-		foundClusterMonitoringConfiguration.PrometheusK8sConfig = newPmK8sConfig
-	} else {
-		// check if externalLabels exists
-		if foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels == nil {
-			foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels = newExternalLabels
-		} else {
-			foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels[clusterLabelKeyForAlerts] = clusterID
+// setClusterMonitoringExternalLabels sets the cluster label and the external labels in the cluster monitoring config,
+// the external labels configured by the customer are not overwritten. It returns true if the config is changed,
+// the labels now owned by the operator and the conflicts.
+func setClusterMonitoringExternalLabels(config *yaml.Node, clusterID string,
+	externalLabels map[string]string, owned []string) (bool, []string, []string) {
+	found := getExternalLabelValues(config, cmoPrometheusK8sKey, cmoExternalLabelsKey)
+	merged, newOwned, conflicts := mergeExternalLabels(found, externalLabels, owned)
+	merged[clusterLabelKeyForAlerts] = clusterID
+	removed := []string{}
+	for k := range found {
+		if _, ok := merged[k]; !ok {
+			removed = append(removed, k)
 		}
+	}
+	changed := removeExternalLabelValues(config, cmoPrometheusK8sKey, cmoExternalLabelsKey, removed)
+	if setExternalLabelValues(config, cmoPrometheusK8sKey, cmoExternalLabelsKey, merged) {
+		changed = true
+	}
+	return changed, newOwned, conflicts
+}
 
-		// check if alertmanagerConfigs exists
-		if foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs == nil {
-			foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs = newAlertmanagerConfigs
-		} else {
-			additionalAlertmanagerConfigExists := false
-			for _, v := range foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs {
-				if v.TLSConfig != (cmomanifests.TLSConfig{}) &&
-					v.TLSConfig.CA != nil &&
-					v.TLSConfig.CA.LocalObjectReference != (corev1.LocalObjectReference{}) &&
-					v.TLSConfig.CA.LocalObjectReference.Name == hubAmRouterCASecretName {
-					additionalAlertmanagerConfigExists = true
-					break
-				}
+// getAlertmanagerConfigCAName returns the name of the secret with the CA of the alertmanager config
+func getAlertmanagerConfigCAName(c cmomanifests.AdditionalAlertmanagerConfig) string {
+	if c.TLSConfig.CA == nil {
+		return ""
+	}
+	return c.TLSConfig.CA.LocalObjectReference.Name
+}
+
+// deleteAlertmanagerConfigSecrets deletes the secrets referred by the alertmanager configs
+func deleteAlertmanagerConfigSecrets(ctx context.Context, client client.Client,
+	configs []cmomanifests.AdditionalAlertmanagerConfig, ns string) error {
+	for _, c := range configs {
+		if name := getAlertmanagerConfigCAName(c); name != "" {
+			if err := deleteSecret(ctx, client, name, ns); err != nil {
+				return err
 			}
-			if !additionalAlertmanagerConfigExists {
-				foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs = append(foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs, newAdditionalAlertmanagerConfig)
+		}
+		if c.BearerToken != nil && c.BearerToken.LocalObjectReference.Name != "" {
+			if err := deleteSecret(ctx, client, c.BearerToken.LocalObjectReference.Name, ns); err != nil {
+				return err
 			}
 		}
 	}
-
-	// prepare to write back the cluster monitoring configuration
-	updatedClusterMonitoringConfigurationJSONBytes, err := json.Marshal(foundClusterMonitoringConfiguration)
-	if err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
-		return err
-	}
-	updatedclusterMonitoringConfigurationYAMLBytes, err := yaml.JSONToYAML(updatedClusterMonitoringConfigurationJSONBytes)
-	if err != nil {
-		log.Error(err, "failed to transform JSON to YAML", "JSON", updatedClusterMonitoringConfigurationJSONBytes)
-		return err
-	}
-	found.Data[clusterMonitoringConfigDataKey] = string(updatedclusterMonitoringConfigurationYAMLBytes)
-	err = client.Update(ctx, found)
-	if err != nil {
-		log.Error(err, "failed to update configmap", "name", clusterMonitoringConfigName)
-		return err
-	}
-	log.Info("configmap updated", "name", clusterMonitoringConfigName)
 	return nil
 }
 
 // revertClusterMonitoringConfig reverts the configmap cluster-monitoring-config and relevant resources
-// (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift cluster monitoring stack
+// (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift cluster monitoring stack.
+// The original config recorded before the first change is restored as it was, the configmap created by the operator
+// is deleted. When the config is changed by others in the meantime, only the cluster label and the external labels
+// set by the operator are removed or restored to their original values, and the hubs' alertmanager configs removed.
 func revertClusterMonitoringConfig(ctx context.Context, client client.Client) error {
 	// delete the hub-alertmanager-router-ca secret
-	if err := deleteHubAmRouterCASecret(ctx, client); err != nil {
+	if err := deleteHubAmRouterCASecret(ctx, client, promNamespace); err != nil {
 		log.Error(err, "failed to delete the hub-alertmanager-router-ca secret")
 		return err
 	}
 
 	// delete the observability-alertmanager-accessor secret
-	if err := deleteHubAmAccessorTokenSecret(ctx, client); err != nil {
+	if err := deleteHubAmAccessorTokenSecret(ctx, client, promNamespace); err != nil {
 		log.Error(err, "failed to delete the observability-alertmanager-accessor secret")
 		return err
 	}
 
-	// try to retrieve the current configmap in the cluster
-	found := &corev1.ConfigMap{}
-	err := client.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName,
-		Namespace: promNamespace}, found)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			log.Info("configmap not found, no need action", "name", clusterMonitoringConfigName)
-			return nil
-		} else {
+	var removed []interface{}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		found := &corev1.ConfigMap{}
+		err := client.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName,
+			Namespace: promNamespace}, found)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				log.Info("configmap not found, no need action", "name", clusterMonitoringConfigName)
+				return nil
+			}
 			log.Error(err, "failed to check configmap", "name", clusterMonitoringConfigName)
 			return err
 		}
-	}
 
-	// revert the existing cluster-monitor-config configmap
-	log.Info("configmap exists, check if it needs revert", "name", clusterMonitoringConfigName)
-	foundClusterMonitoringConfigurationYAML, ok := found.Data[clusterMonitoringConfigDataKey]
-	if !ok {
-		log.Info("configmap data doesn't contain key, no need action", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-		return nil
-	}
-	foundClusterMonitoringConfigurationJSON, err := yaml.YAMLToJSON([]byte(foundClusterMonitoringConfigurationYAML))
-	if err != nil {
-		log.Error(err, "failed to transform YAML to JSON", "YAML", foundClusterMonitoringConfigurationYAML)
-		return err
-	}
-
-	log.Info("configmap exists and key config.yaml exists, check if the value needs revert", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-	foundClusterMonitoringConfiguration := &cmomanifests.ClusterMonitoringConfiguration{}
-	if err := json.Unmarshal([]byte(foundClusterMonitoringConfigurationJSON), foundClusterMonitoringConfiguration); err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
-		return err
-	}
-
-	if foundClusterMonitoringConfiguration.PrometheusK8sConfig == nil {
-		log.Info("configmap data doesn't key: prometheusK8s, no need action", "name", clusterMonitoringConfigName, "key", clusterMonitoringConfigDataKey)
-		return nil
-	} else {
-		// check if externalLabels exists
-		if foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels != nil {
-			if _, ok := foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels[clusterLabelKeyForAlerts]; ok {
-				delete(foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels, clusterLabelKeyForAlerts)
+		doc, err := parseMonitoringConfig(found.Data[clusterMonitoringConfigDataKey])
+		if err != nil {
+			log.Error(err, "failed to unmarshal the cluster monitoring config", "name", clusterMonitoringConfigName)
+			return err
+		}
+		config := doc.Content[0]
+		original, hasOriginal := found.Annotations[originalConfigAnnotation]
+		created := found.Annotations[createdConfigAnnotation] == "true"
+		originalDoc, _ := parseMonitoringConfig("")
+		if hasOriginal {
+			originalDoc, err = parseMonitoringConfig(original)
+			if err != nil {
+				log.Error(err, "failed to unmarshal the original cluster monitoring config")
+				return err
 			}
-			if len(foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels) == 0 {
-				foundClusterMonitoringConfiguration.PrometheusK8sConfig.ExternalLabels = nil
+		}
+		originalConfig := originalDoc.Content[0]
+
+		// the labels set by the operator are removed, or restored when they were in the original config
+		labels := append([]string{clusterLabelKeyForAlerts}, getOwnedExternalLabels(found.Annotations)...)
+		labelsChanged := removeExternalLabelValues(config, cmoPrometheusK8sKey, cmoExternalLabelsKey, labels)
+		originalLabels := getExternalLabelValues(originalConfig, cmoPrometheusK8sKey, cmoExternalLabelsKey)
+		restored := map[string]string{}
+		for _, k := range labels {
+			if v, ok := originalLabels[k]; ok {
+				restored[k] = v
 			}
 		}
+		if len(restored) != 0 && setExternalLabelValues(config, cmoPrometheusK8sKey, cmoExternalLabelsKey, restored) {
+			labelsChanged = true
+		}
+		amChanged, amRemoved := removeAlertmanagerConfigs(config, clusterMonitoringConfigComponents,
+			cmoAlertmanagerConfigsKey, nil)
+		removed = amRemoved
+		if !labelsChanged && !amChanged && !hasOriginal && !created &&
+			found.Annotations[externalLabelsAnnotation] == "" && found.Annotations[appliedConfigAnnotation] == "" {
+			log.Info("no change for configmap, no need action", "name", clusterMonitoringConfigName)
+			return nil
+		}
 
-		// check if alertmanagerConfigs exists
-		if foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs != nil {
-			copiedAlertmanagerConfigs := make([]cmomanifests.AdditionalAlertmanagerConfig, 0)
-			for _, v := range foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs {
-				if v.TLSConfig == (cmomanifests.TLSConfig{}) ||
-					v.TLSConfig.CA == nil ||
-					v.TLSConfig.CA.LocalObjectReference == (corev1.LocalObjectReference{}) ||
-					v.TLSConfig.CA.LocalObjectReference.Name != hubAmRouterCASecretName {
-					copiedAlertmanagerConfigs = append(copiedAlertmanagerConfigs, v)
-				}
+		var data string
+		switch {
+		case hasOriginal && reflect.DeepEqual(pruneEmptyValues(getNodeValue(config)),
+			pruneEmptyValues(getNodeValue(originalConfig))):
+			// nothing else is changed since the original config was recorded, restore it as it was
+			data = original
+		case len(config.Content) == 0 && (created || !hasOriginal):
+			// the configmap is created by the operator, or by a former version which didn't record the original
+			log.Info("empty ClusterMonitoringConfiguration, should delete configmap", "name", clusterMonitoringConfigName)
+			err = client.Delete(ctx, found, unchangedPrecondition(found))
+			if err != nil {
+				log.Error(err, "failed to delete configmap", "name", clusterMonitoringConfigName)
+				return err
 			}
-			if len(copiedAlertmanagerConfigs) == 0 {
-				foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs = nil
-				if reflect.DeepEqual(*foundClusterMonitoringConfiguration.PrometheusK8sConfig, cmomanifests.PrometheusK8sConfig{}) {
-					foundClusterMonitoringConfiguration.PrometheusK8sConfig = nil
-				}
-			} else {
-				foundClusterMonitoringConfiguration.PrometheusK8sConfig.AlertmanagerConfigs = copiedAlertmanagerConfigs
+			log.Info("configmap deleted", "name", clusterMonitoringConfigName)
+			return nil
+		default:
+			// the config is changed by others in the meantime, only the changes of the operator are reverted
+			data, err = marshalMonitoringConfig(doc, getMonitoringConfigIndent(found.Data[clusterMonitoringConfigDataKey]))
+			if err != nil {
+				log.Error(err, "failed to marshal the cluster monitoring config")
+				return err
 			}
 		}
-	}
-
-	// check if the foundClusterMonitoringConfiguration is empty ClusterMonitoringConfiguration
-	if reflect.DeepEqual(*foundClusterMonitoringConfiguration, cmomanifests.ClusterMonitoringConfiguration{}) {
-		log.Info("empty ClusterMonitoringConfiguration, should delete configmap", "name", clusterMonitoringConfigName)
-		err = client.Delete(ctx, found)
+		if found.Data == nil {
+			found.Data = map[string]string{}
+		}
+		found.Data[clusterMonitoringConfigDataKey] = data
+		setOwnedExternalLabels(found, nil)
+		delete(found.Annotations, originalConfigAnnotation)
+		delete(found.Annotations, createdConfigAnnotation)
+		delete(found.Annotations, appliedConfigAnnotation)
+		err = client.Update(ctx, found)
 		if err != nil {
-			log.Error(err, "failed to delete configmap", "name", clusterMonitoringConfigName)
+			log.Error(err, "failed to update configmap", "name", clusterMonitoringConfigName)
 			return err
 		}
-		log.Info("configmap delete", "name", clusterMonitoringConfigName)
+		log.Info("configmap reverted", "name", clusterMonitoringConfigName)
 		return nil
-	}
-
-	// prepare to write back the cluster monitoring configuration
-	updatedClusterMonitoringConfigurationJSONBytes, err := json.Marshal(foundClusterMonitoringConfiguration)
+	})
 	if err != nil {
-		log.Error(err, "failed to marshal the cluster monitoring config")
 		return err
 	}
-	updatedClusterMonitoringConfigurationYAMLBytes, err := yaml.JSONToYAML(updatedClusterMonitoringConfigurationJSONBytes)
-	if err != nil {
-		log.Error(err, "failed to transform JSON to YAML", "JSON", updatedClusterMonitoringConfigurationJSONBytes)
-		return err
+	// the alerts are not sent to the additional hubs anymore
+	return deleteRemovedAlertmanagerConfigSecrets(ctx, client, removed, promNamespace)
+}
+
+// recordOriginalConfig records the config.yaml of the configmap in the annotation before the operator changes it,
+// it returns true if it's recorded. The config which already has the hubs' alertmanager configs is changed by a
+// former version of the operator and not recorded.
+func recordOriginalConfig(cm *corev1.ConfigMap, config *yaml.Node) bool {
+	if _, ok := cm.Annotations[originalConfigAnnotation]; ok || cm.Annotations[createdConfigAnnotation] == "true" {
+		return false
+	}
+	for _, component := range clusterMonitoringConfigComponents {
+		for _, c := range getAlertmanagerConfigItems(config, component, cmoAlertmanagerConfigsKey) {
+			if isHubAlertmanagerConfig(c) {
+				return false
+			}
+		}
 	}
-	found.Data[clusterMonitoringConfigDataKey] = string(updatedClusterMonitoringConfigurationYAMLBytes)
-	err = client.Update(ctx, found)
-	if err != nil {
-		log.Error(err, "failed to update configmap", "name", clusterMonitoringConfigName)
-		return err
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
 	}
-	log.Info("configmap updated", "name", clusterMonitoringConfigName)
-	return nil
+	cm.Annotations[originalConfigAnnotation] = cm.Data[clusterMonitoringConfigDataKey]
+	return true
 }
@@ -5,6 +5,8 @@ package observabilityendpoint
 import (
 	"context"
 	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
@@ -43,15 +45,15 @@ func TestCreateDeleteHubAmRouterCASecret(t *testing.T) {
 
 	ctx := context.TODO()
 	c := fake.NewFakeClient(objs...)
-	err = createHubAmRouterCASecret(ctx, hubInfo, c)
+	err = createHubAmRouterCASecret(ctx, hubInfo, c, promNamespace)
 	if err != nil {
 		t.Fatalf("Failed to create the hub-alertmanager-router-ca secret: (%v)", err)
 	}
-	err = deleteHubAmRouterCASecret(ctx, c)
+	err = deleteHubAmRouterCASecret(ctx, c, promNamespace)
 	if err != nil {
 		t.Fatalf("Failed to delete the hub-alertmanager-router-ca secret: (%v)", err)
 	}
-	err = deleteHubAmRouterCASecret(ctx, c)
+	err = deleteHubAmRouterCASecret(ctx, c, promNamespace)
 	if err != nil {
 		t.Fatalf("Run into error when try to delete hub-alertmanager-router-ca secret twice: (%v)", err)
 	}
@@ -63,20 +65,40 @@ func TestCreateDeleteHubAmAccessorTokenSecret(t *testing.T) {
 
 	ctx := context.TODO()
 	c := fake.NewFakeClient(objs...)
-	err := createHubAmAccessorTokenSecret(ctx, c)
+	err := createHubAmAccessorTokenSecret(ctx, c, testNamespace, promNamespace)
 	if err != nil {
 		t.Fatalf("Failed to create the observability-alertmanager-accessor secret: (%v)", err)
 	}
-	err = deleteHubAmAccessorTokenSecret(ctx, c)
+	err = deleteHubAmAccessorTokenSecret(ctx, c, promNamespace)
 	if err != nil {
 		t.Fatalf("Failed to delete the observability-alertmanager-accessor secret: (%v)", err)
 	}
-	err = deleteHubAmAccessorTokenSecret(ctx, c)
+	err = deleteHubAmAccessorTokenSecret(ctx, c, promNamespace)
 	if err != nil {
 		t.Fatalf("Run into error when try to delete observability-alertmanager-accessor secret twice: (%v)", err)
 	}
 }
 
+func TestNewAlertmanagerConfig(t *testing.T) {
+	caseList := []struct {
+		endpoint string
+		expected string
+	}{
+		// the host starts with the characters of the scheme
+		{endpoint: "https://hub-am.example", expected: "hub-am.example"},
+		{endpoint: "https://hub-am.example:443", expected: "hub-am.example:443"},
+		{endpoint: "http://test-alertamanger-endpoint", expected: "test-alertamanger-endpoint"},
+		{endpoint: "hub-am.example", expected: "hub-am.example"},
+	}
+	for _, c := range caseList {
+		config := newAlertmanagerConfig(c.endpoint, hubAmRouterCASecretName, hubAmAccessorSecretName)
+		if !reflect.DeepEqual(config.StaticConfigs, []string{c.expected}) {
+			t.Errorf("Wrong static config for %s, expected: %s, actual: %v", c.endpoint, c.expected,
+				config.StaticConfigs)
+		}
+	}
+}
+
 func TestClusterMonitoringConfig(t *testing.T) {
 	tests := []struct {
 		name                                    string
@@ -93,14 +115,14 @@ func TestClusterMonitoringConfig(t *testing.T) {
 			name:                                    "cluster-monitoring-config with empty config.yaml",
 			ClusterMonitoringConfigCMExist:          true,
 			ClusterMonitoringConfigDataYaml:         "",
-			ExpectedDeleteClusterMonitoringConfigCM: true,
+			ExpectedDeleteClusterMonitoringConfigCM: false,
 		},
 		{
 			name:                           "cluster-monitoring-config with non-empty config.yaml and empty prometheusK8s",
 			ClusterMonitoringConfigCMExist: true,
 			ClusterMonitoringConfigDataYaml: `
 prometheusK8s: null`,
-			ExpectedDeleteClusterMonitoringConfigCM: true,
+			ExpectedDeleteClusterMonitoringConfigCM: false,
 		},
 		{
 			name:                           "cluster-monitoring-config with non-empty config.yaml and prometheusK8s and empty additionalAlertManagerConfigs",
@@ -108,7 +130,7 @@ prometheusK8s: null`,
 			ClusterMonitoringConfigDataYaml: `
 prometheusK8s:
   additionalAlertManagerConfigs: null`,
-			ExpectedDeleteClusterMonitoringConfigCM: true,
+			ExpectedDeleteClusterMonitoringConfigCM: false,
 		},
 		{
 			name:                           "cluster-monitoring-config with non-empty config.yaml and prometheusK8s and additionalAlertManagerConfigs",
@@ -144,14 +166,16 @@ prometheusK8s:
 			if tt.ClusterMonitoringConfigCMExist {
 				objs = append(objs, newClusterMonitoringConfigCM(tt.ClusterMonitoringConfigDataYaml))
 			}
-			testCreateOrUpdateClusterMonitoringConfig(t, hubInfo, fake.NewFakeClient(objs...), tt.ExpectedDeleteClusterMonitoringConfigCM)
+			testCreateOrUpdateClusterMonitoringConfig(t, hubInfo, fake.NewFakeClient(objs...), tt.ExpectedDeleteClusterMonitoringConfigCM,
+				tt.ClusterMonitoringConfigDataYaml)
 		})
 	}
 }
 
-func testCreateOrUpdateClusterMonitoringConfig(t *testing.T, hubInfo *HubInfo, c client.Client, expectedCMDelete bool) {
+func testCreateOrUpdateClusterMonitoringConfig(t *testing.T, hubInfo *HubInfo, c client.Client, expectedCMDelete bool,
+	originalConfig string) {
 	ctx := context.TODO()
-	err := createOrUpdateClusterMonitoringConfig(ctx, hubInfo, testClusterID, c)
+	_, _, err := createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID, nil, c, testNamespace)
 	if err != nil {
 		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
 	}
@@ -220,12 +244,25 @@ func testCreateOrUpdateClusterMonitoringConfig(t *testing.T, hubInfo *HubInfo, c
 		t.Fatalf("Failed to revert cluster-monitoring-config configmap: (%v)", err)
 	}
 
+	foundCusterMonitoringConfigMap = &corev1.ConfigMap{}
 	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName,
 		Namespace: promNamespace}, foundCusterMonitoringConfigMap)
 	if expectedCMDelete {
 		if err == nil || !errors.IsNotFound(err) {
 			t.Fatalf("the configmap %s should be deleted", clusterMonitoringConfigName)
 		}
+	} else {
+		if err != nil {
+			t.Fatalf("the configmap %s should be kept: %v", clusterMonitoringConfigName, err)
+		}
+		// the config created by the customer is restored as it was
+		if foundCusterMonitoringConfigMap.Data[clusterMonitoringConfigDataKey] != originalConfig {
+			t.Fatalf("the original config is not restored, expected:\n%s\nactual:\n%s", originalConfig,
+				foundCusterMonitoringConfigMap.Data[clusterMonitoringConfigDataKey])
+		}
+		if len(foundCusterMonitoringConfigMap.Annotations) != 0 {
+			t.Fatalf("the annotations are kept: %v", foundCusterMonitoringConfigMap.Annotations)
+		}
 	}
 
 	foundHubAmAccessorSecret := &corev1.Secret{}
@@ -247,3 +284,247 @@ func testCreateOrUpdateClusterMonitoringConfig(t *testing.T, hubInfo *HubInfo, c
 		t.Fatalf("Run into error when try to revert cluster-monitoring-config configmap twice: (%v)", err)
 	}
 }
+
+// conflictClient changes the configmap before the first update, so that the update runs into a conflict
+type conflictClient struct {
+	client.Client
+	conflicted bool
+}
+
+func (c *conflictClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if !c.conflicted {
+		c.conflicted = true
+		cm := &corev1.ConfigMap{}
+		if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), cm); err != nil {
+			return err
+		}
+		cm.Labels = map[string]string{"edited": "true"}
+		if err := c.Client.Update(ctx, cm); err != nil {
+			return err
+		}
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestClusterMonitoringConfigUnknownFields(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	ctx := context.TODO()
+	c := &conflictClient{Client: fake.NewFakeClient(newAMAccessorSecret(), newClusterMonitoringConfigCM(`
+enableUserWorkload: true
+newComponent:
+  foo: bar
+prometheusK8s:
+  collectionProfile: minimal
+  retention: 24h`))}
+
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if !c.conflicted {
+		t.Fatalf("The configmap is not updated")
+	}
+	cm := &corev1.ConfigMap{}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if cm.Labels["edited"] != "true" {
+		t.Fatalf("The change made in the meantime is overwritten: %v", cm.Labels)
+	}
+	config := map[string]interface{}{}
+	err = yamltool.Unmarshal([]byte(cm.Data[clusterMonitoringConfigDataKey]), &config)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal the cluster-monitoring-config configmap: (%v)", err)
+	}
+	pmK8sConfig := config[cmoPrometheusK8sKey].(map[string]interface{})
+	if pmK8sConfig["collectionProfile"] != "minimal" || pmK8sConfig["retention"] != "24h" ||
+		config["enableUserWorkload"] != true || config["newComponent"] == nil {
+		t.Fatalf("The unknown fields are not kept:\n%s", cm.Data[clusterMonitoringConfigDataKey])
+	}
+	if len(pmK8sConfig[cmoAlertmanagerConfigsKey].([]interface{})) != 1 {
+		t.Fatalf("Wrong alertmanager configs:\n%s", cm.Data[clusterMonitoringConfigDataKey])
+	}
+
+	// the configmap is not written again when nothing changes
+	resourceVersion := cm.ResourceVersion
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if cm.ResourceVersion != resourceVersion {
+		t.Fatalf("The configmap is updated without change")
+	}
+
+	err = revertClusterMonitoringConfig(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to revert the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	reverted := map[string]interface{}{}
+	err = yamltool.Unmarshal([]byte(cm.Data[clusterMonitoringConfigDataKey]), &reverted)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal the cluster-monitoring-config configmap: (%v)", err)
+	}
+	expected := map[string]interface{}{}
+	_ = yamltool.Unmarshal([]byte(`
+enableUserWorkload: true
+newComponent:
+  foo: bar
+prometheusK8s:
+  collectionProfile: minimal
+  retention: 24h`), &expected)
+	if !reflect.DeepEqual(reverted, expected) {
+		t.Fatalf("Wrong reverted config:\n%s", cm.Data[clusterMonitoringConfigDataKey])
+	}
+}
+
+func TestClusterMonitoringConfigComments(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	ctx := context.TODO()
+	c := fake.NewFakeClient(newAMAccessorSecret(), newClusterMonitoringConfigCM(`# managed by the platform team
+prometheusK8s:
+  # keep two days
+  retention: 48h
+  externalLabels:
+    region: us-east-1 # set by the installer
+alertmanagerMain:
+  enableUserAlertmanagerConfig: true
+`))
+	getConfig := func() string {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+		if err != nil {
+			t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+		}
+		return cm.Data[clusterMonitoringConfigDataKey]
+	}
+	// checkOrder checks that the comments are kept and the keys are in the same order
+	checkOrder := func(config string, keys ...string) {
+		last := -1
+		for _, k := range keys {
+			i := strings.Index(config, k)
+			if i <= last {
+				t.Fatalf("%s is missing or moved:\n%s", k, config)
+			}
+			last = i
+		}
+	}
+
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	checkOrder(getConfig(), "# managed by the platform team", "prometheusK8s:", "# keep two days", "retention: 48h",
+		"region: us-east-1 # set by the installer", "cluster: "+testClusterID, cmoAlertmanagerConfigsKey+":",
+		"alertmanagerMain:")
+
+	// the config is changed by others, so only the changes of the operator are reverted
+	cm := newClusterMonitoringConfigCM("")
+	err = c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+	}
+	cm.Data[clusterMonitoringConfigDataKey] += "grafana:\n  enabled: false\n"
+	err = c.Update(ctx, cm)
+	if err != nil {
+		t.Fatalf("Failed to update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = revertClusterMonitoringConfig(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to revert the cluster-monitoring-config configmap: (%v)", err)
+	}
+	config := getConfig()
+	checkOrder(config, "# managed by the platform team", "prometheusK8s:", "# keep two days", "retention: 48h",
+		"region: us-east-1 # set by the installer", "alertmanagerMain:", "grafana:")
+	if strings.Contains(config, "cluster:") || strings.Contains(config, cmoAlertmanagerConfigsKey) {
+		t.Fatalf("The changes of the operator are not reverted:\n%s", config)
+	}
+}
+
+func TestRestoreOriginalClusterMonitoringConfig(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	original := `# configured by the customer
+prometheusK8s:
+  externalLabels:
+    cluster: customer-cluster
+  retention: 24h
+`
+	ctx := context.TODO()
+	getCM := func(c client.Client) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: clusterMonitoringConfigName, Namespace: promNamespace}, cm)
+		if err != nil {
+			t.Fatalf("Failed to get the cluster-monitoring-config configmap: (%v)", err)
+		}
+		return cm
+	}
+
+	// the original config is restored as it was, with the cluster label of the customer
+	c := fake.NewFakeClient(newAMAccessorSecret(), newClusterMonitoringConfigCM(original))
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID,
+		map[string]string{"region": "us-east-1"}, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if cm := getCM(c); cm.Annotations[originalConfigAnnotation] != original {
+		t.Fatalf("The original config is not recorded: %v", cm.Annotations)
+	}
+	err = revertClusterMonitoringConfig(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to revert the cluster-monitoring-config configmap: (%v)", err)
+	}
+	if cm := getCM(c); cm.Data[clusterMonitoringConfigDataKey] != original || len(cm.Annotations) != 0 {
+		t.Fatalf("The original config is not restored: %v\n%s", cm.Annotations, cm.Data[clusterMonitoringConfigDataKey])
+	}
+
+	// the config changed by the customer in the meantime is kept, and the changes of the operator are reverted
+	c = fake.NewFakeClient(newAMAccessorSecret(), newClusterMonitoringConfigCM(original))
+	_, _, err = createOrUpdateClusterMonitoringConfig(ctx, hubInfo, nil, testClusterID,
+		map[string]string{"region": "us-east-1"}, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to create or update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	cm := getCM(c)
+	cm.Data[clusterMonitoringConfigDataKey] = strings.Replace(cm.Data[clusterMonitoringConfigDataKey],
+		"retention: 24h", "retention: 48h", 1)
+	err = c.Update(ctx, cm)
+	if err != nil {
+		t.Fatalf("Failed to update the cluster-monitoring-config configmap: (%v)", err)
+	}
+	err = revertClusterMonitoringConfig(ctx, c)
+	if err != nil {
+		t.Fatalf("Failed to revert the cluster-monitoring-config configmap: (%v)", err)
+	}
+	reverted := map[string]interface{}{}
+	err = yamltool.Unmarshal([]byte(getCM(c).Data[clusterMonitoringConfigDataKey]), &reverted)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal the cluster-monitoring-config configmap: (%v)", err)
+	}
+	expected := map[string]interface{}{cmoPrometheusK8sKey: map[string]interface{}{
+		"retention":          "48h",
+		cmoExternalLabelsKey: map[string]interface{}{clusterLabelKeyForAlerts: "customer-cluster"},
+	}}
+	if !reflect.DeepEqual(reverted, expected) {
+		t.Fatalf("Wrong reverted config, expected: %v, actual: %v", expected, reverted)
+	}
+}
@@ -4,7 +4,6 @@ package observabilityendpoint
 
 import (
 	"context"
-	"os"
 	"reflect"
 
 	ocinfrav1 "github.com/openshift/api/config/v1"
@@ -23,10 +22,6 @@ const (
 	caConfigmapName        = "metrics-collector-serving-certs-ca-bundle"
 )
 
-var (
-	serviceAccountName = os.Getenv("SERVICE_ACCOUNT")
-)
-
 func deleteMonitoringClusterRoleBinding(ctx context.Context, client client.Client) error {
 	rb := &rbacv1.ClusterRoleBinding{}
 	err := client.Get(ctx, types.NamespacedName{Name: clusterRoleBindingName,
@@ -48,7 +43,7 @@ func deleteMonitoringClusterRoleBinding(ctx context.Context, client client.Clien
 	return nil
 }
 
-func createMonitoringClusterRoleBinding(ctx context.Context, client client.Client) error {
+func createMonitoringClusterRoleBinding(ctx context.Context, client client.Client, ns string, serviceAccount string) error {
 	rb := &rbacv1.ClusterRoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: clusterRoleBindingName,
@@ -64,8 +59,8 @@ func createMonitoringClusterRoleBinding(ctx context.Context, client client.Clien
 		Subjects: []rbacv1.Subject{
 			{
 				Kind:      "ServiceAccount",
-				Name:      serviceAccountName,
-				Namespace: namespace,
+				Name:      serviceAccount,
+				Namespace: ns,
 			},
 		},
 	}
@@ -100,10 +95,10 @@ func createMonitoringClusterRoleBinding(ctx context.Context, client client.Clien
 	return nil
 }
 
-func deleteCAConfigmap(ctx context.Context, client client.Client) error {
+func deleteCAConfigmap(ctx context.Context, client client.Client, ns string) error {
 	cm := &corev1.ConfigMap{}
 	err := client.Get(ctx, types.NamespacedName{Name: caConfigmapName,
-		Namespace: namespace}, cm)
+		Namespace: ns}, cm)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("configmap already deleted")
@@ -121,16 +116,16 @@ func deleteCAConfigmap(ctx context.Context, client client.Client) error {
 	return nil
 }
 
-func createCAConfigmap(ctx context.Context, client client.Client) error {
+func createCAConfigmap(ctx context.Context, client client.Client, ns string) error {
 	cm := &corev1.ConfigMap{}
 	err := client.Get(ctx, types.NamespacedName{Name: caConfigmapName,
-		Namespace: namespace}, cm)
+		Namespace: ns}, cm)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			cm := &v1.ConfigMap{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      caConfigmapName,
-					Namespace: namespace,
+					Namespace: ns,
 					Annotations: map[string]string{
 						ownerLabelKey: ownerLabelValue,
 						"service.alpha.openshift.io/inject-cabundle": "true",
@@ -13,7 +13,7 @@ import (
 )
 
 const (
-	testClusterID = "kind-cluster-id"
+	testClusterID = "test-cluster-id"
 )
 
 var (
@@ -34,15 +34,15 @@ var (
 func TestCreateDeleteCAConfigmap(t *testing.T) {
 	ctx := context.TODO()
 	c := fake.NewFakeClient()
-	err := createCAConfigmap(ctx, c)
+	err := createCAConfigmap(ctx, c, testNamespace)
 	if err != nil {
 		t.Fatalf("Failed to create CA configmap: (%v)", err)
 	}
-	err = deleteCAConfigmap(ctx, c)
+	err = deleteCAConfigmap(ctx, c, testNamespace)
 	if err != nil {
 		t.Fatalf("Failed to delete CA configmap: (%v)", err)
 	}
-	err = deleteCAConfigmap(ctx, c)
+	err = deleteCAConfigmap(ctx, c, testNamespace)
 	if err != nil {
 		t.Fatalf("Run into error when try to delete CA configmap twice: (%v)", err)
 	}
@@ -51,7 +51,7 @@ func TestCreateDeleteCAConfigmap(t *testing.T) {
 func TestCreateDeleteMonitoringClusterRoleBinding(t *testing.T) {
 	ctx := context.TODO()
 	c := fake.NewFakeClient()
-	err := createMonitoringClusterRoleBinding(ctx, c)
+	err := createMonitoringClusterRoleBinding(ctx, c, testNamespace, testServiceAccount)
 	if err != nil {
 		t.Fatalf("Failed to create clusterrolebinding: (%v)", err)
 	}
@@ -71,8 +71,8 @@ func TestCreateDeleteMonitoringClusterRoleBinding(t *testing.T) {
 		Subjects: []rbacv1.Subject{
 			{
 				Kind:      "ServiceAccount",
-				Name:      serviceAccountName,
-				Namespace: namespace,
+				Name:      testServiceAccount,
+				Namespace: testNamespace,
 			},
 		},
 	}
@@ -80,7 +80,7 @@ func TestCreateDeleteMonitoringClusterRoleBinding(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to update clusterrolebinding: (%v)", err)
 	}
-	err = createMonitoringClusterRoleBinding(ctx, c)
+	err = createMonitoringClusterRoleBinding(ctx, c, testNamespace, testServiceAccount)
 	if err != nil {
 		t.Fatalf("Failed to revert clusterrolebinding: (%v)", err)
 	}
@@ -0,0 +1,121 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	ocinfrav1 "github.com/openshift/api/config/v1"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	platformOpenShift       = "OpenShift"
+	platformKubernetes      = "Kubernetes"
+	prometheusOperatedName  = "prometheus-operated"
+	prometheusSvcLabelKey   = "app.kubernetes.io/name"
+	prometheusSvcLabelValue = "prometheus"
+	prometheusWebPortName   = "web"
+	prometheusWebPort       = 9090
+	kubeSystemNamespace     = "kube-system"
+)
+
+// openShiftAPIs are only served by OpenShift: the ClusterVersion of OpenShift 4, and the Project of OpenShift 3.11
+// which has no ClusterVersion
+var openShiftAPIs = []schema.GroupVersionKind{
+	ocinfrav1.GroupVersion.WithKind("ClusterVersion"),
+	{Group: "project.openshift.io", Version: "v1", Kind: "Project"},
+}
+
+// getPlatform returns OpenShift if the OpenShift APIs are served, otherwise Kubernetes. The APIs are found by
+// discovery, so the platform doesn't change with the services running in the cluster.
+func getPlatform(ctx context.Context, c client.Client) (string, error) {
+	for _, gvk := range openShiftAPIs {
+		_, err := c.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err == nil {
+			return platformOpenShift, nil
+		}
+		if !meta.IsNoMatchError(err) {
+			log.Error(err, "Failed to discover the API", "kind", gvk.Kind, "group", gvk.Group)
+			return "", err
+		}
+	}
+	return platformKubernetes, nil
+}
+
+// discoverPrometheus finds a Prometheus managed by the Prometheus Operator, by the Prometheus CR first
+// and by the labeled service then. nil is returned if no Prometheus is found.
+func discoverPrometheus(ctx context.Context, c client.Client) (*MetricsSource, error) {
+	promList := &monitoringv1.PrometheusList{}
+	err := c.List(ctx, promList)
+	if err != nil && !meta.IsNoMatchError(err) {
+		log.Error(err, "Failed to list prometheus resources")
+		return nil, err
+	}
+	if err == nil && len(promList.Items) != 0 {
+		proms := promList.Items
+		sort.Slice(proms, func(i, j int) bool {
+			if proms[i].Namespace != proms[j].Namespace {
+				return proms[i].Namespace < proms[j].Namespace
+			}
+			return proms[i].Name < proms[j].Name
+		})
+		// the Prometheus Operator exposes the Prometheus servers in each namespace by the governing service
+		return &MetricsSource{
+			ServerURL: fmt.Sprintf("http://%s.%s.svc:%d", prometheusOperatedName, proms[0].Namespace, prometheusWebPort),
+		}, nil
+	}
+
+	svcList := &corev1.ServiceList{}
+	opts := &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{prometheusSvcLabelKey: prometheusSvcLabelValue}),
+	}
+	err = c.List(ctx, svcList, opts)
+	if err != nil {
+		log.Error(err, "Failed to list prometheus services")
+		return nil, err
+	}
+	svcs := svcList.Items
+	if len(svcs) == 0 {
+		return nil, nil
+	}
+	sort.Slice(svcs, func(i, j int) bool {
+		if svcs[i].Namespace != svcs[j].Namespace {
+			return svcs[i].Namespace < svcs[j].Namespace
+		}
+		return svcs[i].Name < svcs[j].Name
+	})
+	// use the web port, or the first port if no port is named as web
+	port := int32(prometheusWebPort)
+	if len(svcs[0].Spec.Ports) != 0 {
+		port = svcs[0].Spec.Ports[0].Port
+	}
+	for _, p := range svcs[0].Spec.Ports {
+		if p.Name == prometheusWebPortName {
+			port = p.Port
+			break
+		}
+	}
+	return &MetricsSource{
+		ServerURL: fmt.Sprintf("http://%s.%s.svc:%d", svcs[0].Name, svcs[0].Namespace, port),
+	}, nil
+}
+
+// getKubeClusterID returns the uid of the kube-system namespace, which is stable for the cluster life
+func getKubeClusterID(ctx context.Context, c client.Client) (string, error) {
+	ns := &corev1.Namespace{}
+	err := c.Get(ctx, types.NamespacedName{Name: kubeSystemNamespace}, ns)
+	if err != nil {
+		log.Error(err, "Failed to get the kube-system namespace")
+		return "", err
+	}
+	return string(ns.UID), nil
+}
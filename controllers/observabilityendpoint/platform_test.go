@@ -0,0 +1,166 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPrometheus(name string, ns string) *monitoringv1.Prometheus {
+	return &monitoringv1.Prometheus{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+		},
+	}
+}
+
+func newLabeledPromSvc(name string, ns string, ports []corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels: map[string]string{
+				prometheusSvcLabelKey: prometheusSvcLabelValue,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: ports,
+		},
+	}
+}
+
+// discoveryClient serves the RESTMapper of the cluster APIs, which is not implemented by the fake client
+type discoveryClient struct {
+	client.Client
+	openShift bool
+}
+
+func (c *discoveryClient) RESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	if c.openShift {
+		mapper.Add(openShiftAPIs[0], meta.RESTScopeRoot)
+		mapper.Add(alertRelabelConfigGVK, meta.RESTScopeNamespace)
+	}
+	return mapper
+}
+
+func TestGetPlatform(t *testing.T) {
+	ctx := context.TODO()
+	// the OpenShift prometheus service doesn't make the cluster OpenShift
+	c := &discoveryClient{Client: fake.NewFakeClient(newPromSvc())}
+	platform, err := getPlatform(ctx, c)
+	if err != nil || platform != platformKubernetes {
+		t.Fatalf("Wrong platform without OpenShift APIs: (%s) (%v)", platform, err)
+	}
+	c.openShift = true
+	platform, err = getPlatform(ctx, c)
+	if err != nil || platform != platformOpenShift {
+		t.Fatalf("Wrong platform with OpenShift APIs: (%s) (%v)", platform, err)
+	}
+	// OpenShift 3.11 has no ClusterVersion
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(openShiftAPIs[1], meta.RESTScopeRoot)
+	platform, err = getPlatform(ctx, &mapperClient{Client: fake.NewFakeClient(), mapper: mapper})
+	if err != nil || platform != platformOpenShift {
+		t.Fatalf("Wrong platform with OpenShift 3.11 APIs: (%s) (%v)", platform, err)
+	}
+}
+
+type mapperClient struct {
+	client.Client
+	mapper meta.RESTMapper
+}
+
+func (c *mapperClient) RESTMapper() meta.RESTMapper {
+	return c.mapper
+}
+
+func TestDiscoverPrometheus(t *testing.T) {
+	caseList := []struct {
+		name string
+		objs []runtime.Object
+		url  string
+	}{
+		{
+			name: "no prometheus",
+		},
+		{
+			name: "prometheus cr",
+			objs: []runtime.Object{
+				newPrometheus("k8s", "monitoring"),
+				newPrometheus("app", "app-monitoring"),
+				newLabeledPromSvc("prometheus-k8s", "default", nil),
+			},
+			url: "http://prometheus-operated.app-monitoring.svc:9090",
+		},
+		{
+			name: "labeled service with web port",
+			objs: []runtime.Object{
+				newLabeledPromSvc("prometheus-k8s", "monitoring", []corev1.ServicePort{
+					{Name: "reloader-web", Port: 8080},
+					{Name: prometheusWebPortName, Port: 9091},
+				}),
+			},
+			url: "http://prometheus-k8s.monitoring.svc:9091",
+		},
+		{
+			name: "labeled service without web port",
+			objs: []runtime.Object{
+				newLabeledPromSvc("prometheus", "monitoring", []corev1.ServicePort{
+					{Name: "http", Port: 80},
+				}),
+			},
+			url: "http://prometheus.monitoring.svc:80",
+		},
+	}
+
+	ctx := context.TODO()
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			source, err := discoverPrometheus(ctx, fake.NewFakeClient(c.objs...))
+			if err != nil {
+				t.Fatalf("Failed to discover prometheus: (%v)", err)
+			}
+			if c.url == "" {
+				if source != nil {
+					t.Fatalf("Unexpected metrics source found: (%v)", source)
+				}
+				return
+			}
+			if source == nil || source.ServerURL != c.url {
+				t.Fatalf("Wrong metrics source, expected: (%s), actual: (%v)", c.url, source)
+			}
+		})
+	}
+}
+
+func TestGetKubeClusterID(t *testing.T) {
+	ctx := context.TODO()
+	_, err := getKubeClusterID(ctx, fake.NewFakeClient())
+	if err == nil {
+		t.Fatal("Miss the error for missing kube-system namespace")
+	}
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: kubeSystemNamespace,
+			UID:  "test-kube-system-uid",
+		},
+	}
+	clusterID, err := getKubeClusterID(ctx, fake.NewFakeClient(ns))
+	if err != nil {
+		t.Fatalf("Failed to get cluster id: (%v)", err)
+	}
+	if clusterID != "test-kube-system-uid" {
+		t.Fatalf("Wrong cluster id: (%s)", clusterID)
+	}
+}
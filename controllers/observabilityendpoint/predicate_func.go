@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
@@ -44,6 +46,11 @@ func getPred(name string, namespace string,
 						e.ObjectOld.(*v1.Deployment).Spec.Template.Spec) {
 						return true
 					}
+					// the availability change is reflected in the addon status
+					if e.ObjectNew.(*v1.Deployment).Status.AvailableReplicas !=
+						e.ObjectOld.(*v1.Deployment).Status.AvailableReplicas {
+						return true
+					}
 				} else if e.ObjectNew.GetName() == obAddonName ||
 					e.ObjectNew.GetObjectKind().GroupVersionKind().Kind == "ObservabilityAddon" {
 					if !reflect.DeepEqual(e.ObjectNew.(*oav1beta1.ObservabilityAddon).Spec,
@@ -71,3 +78,89 @@ func getPred(name string, namespace string,
 		DeleteFunc: deleteFunc,
 	}
 }
+
+// getLabelPred returns the predicate for the objects in namespace which have or had the label
+func getLabelPred(labelKey string, labelValue string, namespace string) predicate.Funcs {
+	hasLabel := func(obj client.Object) bool {
+		return obj.GetNamespace() == namespace && obj.GetLabels()[labelKey] == labelValue
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return hasLabel(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return (hasLabel(e.ObjectNew) || hasLabel(e.ObjectOld)) &&
+				e.ObjectNew.GetResourceVersion() != e.ObjectOld.GetResourceVersion()
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return hasLabel(e.Object)
+		},
+	}
+}
+
+// getHubCollectorPred returns the predicate for the metrics collector deployments of the additional hubs in
+// namespace, the changes made by others in their pod template and their deletion are reverted
+func getHubCollectorPred(namespace string) predicate.Funcs {
+	isHubCollector := func(obj client.Object) bool {
+		return obj.GetNamespace() == namespace && obj.GetLabels()[additionalHubLabelKey] == additionalHubLabelValue
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			newDeploy, ok := e.ObjectNew.(*v1.Deployment)
+			if !ok || !isHubCollector(e.ObjectOld) {
+				return false
+			}
+			oldDeploy, ok := e.ObjectOld.(*v1.Deployment)
+			return !ok || !isHubCollector(newDeploy) ||
+				!reflect.DeepEqual(newDeploy.Spec.Template.Spec, oldDeploy.Spec.Template.Spec)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return isHubCollector(e.Object)
+		},
+	}
+}
+
+// getCollectorPodPred returns the predicate for the metrics collector pods in namespace, only the changes of the
+// phase, the readiness and the container states are reflected in the addon status
+func getCollectorPodPred(namespace string) predicate.Funcs {
+	isCollectorPod := func(obj client.Object) bool {
+		return obj.GetNamespace() == namespace && obj.GetLabels()[selectorKey] == selectorValue
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			newPod, ok := e.ObjectNew.(*corev1.Pod)
+			if !ok || !isCollectorPod(newPod) {
+				return false
+			}
+			oldPod, ok := e.ObjectOld.(*corev1.Pod)
+			return !ok || !reflect.DeepEqual(getPodHealthSummary(oldPod), getPodHealthSummary(newPod))
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return isCollectorPod(e.Object)
+		},
+	}
+}
+
+// getPodHealthSummary returns the fields of the pod status which the metrics collector health is evaluated from
+func getPodHealthSummary(pod *corev1.Pod) []string {
+	summary := []string{string(pod.Status.Phase)}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			summary = append(summary, string(c.Status))
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		waiting := ""
+		if status.State.Waiting != nil {
+			waiting = status.State.Waiting.Reason
+		}
+		summary = append(summary, fmt.Sprintf("%s/%t/%d/%s", status.Name, status.Ready, status.RestartCount, waiting))
+	}
+	return summary
+}
@@ -126,6 +126,10 @@ func TestPredFunc(t *testing.T) {
 				if pred.UpdateFunc(ue) {
 					t.Fatalf("pre func return true on same deployment spec in case: (%v)", c.caseName)
 				}
+				ue.ObjectNew.(*appsv1.Deployment).Status.AvailableReplicas = 1
+				if !pred.UpdateFunc(ue) {
+					t.Fatalf("pre func return false on changed available replicas in case: (%v)", c.caseName)
+				}
 			} else {
 				if pred.UpdateFunc(ue) {
 					t.Fatalf("pre func return true on non-applied updateevent in case: (%v)", c.caseName)
@@ -159,3 +163,113 @@ func TestPredFunc(t *testing.T) {
 		})
 	}
 }
+
+func TestLabelPredFunc(t *testing.T) {
+	pred := getLabelPred(allowlistLabelKey, allowlistLabelValue, testNamespace)
+	labeled := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-obj",
+			Namespace:       testNamespace,
+			Labels:          map[string]string{allowlistLabelKey: allowlistLabelValue},
+			ResourceVersion: "2",
+		},
+	}
+	unlabeled := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-obj",
+			Namespace:       testNamespace,
+			ResourceVersion: "1",
+		},
+	}
+
+	if !pred.CreateFunc(event.CreateEvent{Object: labeled}) {
+		t.Fatal("pred func return false on labeled createevent")
+	}
+	if pred.CreateFunc(event.CreateEvent{Object: unlabeled}) {
+		t.Fatal("pred func return true on unlabeled createevent")
+	}
+	if !pred.UpdateFunc(event.UpdateEvent{ObjectNew: unlabeled, ObjectOld: labeled}) {
+		t.Fatal("pred func return false on label removed updateevent")
+	}
+	if pred.UpdateFunc(event.UpdateEvent{ObjectNew: labeled, ObjectOld: labeled}) {
+		t.Fatal("pred func return true on same resource version")
+	}
+	if !pred.DeleteFunc(event.DeleteEvent{Object: labeled}) {
+		t.Fatal("pred func return false on labeled deleteevent")
+	}
+	labeled.SetNamespace("other-ns")
+	if pred.DeleteFunc(event.DeleteEvent{Object: labeled}) {
+		t.Fatal("pred func return true on deleteevent in other namespace")
+	}
+}
+
+func TestCollectorPodPredFunc(t *testing.T) {
+	pred := getCollectorPodPred(testNamespace)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "metrics-collector-deployment-1",
+			Namespace:       testNamespace,
+			Labels:          map[string]string{selectorKey: selectorValue},
+			ResourceVersion: "1",
+		},
+		Status: v1.PodStatus{
+			Phase:             v1.PodRunning,
+			ContainerStatuses: []v1.ContainerStatus{{Name: "metrics-collector", Ready: true}},
+		},
+	}
+	updated := pod.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Status.PodIP = "10.0.0.1"
+
+	if pred.CreateFunc(event.CreateEvent{Object: pod}) {
+		t.Fatal("pod pred func return true on createevent")
+	}
+	if pred.UpdateFunc(event.UpdateEvent{ObjectNew: updated, ObjectOld: pod}) {
+		t.Fatal("pod pred func return true on updateevent without health change")
+	}
+	updated.Status.ContainerStatuses[0].Ready = false
+	updated.Status.ContainerStatuses[0].RestartCount = 1
+	if !pred.UpdateFunc(event.UpdateEvent{ObjectNew: updated, ObjectOld: pod}) {
+		t.Fatal("pod pred func return false on updateevent with readiness change")
+	}
+	if !pred.DeleteFunc(event.DeleteEvent{Object: pod}) {
+		t.Fatal("pod pred func return false on deleteevent")
+	}
+	pod.SetNamespace("other-ns")
+	if pred.DeleteFunc(event.DeleteEvent{Object: pod}) {
+		t.Fatal("pod pred func return true on deleteevent in other namespace")
+	}
+}
+
+func TestHubCollectorPredFunc(t *testing.T) {
+	pred := getHubCollectorPred(testNamespace)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            hubCollectorNamePrefix + "hub-a",
+			Namespace:       testNamespace,
+			Labels:          map[string]string{additionalHubLabelKey: additionalHubLabelValue},
+			ResourceVersion: "1",
+		},
+	}
+	updated := deploy.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Status.AvailableReplicas = 1
+
+	if pred.CreateFunc(event.CreateEvent{Object: deploy}) {
+		t.Fatal("hub collector pred func return true on createevent")
+	}
+	if pred.UpdateFunc(event.UpdateEvent{ObjectNew: updated, ObjectOld: deploy}) {
+		t.Fatal("hub collector pred func return true on updateevent without pod template change")
+	}
+	updated.Spec.Template.Spec.ServiceAccountName = "edited"
+	if !pred.UpdateFunc(event.UpdateEvent{ObjectNew: updated, ObjectOld: deploy}) {
+		t.Fatal("hub collector pred func return false on updateevent with pod template change")
+	}
+	if !pred.DeleteFunc(event.DeleteEvent{Object: deploy}) {
+		t.Fatal("hub collector pred func return false on deleteevent")
+	}
+	deploy.Labels = nil
+	if pred.DeleteFunc(event.DeleteEvent{Object: deploy}) {
+		t.Fatal("hub collector pred func return true on deleteevent without the label")
+	}
+}
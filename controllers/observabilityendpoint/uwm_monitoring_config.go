@@ -0,0 +1,216 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cmomanifests "github.com/openshift/cluster-monitoring-operator/pkg/manifests"
+)
+
+const (
+	uwmNamespace              = "openshift-user-workload-monitoring"
+	uwmConfigName             = "user-workload-monitoring-config"
+	uwmConfigDataKey          = "config.yaml"
+	uwmPrometheusKey          = "prometheus"
+	uwmThanosRulerKey         = "thanosRuler"
+	uwmAlertmanagerConfigsKey = "additionalAlertmanagerConfigs"
+)
+
+// uwmAlertingComponents are the user workload monitoring components which send alerts to the Hub's Alertmanager
+var uwmAlertingComponents = []string{uwmPrometheusKey, uwmThanosRulerKey}
+
+// createOrUpdateUWMConfig creates or updates the configmap user-workload-monitoring-config and relevant resources
+// (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift user workload monitoring stack.
+// The alerts are sent to the Alertmanager of each additional hub as well. The unknown fields in the configmap are kept as they are.
+func createOrUpdateUWMConfig(ctx context.Context, hubInfo *HubInfo, additionalHubs []additionalHub,
+	client client.Client, addonNs string) error {
+	// the user workload monitoring is not available before OCP 4.6
+	ns := &corev1.Namespace{}
+	err := client.Get(ctx, types.NamespacedName{Name: uwmNamespace}, ns)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("namespace not found, skip the user workload monitoring config", "namespace", uwmNamespace)
+			return nil
+		}
+		log.Error(err, "failed to check namespace", "namespace", uwmNamespace)
+		return err
+	}
+
+	if err := createHubAmRouterCASecret(ctx, hubInfo, client, uwmNamespace); err != nil {
+		log.Error(err, "failed to create or update the hub-alertmanager-router-ca secret", "namespace", uwmNamespace)
+		return err
+	}
+	if err := createHubAmAccessorTokenSecret(ctx, client, addonNs, uwmNamespace); err != nil {
+		log.Error(err, "failed to create or update the observability-alertmanager-accessor secret", "namespace", uwmNamespace)
+		return err
+	}
+	if err := createAdditionalHubAmSecrets(ctx, client, additionalHubs, uwmNamespace); err != nil {
+		return err
+	}
+
+	amConfigs, err := getAlertmanagerConfigValues(append(
+		[]cmomanifests.AdditionalAlertmanagerConfig{newHubAlertmanagerConfig(hubInfo)},
+		getAdditionalHubAlertmanagerConfigs(additionalHubs)...))
+	if err != nil {
+		return err
+	}
+
+	// the alertmanager configs of the removed additional hubs
+	var stale []interface{}
+	// the configmap is read again when it's changed by others in the meantime
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		found := &corev1.ConfigMap{}
+		err := client.Get(ctx, types.NamespacedName{Name: uwmConfigName, Namespace: uwmNamespace}, found)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				log.Error(err, "failed to check configmap", "name", uwmConfigName)
+				return err
+			}
+			doc, _ := parseMonitoringConfig("")
+			_, _, err = addAlertmanagerConfigs(doc.Content[0], uwmAlertingComponents, uwmAlertmanagerConfigsKey,
+				amConfigs)
+			if err != nil {
+				return err
+			}
+			data, err := marshalMonitoringConfig(doc, defaultMonitoringConfigIndent)
+			if err != nil {
+				log.Error(err, "failed to marshal the user workload monitoring config")
+				return err
+			}
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      uwmConfigName,
+					Namespace: uwmNamespace,
+					// the configmap is deleted when the changes are reverted
+					Annotations: map[string]string{createdConfigAnnotation: "true"},
+				},
+				Data: map[string]string{uwmConfigDataKey: data},
+			}
+			err = client.Create(ctx, cm)
+			if err != nil {
+				log.Error(err, "failed to create configmap", "name", uwmConfigName)
+				return err
+			}
+			log.Info("configmap created", "name", uwmConfigName)
+			return nil
+		}
+
+		doc, err := parseMonitoringConfig(found.Data[uwmConfigDataKey])
+		if err != nil {
+			log.Error(err, "failed to unmarshal the user workload monitoring config", "name", uwmConfigName)
+			return err
+		}
+		changed, removed, err := addAlertmanagerConfigs(doc.Content[0], uwmAlertingComponents,
+			uwmAlertmanagerConfigsKey, amConfigs)
+		if err != nil {
+			return err
+		}
+		stale = removed
+		if !changed {
+			log.Info("no change for configmap", "name", uwmConfigName)
+			return nil
+		}
+		data, err := marshalMonitoringConfig(doc, getMonitoringConfigIndent(found.Data[uwmConfigDataKey]))
+		if err != nil {
+			log.Error(err, "failed to marshal the user workload monitoring config")
+			return err
+		}
+		if found.Data == nil {
+			found.Data = map[string]string{}
+		}
+		found.Data[uwmConfigDataKey] = data
+		err = client.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "failed to update configmap", "name", uwmConfigName)
+			return err
+		}
+		log.Info("configmap updated", "name", uwmConfigName)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the secrets of the removed additional hubs are not referenced anymore
+	return deleteRemovedAlertmanagerConfigSecrets(ctx, client, stale, uwmNamespace)
+}
+
+// revertUWMConfig reverts the configmap user-workload-monitoring-config and relevant resources
+// (observability-alertmanager-accessor and hub-alertmanager-router-ca) for the openshift user workload monitoring stack.
+// The configmap is only deleted when it's created by the operator and nothing else is configured in it.
+func revertUWMConfig(ctx context.Context, client client.Client) error {
+	if err := deleteHubAmRouterCASecret(ctx, client, uwmNamespace); err != nil {
+		log.Error(err, "failed to delete the hub-alertmanager-router-ca secret", "namespace", uwmNamespace)
+		return err
+	}
+	if err := deleteHubAmAccessorTokenSecret(ctx, client, uwmNamespace); err != nil {
+		log.Error(err, "failed to delete the observability-alertmanager-accessor secret", "namespace", uwmNamespace)
+		return err
+	}
+
+	var removed []interface{}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		found := &corev1.ConfigMap{}
+		err := client.Get(ctx, types.NamespacedName{Name: uwmConfigName, Namespace: uwmNamespace}, found)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				log.Info("configmap not found, no need action", "name", uwmConfigName)
+				return nil
+			}
+			log.Error(err, "failed to check configmap", "name", uwmConfigName)
+			return err
+		}
+
+		doc, err := parseMonitoringConfig(found.Data[uwmConfigDataKey])
+		if err != nil {
+			log.Error(err, "failed to unmarshal the user workload monitoring config", "name", uwmConfigName)
+			return err
+		}
+		config := doc.Content[0]
+		changed, amRemoved := removeAlertmanagerConfigs(config, uwmAlertingComponents, uwmAlertmanagerConfigsKey, nil)
+		removed = amRemoved
+		created := found.Annotations[createdConfigAnnotation] == "true"
+		if !changed && !created {
+			log.Info("no alertmanager config for the hub in configmap, no need action", "name", uwmConfigName)
+			return nil
+		}
+
+		if len(config.Content) == 0 && created {
+			err = client.Delete(ctx, found, unchangedPrecondition(found))
+			if err != nil {
+				log.Error(err, "failed to delete configmap", "name", uwmConfigName)
+				return err
+			}
+			log.Info("configmap deleted", "name", uwmConfigName)
+			return nil
+		}
+		// the configmap created by the operator is kept once others configured it
+		delete(found.Annotations, createdConfigAnnotation)
+		if changed {
+			data, err := marshalMonitoringConfig(doc, getMonitoringConfigIndent(found.Data[uwmConfigDataKey]))
+			if err != nil {
+				log.Error(err, "failed to marshal the user workload monitoring config")
+				return err
+			}
+			found.Data[uwmConfigDataKey] = data
+		}
+		err = client.Update(ctx, found)
+		if err != nil {
+			log.Error(err, "failed to update configmap", "name", uwmConfigName)
+			return err
+		}
+		log.Info("configmap reverted", "name", uwmConfigName)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteRemovedAlertmanagerConfigSecrets(ctx, client, removed, uwmNamespace)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package observabilityendpoint
+
+import (
+	"context"
+	"testing"
+
+	yamltool "github.com/ghodss/yaml"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newUWMNamespace() *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: uwmNamespace,
+		},
+	}
+}
+
+func newUWMConfigCM(configDataStr string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      uwmConfigName,
+			Namespace: uwmNamespace,
+		},
+		Data: map[string]string{
+			uwmConfigDataKey: configDataStr,
+		},
+	}
+}
+
+func getUWMConfig(t *testing.T, c client.Client) map[string]interface{} {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(context.TODO(), types.NamespacedName{Name: uwmConfigName, Namespace: uwmNamespace}, cm)
+	if err != nil {
+		t.Fatalf("Failed to get configmap %s: (%v)", uwmConfigName, err)
+	}
+	config := map[string]interface{}{}
+	err = yamltool.Unmarshal([]byte(cm.Data[uwmConfigDataKey]), &config)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal configmap %s: (%v)", uwmConfigName, err)
+	}
+	return config
+}
+
+func countHubAlertmanagerConfigs(config map[string]interface{}, component string) int {
+	componentConfig, _ := config[component].(map[string]interface{})
+	amConfigs, _ := componentConfig[uwmAlertmanagerConfigsKey].([]interface{})
+	count := 0
+	for _, c := range amConfigs {
+		if isHubAlertmanagerConfig(c) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCreateOrUpdateUWMConfig(t *testing.T) {
+	hubInfo := &HubInfo{}
+	err := yaml.Unmarshal([]byte(hubInfoYAML), &hubInfo)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal hubInfo: (%v)", err)
+	}
+	ctx := context.TODO()
+
+	// the user workload monitoring config is skipped if the namespace doesn't exist
+	c := fake.NewFakeClient(newAMAccessorSecret())
+	err = createOrUpdateUWMConfig(ctx, hubInfo, nil, c, testNamespace)
+	if err != nil {
+		t.Fatalf("Failed to skip the user workload monitoring config: (%v)", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: uwmConfigName, Namespace: uwmNamespace}, &corev1.ConfigMap{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("configmap %s should not be created without namespace %s", uwmConfigName, uwmNamespace)
+	}
+
+	tests := []struct {
+		name           string
+		objs           []runtime.Object
+		expectedDelete bool
+		otherSettings  bool
+	}{
+		{
+			name:           "no user-workload-monitoring-config",
+			expectedDelete: true,
+		},
+		{
+			name: "user-workload-monitoring-config with other settings",
+			objs: []runtime.Object{newUWMConfigCM(`
+prometheus:
+  retention: 24h
+  additionalAlertmanagerConfigs:
+  - apiVersion: v2
+    scheme: https
+    staticConfigs:
+    - test-host.com
+thanosRuler:
+  logLevel: debug
+unknownComponent:
+  foo: bar`)},
+			otherSettings: true,
+		},
+		{
+			// the configmap created by the customer is kept, even if nothing else is configured in it
+			name: "empty user-workload-monitoring-config",
+			objs: []runtime.Object{newUWMConfigCM("")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := append([]runtime.Object{newUWMNamespace(), newAMAccessorSecret()}, tt.objs...)
+			// the configmap is changed by others before it's updated for the first time
+			c := &conflictClient{Client: fake.NewFakeClient(objs...)}
+
+			// run twice to make sure the hub alertmanager config is added only once
+			for i := 0; i < 2; i++ {
+				err := createOrUpdateUWMConfig(ctx, hubInfo, nil, c, testNamespace)
+				if err != nil {
+					t.Fatalf("Failed to create or update the user workload monitoring config: (%v)", err)
+				}
+			}
+			config := getUWMConfig(t, c)
+			for _, component := range uwmAlertingComponents {
+				if n := countHubAlertmanagerConfigs(config, component); n != 1 {
+					t.Fatalf("Expect 1 hub alertmanager config in %s, found %d: %v", component, n, config)
+				}
+			}
+			for _, name := range []string{hubAmRouterCASecretName, hubAmAccessorSecretName} {
+				err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: uwmNamespace}, &corev1.Secret{})
+				if err != nil {
+					t.Fatalf("Secret %s not created in namespace %s: (%v)", name, uwmNamespace, err)
+				}
+			}
+
+			err := revertUWMConfig(ctx, c)
+			if err != nil {
+				t.Fatalf("Failed to revert the user workload monitoring config: (%v)", err)
+			}
+			for _, name := range []string{hubAmRouterCASecretName, hubAmAccessorSecretName} {
+				err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: uwmNamespace}, &corev1.Secret{})
+				if !errors.IsNotFound(err) {
+					t.Fatalf("Secret %s should be deleted in namespace %s", name, uwmNamespace)
+				}
+			}
+			cm := &corev1.ConfigMap{}
+			err = c.Get(ctx, types.NamespacedName{Name: uwmConfigName, Namespace: uwmNamespace}, cm)
+			if tt.expectedDelete {
+				if !errors.IsNotFound(err) {
+					t.Fatalf("configmap %s should be deleted", uwmConfigName)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("configmap %s should be kept: (%v)", uwmConfigName, err)
+				}
+				if cm.Labels["edited"] != "true" {
+					t.Fatalf("The change made in the meantime is overwritten: %v", cm.Labels)
+				}
+				config := getUWMConfig(t, c)
+				for _, component := range uwmAlertingComponents {
+					if n := countHubAlertmanagerConfigs(config, component); n != 0 {
+						t.Fatalf("Hub alertmanager config not removed from %s: %v", component, config)
+					}
+				}
+				if tt.otherSettings {
+					prometheus := config[uwmPrometheusKey].(map[string]interface{})
+					if prometheus["retention"] != "24h" || len(prometheus[uwmAlertmanagerConfigsKey].([]interface{})) != 1 {
+						t.Fatalf("Other prometheus settings not kept: %v", config)
+					}
+					if config[uwmThanosRulerKey].(map[string]interface{})["logLevel"] != "debug" {
+						t.Fatalf("Other thanos ruler settings not kept: %v", config)
+					}
+					if config["unknownComponent"] == nil {
+						t.Fatalf("Unknown fields not kept: %v", config)
+					}
+				}
+			}
+
+			err = revertUWMConfig(ctx, c)
+			if err != nil {
+				t.Fatalf("Run into error when try to revert the user workload monitoring config twice: (%v)", err)
+			}
+		})
+	}
+}
@@ -4,17 +4,27 @@ package status
 
 import (
 	"context"
-	"os"
+	"crypto/sha256"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"net"
 	"reflect"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
 )
 
@@ -24,18 +34,30 @@ var (
 
 const (
 	obAddonName = "observability-addon"
+	// hubStatusAnnotation records the hash of the last status delivered to the hub
+	hubStatusAnnotation = "observability.open-cluster-management.io/hub-status-hash"
+	// statusResyncPeriod is the period to check the status in the hub again, so that it converges after hub outages
+	statusResyncPeriod = 5 * time.Minute
 )
 
-var (
-	namespace    = os.Getenv("WATCH_NAMESPACE")
-	hubNamespace = os.Getenv("HUB_NAMESPACE")
-)
+// statusSyncBackoff is the backoff to retry the status update in the hub, the failures after the retries
+// are retried later by the exponential backoff of the controller
+var statusSyncBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 100 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
 
 // StatusReconciler reconciles status object
 type StatusReconciler struct {
 	Client    client.Client
 	Scheme    *runtime.Scheme
 	HubClient client.Client
+	// Config is the operator configuration
+	Config *config.OperatorConfig
+	// lastResync is the last time the status in the hub was checked
+	lastResync time.Time
 }
 
 // Reconcile reads that state of the cluster for a ObservabilityAddon object and makes changes based on the state read
@@ -46,46 +68,118 @@ func (r *StatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	log := log.WithValues("Request.Namespace", req.Namespace, "Request.Name", req.Name)
 	log.Info("Reconciling")
 
-	// Fetch the ObservabilityAddon instance in hub cluster
-	hubObsAddon := &oav1beta1.ObservabilityAddon{}
-	err := r.HubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: hubNamespace}, hubObsAddon)
+	// Fetch the ObservabilityAddon instance in local cluster
+	obsAddon := &oav1beta1.ObservabilityAddon{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: r.Config.Namespace}, obsAddon)
 	if err != nil {
-		log.Error(err, "Failed to get observabilityaddon in hub cluster", "namespace", hubNamespace)
+		log.Error(err, "Failed to get observabilityaddon", "namespace", r.Config.Namespace)
 		return ctrl.Result{}, err
 	}
 
-	// Fetch the ObservabilityAddon instance in local cluster
-	obsAddon := &oav1beta1.ObservabilityAddon{}
-	err = r.Client.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: namespace}, obsAddon)
+	// the status recorded as delivered is only checked in the hub again by the periodic resync
+	if isDelivered(obsAddon) && time.Since(r.lastResync) < statusResyncPeriod {
+		log.V(1).Info("Status already delivered to hub cluster", "namespace", r.Config.HubNamespace)
+		return ctrl.Result{RequeueAfter: statusResyncPeriod - time.Since(r.lastResync)}, nil
+	}
+
+	// the hub addon is fetched again for each retry, so that the conflicts are resolved with the latest version
+	updated := false
+	err = retry.OnError(statusSyncBackoff, isRetriable, func() error {
+		hubObsAddon := &oav1beta1.ObservabilityAddon{}
+		err := r.HubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: r.Config.HubNamespace}, hubObsAddon)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(hubObsAddon.Status, obsAddon.Status) {
+			return nil
+		}
+		hubObsAddon.Status = obsAddon.Status
+		err = r.HubClient.Status().Update(ctx, hubObsAddon)
+		if err == nil {
+			updated = true
+		}
+		return err
+	})
 	if err != nil {
-		log.Error(err, "Failed to get observabilityaddon", "namespace", namespace)
+		log.Error(err, "Failed to update status for observabilityaddon in hub cluster", "namespace", r.Config.HubNamespace)
 		return ctrl.Result{}, err
 	}
+	if updated {
+		log.Info("Status updated for observabilityaddon in hub cluster", "namespace", r.Config.HubNamespace)
+	}
+	r.lastResync = time.Now()
 
-	hubObsAddon.Status = obsAddon.Status
+	err = r.recordHubStatus(ctx, obsAddon)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: statusResyncPeriod}, nil
+}
 
-	err = r.HubClient.Status().Update(ctx, hubObsAddon)
+// recordHubStatus records the status delivered to the hub in the local observabilityaddon
+func (r *StatusReconciler) recordHubStatus(ctx context.Context, obsAddon *oav1beta1.ObservabilityAddon) error {
+	hash, err := getStatusHash(obsAddon.Status)
 	if err != nil {
-		log.Error(err, "Failed to update status for observabilityaddon in hub cluster", "namespace", hubNamespace)
+		return err
 	}
+	if obsAddon.GetAnnotations()[hubStatusAnnotation] == hash {
+		return nil
+	}
+	patch := client.MergeFrom(obsAddon.DeepCopy())
+	annotations := obsAddon.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[hubStatusAnnotation] = hash
+	obsAddon.SetAnnotations(annotations)
+	err = r.Client.Patch(ctx, obsAddon, patch)
+	if err != nil {
+		log.Error(err, "Failed to record the status delivered to the hub", "namespace", r.Config.Namespace)
+		return err
+	}
+	return nil
+}
 
-	return ctrl.Result{}, nil
+// isDelivered checks if the status of the local observabilityaddon is recorded as delivered to the hub
+func isDelivered(obsAddon *oav1beta1.ObservabilityAddon) bool {
+	hash, err := getStatusHash(obsAddon.Status)
+	return err == nil && obsAddon.GetAnnotations()[hubStatusAnnotation] == hash
+}
+
+// getStatusHash returns the hash of the status
+func getStatusHash(status oav1beta1.ObservabilityAddonStatus) (string, error) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		log.Error(err, "Failed to marshal the status")
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// isRetriable checks if the error is transient, e.g. a conflict or a network failure
+func isRetriable(err error) bool {
+	var netErr net.Error
+	return errors.IsConflict(err) || errors.IsServerTimeout(err) || errors.IsTimeout(err) ||
+		errors.IsTooManyRequests(err) || errors.IsServiceUnavailable(err) || errors.IsInternalError(err) ||
+		utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err) ||
+		goerrors.Is(err, context.DeadlineExceeded) || goerrors.As(err, &netErr)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *StatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	if os.Getenv("NAMESPACE") != "" {
-		namespace = os.Getenv("NAMESPACE")
-	}
+	namespace := r.Config.Namespace
 
 	pred := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
-			return false
+			// sync the status once the operator starts, the periodic resync follows
+			return e.Object.GetNamespace() == namespace
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
+			// the status not recorded as delivered is replayed, e.g. after the retries are exhausted
 			if e.ObjectNew.GetNamespace() == namespace &&
-				!reflect.DeepEqual(e.ObjectNew.(*oav1beta1.ObservabilityAddon).Status,
-					e.ObjectOld.(*oav1beta1.ObservabilityAddon).Status) {
+				(!reflect.DeepEqual(e.ObjectNew.(*oav1beta1.ObservabilityAddon).Status,
+					e.ObjectOld.(*oav1beta1.ObservabilityAddon).Status) ||
+					!isDelivered(e.ObjectNew.(*oav1beta1.ObservabilityAddon))) {
 				return true
 			}
 			return false
@@ -4,16 +4,26 @@ package status
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
 	"testing"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	addonv1alpha1 "github.com/open-cluster-management/api/addon/v1alpha1"
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
 	oashared "github.com/open-cluster-management/multicluster-observability-operator/api/shared"
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
 )
@@ -37,13 +47,34 @@ func newObservabilityAddon(name string, ns string) *oav1beta1.ObservabilityAddon
 	}
 }
 
+// failingClient fails the status updates with the errors in order
+type failingClient struct {
+	client.Client
+	errs []error
+}
+
+type failingStatusWriter struct {
+	client.StatusWriter
+	c *failingClient
+}
+
+func (c *failingClient) Status() client.StatusWriter {
+	return &failingStatusWriter{StatusWriter: c.Client.Status(), c: c}
+}
+
+func (w *failingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if len(w.c.errs) != 0 {
+		err := w.c.errs[0]
+		w.c.errs = w.c.errs[1:]
+		return err
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
 func init() {
 	s := scheme.Scheme
 	addonv1alpha1.AddToScheme(s)
 	oav1beta1.AddToScheme(s)
-
-	namespace = testNamespace
-	hubNamespace = testHubNamspace
 }
 
 func TestStatusController(t *testing.T) {
@@ -54,6 +85,7 @@ func TestStatusController(t *testing.T) {
 	r := &StatusReconciler{
 		Client:    c,
 		HubClient: hubClient,
+		Config:    &config.OperatorConfig{Namespace: testNamespace, HubNamespace: testHubNamspace},
 	}
 
 	// test error in reconcile if missing obervabilityaddon
@@ -112,3 +144,153 @@ func TestStatusController(t *testing.T) {
 		t.Fatalf("Wrong status type: (%v)", hubObsAddon.Status)
 	}
 }
+
+func TestStatusControllerRetry(t *testing.T) {
+	ctx := context.TODO()
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      "install",
+			Namespace: testNamespace,
+		},
+	}
+	conflict := errors.NewConflict(schema.GroupResource{Resource: "observabilityaddons"}, name, nil)
+	forbidden := errors.NewForbidden(schema.GroupResource{Resource: "observabilityaddons"}, name, nil)
+	oba := newObservabilityAddon(name, testNamespace)
+	oba.Status = oav1beta1.ObservabilityAddonStatus{
+		Conditions: []oav1beta1.StatusCondition{
+			{
+				Type:    "Available",
+				Status:  metav1.ConditionTrue,
+				Reason:  "Deployed",
+				Message: "Metrics collector deployed",
+			},
+		},
+	}
+	hubClient := &failingClient{
+		Client: fake.NewFakeClient(newObservabilityAddon(name, testHubNamspace)),
+		errs:   []error{conflict, forbidden},
+	}
+	c := fake.NewFakeClient(oba)
+	r := &StatusReconciler{
+		Client:    c,
+		HubClient: hubClient,
+		Config:    &config.OperatorConfig{Namespace: testNamespace, HubNamespace: testHubNamspace},
+	}
+
+	// the conflict is retried, the forbidden error is returned to be retried later
+	_, err := r.Reconcile(ctx, req)
+	if !errors.IsForbidden(err) {
+		t.Fatalf("reconcile: miss the error for failed status update: (%v)", err)
+	}
+	local := &oav1beta1.ObservabilityAddon{}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, local)
+	if err != nil {
+		t.Fatalf("Failed to get local oba: (%v)", err)
+	}
+	if local.GetAnnotations()[hubStatusAnnotation] != "" {
+		t.Fatal("Status recorded as delivered before it's updated in hub")
+	}
+
+	// the conflict is retried with the latest hub addon
+	hubClient.errs = []error{conflict, conflict}
+	result, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: (%v)", err)
+	}
+	if result.RequeueAfter != statusResyncPeriod {
+		t.Fatalf("Reconcile not requeued to resync the status: (%v)", result)
+	}
+	hubObsAddon := &oav1beta1.ObservabilityAddon{}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testHubNamspace}, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to get oba in hub: (%v)", err)
+	}
+	if len(hubObsAddon.Status.Conditions) != 1 || hubObsAddon.Status.Conditions[0].Type != "Available" {
+		t.Fatalf("Status not updated in hub observabilityaddon: (%v)", hubObsAddon.Status)
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testNamespace}, local)
+	if err != nil {
+		t.Fatalf("Failed to get local oba: (%v)", err)
+	}
+	hash, _ := getStatusHash(local.Status)
+	if local.GetAnnotations()[hubStatusAnnotation] != hash {
+		t.Fatalf("Delivered status not recorded: (%v)", local.GetAnnotations())
+	}
+
+	// the status in hub is reset, the resync delivers it again
+	hubObsAddon.Status = oav1beta1.ObservabilityAddonStatus{}
+	err = hubClient.Client.Status().Update(ctx, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to reset oba status in hub: (%v)", err)
+	}
+	// the delivered status is not pushed again before the resync period
+	result, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: (%v)", err)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > statusResyncPeriod {
+		t.Fatalf("Reconcile not requeued to resync the status: (%v)", result)
+	}
+	hubObsAddon = &oav1beta1.ObservabilityAddon{}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testHubNamspace}, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to get oba in hub: (%v)", err)
+	}
+	if len(hubObsAddon.Status.Conditions) != 0 {
+		t.Fatalf("Delivered status pushed to hub again: (%v)", hubObsAddon.Status)
+	}
+
+	r.lastResync = time.Now().Add(-statusResyncPeriod)
+	_, err = r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: (%v)", err)
+	}
+	err = hubClient.Get(ctx, types.NamespacedName{Name: obAddonName, Namespace: testHubNamspace}, hubObsAddon)
+	if err != nil {
+		t.Fatalf("Failed to get oba in hub: (%v)", err)
+	}
+	if len(hubObsAddon.Status.Conditions) != 1 {
+		t.Fatalf("Status not resynced in hub observabilityaddon: (%v)", hubObsAddon.Status)
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	caseList := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "conflict",
+			err:      errors.NewConflict(schema.GroupResource{Resource: "observabilityaddons"}, name, nil),
+			expected: true,
+		},
+		{
+			name:     "deadline exceeded",
+			err:      fmt.Errorf("failed to update: %w", context.DeadlineExceeded),
+			expected: true,
+		},
+		{
+			name:     "network error",
+			err:      &url.Error{Op: "Put", URL: "https://hub:6443", Err: &net.OpError{Op: "dial", Err: syscall.EHOSTUNREACH}},
+			expected: true,
+		},
+		{
+			name:     "eof",
+			err:      io.EOF,
+			expected: true,
+		},
+		{
+			name:     "forbidden",
+			err:      errors.NewForbidden(schema.GroupResource{Resource: "observabilityaddons"}, name, nil),
+			expected: false,
+		},
+	}
+	for _, c := range caseList {
+		t.Run(c.name, func(t *testing.T) {
+			if isRetriable(c.err) != c.expected {
+				t.Fatalf("Wrong retriable check for %v, expected: %v", c.err, c.expected)
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	"github.com/IBM/controller-filtered-cache/filteredcache"
 	ocinfrav1 "github.com/openshift/api/config/v1"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
@@ -21,11 +22,13 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	obsepctl "github.com/open-cluster-management/endpoint-metrics-operator/controllers/observabilityendpoint"
 	statusctl "github.com/open-cluster-management/endpoint-metrics-operator/controllers/status"
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
 	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/util"
 	"github.com/open-cluster-management/endpoint-metrics-operator/version"
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
@@ -41,6 +44,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(oav1beta1.AddToScheme(scheme))
 	utilruntime.Must(ocinfrav1.AddToScheme(scheme))
+	utilruntime.Must(monitoringv1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -60,6 +64,8 @@ func main() {
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	operatorConfig := config.NewOperatorConfig()
+	operatorConfig.BindFlags(flag.CommandLine)
 	opts := zap.Options{
 		Development: true,
 	}
@@ -68,7 +74,11 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	namespace := os.Getenv("WATCH_NAMESPACE")
+	if err := operatorConfig.Load(flag.CommandLine); err != nil {
+		setupLog.Error(err, "invalid operator configuration")
+		os.Exit(1)
+	}
+	namespace := operatorConfig.Namespace
 	gvkLabelMap := map[schema.GroupVersionKind]filteredcache.Selector{
 		v1.SchemeGroupVersion.WithKind("Secret"): {
 			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
@@ -76,6 +86,12 @@ func main() {
 		v1.SchemeGroupVersion.WithKind("ConfigMap"): {
 			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
 		},
+		v1.SchemeGroupVersion.WithKind("Pod"): {
+			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
+		},
+		v1.SchemeGroupVersion.WithKind("Event"): {
+			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
+		},
 		appsv1.SchemeGroupVersion.WithKind("Deployment"): {
 			FieldSelector: fmt.Sprintf("metadata.namespace==%s", namespace),
 		},
@@ -84,7 +100,14 @@ func main() {
 		},
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	// the kubeconfig of the managed cluster is set with --kubeconfig, or is the in-cluster config
+	kubeConfig, err := ctrlconfig.GetConfigWithContext(operatorConfig.KubeContext)
+	if err != nil {
+		setupLog.Error(err, "unable to get the kubeconfig")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(kubeConfig, ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
@@ -98,7 +121,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	hubClient, err := util.GetOrCreateHubClient()
+	hubClient, err := util.GetOrCreateHubClient(operatorConfig)
 	if err != nil {
 		setupLog.Error(err, "Failed to create the hub client")
 		os.Exit(1)
@@ -108,6 +131,8 @@ func main() {
 		Client:    mgr.GetClient(),
 		Scheme:    mgr.GetScheme(),
 		HubClient: hubClient,
+		Config:    operatorConfig,
+		Recorder:  mgr.GetEventRecorderFor("endpoint-observability-operator"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ObservabilityAddon")
 		os.Exit(1)
@@ -116,6 +141,7 @@ func main() {
 		Client:    mgr.GetClient(),
 		Scheme:    mgr.GetScheme(),
 		HubClient: hubClient,
+		Config:    operatorConfig,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Status")
 		os.Exit(1)
@@ -127,11 +153,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	ctx := ctrl.SetupSignalHandler()
 	// start lease
-	util.StartLease()
+	if operatorConfig.LeaseEnabled() {
+		if err := util.StartLease(ctx, kubeConfig, operatorConfig); err != nil {
+			setupLog.Error(err, "unable to start the lease updater")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("lease updater disabled")
+	}
+	// reload the hub kubeconfig when the registration agent rotates it, the operator exits to be
+	// restarted with the new hub server when it changes
+	go func() {
+		if err := util.WatchHubKubeConfig(ctx, operatorConfig); err != nil {
+			setupLog.Error(err, "unable to reload the hub kubeconfig")
+			os.Exit(1)
+		}
+	}()
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
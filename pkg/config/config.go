@@ -0,0 +1,165 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultHubKubeConfigPath is the path of the hub kubeconfig written by the registration agent
+	DefaultHubKubeConfigPath = "/spoke/hub-kubeconfig/kubeconfig"
+)
+
+// OperatorConfig is the configuration of the operator, it's read from the flags and the optional config file
+type OperatorConfig struct {
+	// Namespace is the namespace of the operator, the observabilityaddon and the metrics collector
+	Namespace string `yaml:"namespace"`
+	// HubNamespace is the namespace of the managed cluster in the hub cluster
+	HubNamespace string `yaml:"hubNamespace"`
+	// HubKubeConfigPath is the path of the kubeconfig to access the hub cluster
+	HubKubeConfigPath string `yaml:"hubKubeConfigPath"`
+	// ServiceAccount is the service account of the metrics collector
+	ServiceAccount string `yaml:"serviceAccount"`
+	// CollectorImage is the image of the metrics collector
+	CollectorImage string `yaml:"collectorImage"`
+	// KubeContext is the context of the managed cluster kubeconfig set with --kubeconfig
+	KubeContext string `yaml:"kubeContext"`
+	// HubKubeContext is the context of the hub kubeconfig
+	HubKubeContext string `yaml:"hubKubeContext"`
+	// LeaseNamespace is the namespace of the addon lease in the managed cluster, it's the namespace if not set
+	LeaseNamespace string `yaml:"leaseNamespace"`
+	// EnableLease enables the lease updater which reports the health of the addon to the hub
+	EnableLease bool `yaml:"enableLease"`
+	// DevMode runs the operator out of the cluster for development, the lease updater is disabled and
+	// the metrics source is not checked as the services in the cluster are not reachable
+	DevMode bool `yaml:"devMode"`
+
+	// ConfigFile is the path of the config file, the values in it are used for the flags which are not set
+	ConfigFile string `yaml:"-"`
+}
+
+type field struct {
+	flag     string
+	usage    string
+	required bool
+	// value returns the pointer to the string or bool value of the field
+	value func(c *OperatorConfig) interface{}
+}
+
+var fields = []field{
+	{"namespace", "The namespace of the operator, the observabilityaddon and the metrics collector.", true,
+		func(c *OperatorConfig) interface{} { return &c.Namespace }},
+	{"hub-namespace", "The namespace of the managed cluster in the hub cluster.", true,
+		func(c *OperatorConfig) interface{} { return &c.HubNamespace }},
+	{"hub-kubeconfig", "The path of the kubeconfig to access the hub cluster.", true,
+		func(c *OperatorConfig) interface{} { return &c.HubKubeConfigPath }},
+	{"service-account", "The service account of the metrics collector.", false,
+		func(c *OperatorConfig) interface{} { return &c.ServiceAccount }},
+	{"collector-image", "The image of the metrics collector.", false,
+		func(c *OperatorConfig) interface{} { return &c.CollectorImage }},
+	{"kube-context", "The context in the kubeconfig of the managed cluster set with --kubeconfig.", false,
+		func(c *OperatorConfig) interface{} { return &c.KubeContext }},
+	{"hub-kube-context", "The context in the hub kubeconfig.", false,
+		func(c *OperatorConfig) interface{} { return &c.HubKubeContext }},
+	{"lease-namespace", "The namespace of the addon lease in the managed cluster, the namespace is used if not set.",
+		false, func(c *OperatorConfig) interface{} { return &c.LeaseNamespace }},
+	{"enable-lease", "Enable the lease updater which reports the health of the addon to the hub.", false,
+		func(c *OperatorConfig) interface{} { return &c.EnableLease }},
+	{"dev-mode", "Run out of the cluster for development with the kubeconfig of the managed cluster and " +
+		"the hub, the lease updater is disabled and the metrics source is not checked.", false,
+		func(c *OperatorConfig) interface{} { return &c.DevMode }},
+}
+
+// NewOperatorConfig returns the config with the defaults, the environment variables set in the operator
+// deployment by the former versions are used as the defaults so the existing deployments keep working
+func NewOperatorConfig() *OperatorConfig {
+	c := &OperatorConfig{
+		Namespace:         os.Getenv("WATCH_NAMESPACE"),
+		LeaseNamespace:    os.Getenv("WATCH_NAMESPACE"),
+		HubNamespace:      os.Getenv("HUB_NAMESPACE"),
+		HubKubeConfigPath: DefaultHubKubeConfigPath,
+		ServiceAccount:    os.Getenv("SERVICE_ACCOUNT"),
+		CollectorImage:    os.Getenv("COLLECTOR_IMAGE"),
+		EnableLease:       true,
+	}
+	if os.Getenv("NAMESPACE") != "" {
+		c.Namespace = os.Getenv("NAMESPACE")
+	}
+	return c
+}
+
+// BindFlags binds the flags of the config to the flag set
+func (c *OperatorConfig) BindFlags(fs *flag.FlagSet) {
+	for _, f := range fields {
+		switch v := f.value(c).(type) {
+		case *string:
+			fs.StringVar(v, f.flag, *v, f.usage)
+		case *bool:
+			fs.BoolVar(v, f.flag, *v, f.usage)
+		}
+	}
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile,
+		"The path of the config file, the values in it are used for the flags which are not set.")
+}
+
+// Load reads the config file once the flags are parsed, and validates the config
+func (c *OperatorConfig) Load(fs *flag.FlagSet) error {
+	if c.ConfigFile != "" {
+		data, err := ioutil.ReadFile(c.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the config file %s: %v", c.ConfigFile, err)
+		}
+		// the values which are not in the config file are kept
+		fileConfig := *c
+		err = yaml.UnmarshalStrict(data, &fileConfig)
+		if err != nil {
+			return fmt.Errorf("failed to parse the config file %s: %v", c.ConfigFile, err)
+		}
+		set := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		for _, f := range fields {
+			if set[f.flag] {
+				continue
+			}
+			switch v := f.value(c).(type) {
+			case *string:
+				*v = *f.value(&fileConfig).(*string)
+			case *bool:
+				*v = *f.value(&fileConfig).(*bool)
+			}
+		}
+	}
+	return c.Validate()
+}
+
+// LeaseEnabled returns whether the lease updater is started
+func (c *OperatorConfig) LeaseEnabled() bool {
+	return c.EnableLease && !c.DevMode
+}
+
+// GetLeaseNamespace returns the namespace of the addon lease, it's WATCH_NAMESPACE by default as in the former
+// versions, which may differ from the namespace overridden by NAMESPACE
+func (c *OperatorConfig) GetLeaseNamespace() string {
+	if c.LeaseNamespace != "" {
+		return c.LeaseNamespace
+	}
+	return c.Namespace
+}
+
+// Validate checks that the required values are set
+func (c *OperatorConfig) Validate() error {
+	for _, f := range fields {
+		if v, ok := f.value(c).(*string); ok && f.required && *v == "" {
+			return fmt.Errorf("%s is required", f.flag)
+		}
+	}
+	return nil
+}
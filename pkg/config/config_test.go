@@ -0,0 +1,185 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewOperatorConfig(t *testing.T) {
+	envs := map[string]string{
+		"WATCH_NAMESPACE": "watch-ns",
+		"NAMESPACE":       "",
+		"HUB_NAMESPACE":   "cluster1",
+		"SERVICE_ACCOUNT": "endpoint-observability-operator-sa",
+		"COLLECTOR_IMAGE": "quay.io/open-cluster-management/metrics-collector:latest",
+	}
+	for name, value := range envs {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	c := NewOperatorConfig()
+	expected := OperatorConfig{
+		Namespace:         "watch-ns",
+		LeaseNamespace:    "watch-ns",
+		HubNamespace:      "cluster1",
+		HubKubeConfigPath: DefaultHubKubeConfigPath,
+		ServiceAccount:    "endpoint-observability-operator-sa",
+		CollectorImage:    "quay.io/open-cluster-management/metrics-collector:latest",
+		EnableLease:       true,
+	}
+	if *c != expected {
+		t.Fatalf("Wrong config from the environment variables: %+v", c)
+	}
+
+	// NAMESPACE takes precedence over WATCH_NAMESPACE
+	os.Setenv("NAMESPACE", "ns")
+	c = NewOperatorConfig()
+	if c.Namespace != "ns" {
+		t.Fatalf("Wrong namespace: %s", c.Namespace)
+	}
+	// the lease is kept in WATCH_NAMESPACE as in the former versions
+	if c.GetLeaseNamespace() != "watch-ns" {
+		t.Fatalf("Wrong lease namespace: %s", c.GetLeaseNamespace())
+	}
+	c.LeaseNamespace = ""
+	if c.GetLeaseNamespace() != "ns" {
+		t.Fatalf("Lease namespace not defaulted to the namespace: %s", c.GetLeaseNamespace())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "operator-config")
+	if err != nil {
+		t.Fatalf("Failed to create the temp dir: (%v)", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(configFile, []byte(`
+namespace: file-ns
+hubNamespace: file-cluster
+collectorImage: file-image
+`), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the config file: (%v)", err)
+	}
+	devConfigFile := filepath.Join(dir, "dev-config.yaml")
+	err = ioutil.WriteFile(devConfigFile, []byte(`
+namespace: ns
+hubNamespace: cluster1
+hubKubeConfigPath: /home/dev/.kube/hub
+hubKubeContext: hub
+kubeContext: managed
+enableLease: false
+devMode: true
+`), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the config file: (%v)", err)
+	}
+
+	caseList := []struct {
+		name     string
+		args     []string
+		expected OperatorConfig
+		err      bool
+	}{
+		{
+			name: "flags",
+			args: []string{"--namespace=ns", "--hub-namespace=cluster1", "--hub-kubeconfig=/tmp/kubeconfig",
+				"--service-account=sa", "--collector-image=image"},
+			expected: OperatorConfig{
+				Namespace:         "ns",
+				HubNamespace:      "cluster1",
+				HubKubeConfigPath: "/tmp/kubeconfig",
+				ServiceAccount:    "sa",
+				CollectorImage:    "image",
+			},
+		},
+		{
+			name: "flags take precedence over the config file",
+			args: []string{"--config=" + configFile, "--namespace=ns"},
+			expected: OperatorConfig{
+				Namespace:         "ns",
+				HubNamespace:      "file-cluster",
+				HubKubeConfigPath: DefaultHubKubeConfigPath,
+				CollectorImage:    "file-image",
+				ConfigFile:        configFile,
+			},
+		},
+		{
+			name: "dev mode",
+			args: []string{"--config=" + devConfigFile, "--enable-lease=true"},
+			expected: OperatorConfig{
+				Namespace:         "ns",
+				HubNamespace:      "cluster1",
+				HubKubeConfigPath: "/home/dev/.kube/hub",
+				KubeContext:       "managed",
+				HubKubeContext:    "hub",
+				EnableLease:       true,
+				DevMode:           true,
+				ConfigFile:        devConfigFile,
+			},
+		},
+		{
+			name: "missing hub namespace",
+			args: []string{"--namespace=ns"},
+			err:  true,
+		},
+		{
+			name: "missing config file",
+			args: []string{"--config=" + filepath.Join(dir, "missing.yaml")},
+			err:  true,
+		},
+	}
+
+	for _, cs := range caseList {
+		t.Run(cs.name, func(t *testing.T) {
+			c := &OperatorConfig{HubKubeConfigPath: DefaultHubKubeConfigPath}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			c.BindFlags(fs)
+			err := fs.Parse(cs.args)
+			if err != nil {
+				t.Fatalf("Failed to parse the flags: (%v)", err)
+			}
+			err = c.Load(fs)
+			if cs.err {
+				if err == nil {
+					t.Fatalf("Missing the error for the config: %+v", c)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to load the config: (%v)", err)
+			}
+			if *c != cs.expected {
+				t.Fatalf("Wrong config, expected: %+v, actual: %+v", cs.expected, c)
+			}
+			if c.DevMode && c.LeaseEnabled() {
+				t.Fatal("Lease enabled in dev mode")
+			}
+		})
+	}
+}
+
+func TestLoadInvalidConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "operator-config")
+	if err != nil {
+		t.Fatalf("Failed to create the temp dir: (%v)", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(configFile, []byte("namespace: ns\nhubNamespce: cluster1\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the config file: (%v)", err)
+	}
+	c := &OperatorConfig{ConfigFile: configFile}
+	err = c.Load(flag.NewFlagSet("test", flag.ContinueOnError))
+	if err == nil {
+		t.Fatal("Missing the error for the unknown field in the config file")
+	}
+}
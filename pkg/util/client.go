@@ -8,6 +8,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
 	ocpClientSet "github.com/openshift/client-go/config/clientset/versioned"
 )
@@ -21,17 +22,13 @@ var (
 	log = ctrl.Log.WithName("util")
 )
 
-const (
-	hubKubeConfigPath = "/spoke/hub-kubeconfig/kubeconfig"
-)
-
 // GetOrCreateOCPClient get an existing hub client or create new one if it doesn't exist
-func GetOrCreateHubClient() (client.Client, error) {
+func GetOrCreateHubClient(cfg *config.OperatorConfig) (client.Client, error) {
 	if hubClient != nil {
 		return hubClient, nil
 	}
-	// create the config from the path
-	config, err := clientcmd.BuildConfigFromFlags("", hubKubeConfigPath)
+	// create the config from the hub kubeconfig, the rotated credentials are reloaded into it
+	hubConfig, err := getHubConfig(cfg)
 	if err != nil {
 		log.Error(err, "Failed to create the config")
 		return nil, err
@@ -43,7 +40,7 @@ func GetOrCreateHubClient() (client.Client, error) {
 	}
 
 	// generate the client based off of the config
-	hubClient, err := client.New(config, client.Options{Scheme: s})
+	hubClient, err = client.New(hubConfig, client.Options{Scheme: s})
 
 	if err != nil {
 		log.Error(err, "Failed to create hub client")
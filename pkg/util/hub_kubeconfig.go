@@ -0,0 +1,187 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
+)
+
+const (
+	// hubKubeConfigCheckPeriod is the period to check whether the hub kubeconfig is rotated
+	hubKubeConfigCheckPeriod = 30 * time.Second
+)
+
+// ErrHubServerChanged is returned by WatchHubKubeConfig when the server in the hub kubeconfig changes, the hub
+// clients cannot switch to it so the operator has to be restarted
+var ErrHubServerChanged = errors.New("the hub server in the hub kubeconfig changed")
+
+var (
+	hubKubeConfigOnce   sync.Once
+	loadedHubKubeConfig *hubKubeConfig
+	hubKubeConfigErr    error
+)
+
+// reloadableTransport sends the requests with the transport built from the latest hub kubeconfig,
+// the transport is swapped atomically so the requests in flight complete with the former one
+type reloadableTransport struct {
+	rt atomic.Value
+}
+
+func (t *reloadableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.rt.Load().(http.RoundTripper).RoundTrip(req)
+}
+
+// hubKubeConfig is the hub kubeconfig and the client certificates it refers to, the clients built
+// from its config pick up the rotated credentials without being rebuilt
+type hubKubeConfig struct {
+	path      string
+	context   string
+	transport *reloadableTransport
+	config    *rest.Config
+	hash      []byte
+}
+
+func newHubKubeConfig(path string, context string) (*hubKubeConfig, error) {
+	h := &hubKubeConfig{
+		path:      path,
+		context:   context,
+		transport: &reloadableTransport{},
+	}
+	config, hash, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+	rt, err := rest.TransportFor(config)
+	if err != nil {
+		log.Error(err, "Failed to create the hub transport")
+		return nil, err
+	}
+	h.transport.rt.Store(rt)
+	h.hash = hash
+	h.config = &rest.Config{
+		Host:      config.Host,
+		APIPath:   config.APIPath,
+		Transport: h.transport,
+	}
+	return h, nil
+}
+
+// load reads the kubeconfig with the certificate files it refers to, and returns the hash of
+// the content so the rotation of any of them is detected
+func (h *hubKubeConfig) load() (*rest.Config, []byte, error) {
+	data, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		log.Error(err, "Failed to read the hub kubeconfig", "path", h.path)
+		return nil, nil, err
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: h.path},
+		&clientcmd.ConfigOverrides{CurrentContext: h.context}).ClientConfig()
+	if err != nil {
+		log.Error(err, "Failed to create the hub config")
+		return nil, nil, err
+	}
+	err = rest.LoadTLSFiles(config)
+	if err != nil {
+		log.Error(err, "Failed to load the certificates of the hub kubeconfig")
+		return nil, nil, err
+	}
+	hash := sha256.New()
+	for _, content := range [][]byte{data, config.CertData, config.KeyData, config.CAData} {
+		hash.Write(content)
+	}
+	return config, hash.Sum(nil), nil
+}
+
+// reload rebuilds the hub transport if the kubeconfig or its certificates changed, the former
+// transport is kept if the new content is not valid, e.g. while it's partially written, or if
+// the hub server changed, ErrHubServerChanged is returned then
+func (h *hubKubeConfig) reload() (bool, error) {
+	config, hash, err := h.load()
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(hash, h.hash) {
+		return false, nil
+	}
+	if config.Host != h.config.Host {
+		return false, ErrHubServerChanged
+	}
+	rt, err := rest.TransportFor(config)
+	if err != nil {
+		log.Error(err, "Failed to create the hub transport")
+		return false, err
+	}
+	former := h.transport.rt.Load().(http.RoundTripper)
+	h.transport.rt.Store(rt)
+	h.hash = hash
+	closeIdleConnections(former)
+	return true, nil
+}
+
+// closeIdleConnections closes the idle connections of the transport wrapped in rt
+func closeIdleConnections(rt http.RoundTripper) {
+	for rt != nil {
+		if t, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			t.CloseIdleConnections()
+			return
+		}
+		wrapper, ok := rt.(utilnet.RoundTripperWrapper)
+		if !ok {
+			return
+		}
+		rt = wrapper.WrappedRoundTripper()
+	}
+}
+
+// getHubConfig returns the config of the hub clients built from the hub kubeconfig of the operator
+// configuration, its transport is reloaded by WatchHubKubeConfig
+func getHubConfig(cfg *config.OperatorConfig) (*rest.Config, error) {
+	hubKubeConfigOnce.Do(func() {
+		loadedHubKubeConfig, hubKubeConfigErr = newHubKubeConfig(cfg.HubKubeConfigPath, cfg.HubKubeContext)
+	})
+	if hubKubeConfigErr != nil {
+		return nil, hubKubeConfigErr
+	}
+	return rest.CopyConfig(loadedHubKubeConfig.config), nil
+}
+
+// WatchHubKubeConfig checks the hub kubeconfig periodically until the context is done, the hub client
+// and the lease updater use the new credentials once the registration agent rotates them. It returns
+// ErrHubServerChanged when the hub server changes, the hub clients are built for the former one.
+func WatchHubKubeConfig(ctx context.Context, cfg *config.OperatorConfig) error {
+	_, err := getHubConfig(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var serverErr error
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		reloaded, err := loadedHubKubeConfig.reload()
+		if err == ErrHubServerChanged {
+			serverErr = err
+			cancel()
+			return
+		}
+		if err == nil && reloaded {
+			log.Info("Hub kubeconfig reloaded", "path", loadedHubKubeConfig.path)
+		}
+	}, hubKubeConfigCheckPeriod)
+	return serverErr
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2021 Red Hat, Inc.
+// Copyright Contributors to the Open Cluster Management project.
+package util
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeHubKubeConfig(t *testing.T, path string, server *httptest.Server, token string) {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: hub
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+contexts:
+- name: hub
+  context:
+    cluster: hub
+    user: agent
+current-context: hub
+users:
+- name: agent
+  user:
+    token: %s
+`, server.URL, base64.StdEncoding.EncodeToString(ca), token)
+	err := ioutil.WriteFile(path, []byte(kubeconfig), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the hub kubeconfig: (%v)", err)
+	}
+}
+
+func TestHubKubeConfigReload(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "hub-kubeconfig")
+	if err != nil {
+		t.Fatalf("Failed to create the temp dir: (%v)", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubeconfig")
+
+	getAuthorization := func(h *hubKubeConfig) string {
+		resp, err := (&http.Client{Transport: h.config.Transport}).Get(server.URL)
+		if err != nil {
+			t.Fatalf("Failed to send the request: (%v)", err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read the response: (%v)", err)
+		}
+		return string(body)
+	}
+
+	_, err = newHubKubeConfig(path, "")
+	if err == nil {
+		t.Fatal("Missing the error for the hub kubeconfig not found")
+	}
+
+	writeHubKubeConfig(t, path, server, "token-1")
+	h, err := newHubKubeConfig(path, "")
+	if err != nil {
+		t.Fatalf("Failed to load the hub kubeconfig: (%v)", err)
+	}
+	if h.config.Host != server.URL {
+		t.Fatalf("Wrong host of the hub config: %s", h.config.Host)
+	}
+	if auth := getAuthorization(h); auth != "Bearer token-1" {
+		t.Fatalf("Wrong credentials before the rotation: %s", auth)
+	}
+
+	reloaded, err := h.reload()
+	if err != nil || reloaded {
+		t.Fatalf("Hub kubeconfig reloaded without changes: %v (%v)", reloaded, err)
+	}
+
+	// the credentials are reloaded once rotated
+	writeHubKubeConfig(t, path, server, "token-2")
+	reloaded, err = h.reload()
+	if err != nil || !reloaded {
+		t.Fatalf("Hub kubeconfig not reloaded after the rotation: %v (%v)", reloaded, err)
+	}
+	if auth := getAuthorization(h); auth != "Bearer token-2" {
+		t.Fatalf("Wrong credentials after the rotation: %s", auth)
+	}
+
+	// the former credentials are kept while the kubeconfig is not valid
+	err = ioutil.WriteFile(path, []byte("apiVersion: v1\nkind: Config\nclusters: ["), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the hub kubeconfig: (%v)", err)
+	}
+	reloaded, err = h.reload()
+	if err == nil || reloaded {
+		t.Fatalf("Invalid hub kubeconfig reloaded: %v (%v)", reloaded, err)
+	}
+	if auth := getAuthorization(h); auth != "Bearer token-2" {
+		t.Fatalf("Wrong credentials with the invalid kubeconfig: %s", auth)
+	}
+
+	// the former transport is kept when the hub server changes
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	writeHubKubeConfig(t, path, other, "token-3")
+	reloaded, err = h.reload()
+	if err != ErrHubServerChanged || reloaded {
+		t.Fatalf("Miss the error for the hub server changed: %v (%v)", reloaded, err)
+	}
+	if auth := getAuthorization(h); auth != "Bearer token-2" {
+		t.Fatalf("Wrong credentials after the hub server changed: %s", auth)
+	}
+}
+
+func TestHubKubeConfigContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hub-kubeconfig")
+	if err != nil {
+		t.Fatalf("Failed to create the temp dir: (%v)", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubeconfig")
+	err = ioutil.WriteFile(path, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: managed
+  cluster:
+    server: https://managed.example.com:6443
+- name: hub
+  cluster:
+    server: https://hub.example.com:6443
+contexts:
+- name: managed
+  context:
+    cluster: managed
+    user: dev
+- name: hub
+  context:
+    cluster: hub
+    user: dev
+current-context: managed
+users:
+- name: dev
+  user:
+    token: dev-token
+`), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the hub kubeconfig: (%v)", err)
+	}
+
+	h, err := newHubKubeConfig(path, "")
+	if err != nil {
+		t.Fatalf("Failed to load the hub kubeconfig: (%v)", err)
+	}
+	if h.config.Host != "https://managed.example.com:6443" {
+		t.Fatalf("Wrong host of the current context: %s", h.config.Host)
+	}
+	h, err = newHubKubeConfig(path, "hub")
+	if err != nil {
+		t.Fatalf("Failed to load the hub kubeconfig with the context: (%v)", err)
+	}
+	if h.config.Host != "https://hub.example.com:6443" {
+		t.Fatalf("Wrong host of the hub context: %s", h.config.Host)
+	}
+	_, err = newHubKubeConfig(path, "missing")
+	if err == nil {
+		t.Fatal("Missing the error for the context not found")
+	}
+}
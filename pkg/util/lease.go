@@ -5,46 +5,41 @@ package util
 
 import (
 	"context"
-	"os"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/open-cluster-management/addon-framework/pkg/lease"
+	"github.com/open-cluster-management/endpoint-metrics-operator/pkg/config"
 )
 
 const (
 	leaseName = "observability-controller"
 )
 
-var (
-	namespace   = os.Getenv("WATCH_NAMESPACE")
-	clusterName = os.Getenv("HUB_NAMESPACE")
-)
-
-func StartLease() {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		log.Error(err, "Failed to create incluster config")
-		panic(err.Error())
-	}
+// StartLease starts the lease updater which reports the health of the addon to the hub,
+// kubeConfig is the config of the managed cluster
+func StartLease(ctx context.Context, kubeConfig *rest.Config, cfg *config.OperatorConfig) error {
 	// creates the clientset
-	c, err := kubernetes.NewForConfig(config)
+	c, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
 		log.Error(err, "Failed to create kube client")
-		panic(err.Error())
+		return err
 	}
 
-	// create the config from the path
-	hubConfig, err := clientcmd.BuildConfigFromFlags("", hubKubeConfigPath)
+	// create the config from the hub kubeconfig, the rotated credentials are reloaded into it
+	// as the lease updater cannot be stopped and rebuilt
+	hubConfig, err := getHubConfig(cfg)
 	if err != nil {
 		log.Error(err, "Failed to create the hub config")
-		panic(err.Error())
+		return err
 	}
 
-	actual := lease.CheckAddonPodFunc(c.CoreV1(), namespace, "name=endpoint-observability-operator")
-	leaseController := lease.NewLeaseUpdater(c, leaseName, namespace, actual).
-		WithHubLeaseConfig(hubConfig, clusterName)
-	go leaseController.Start(context.TODO())
+	// the lease namespace is WATCH_NAMESPACE by default as in the former versions
+	leaseNamespace := cfg.GetLeaseNamespace()
+	actual := lease.CheckAddonPodFunc(c.CoreV1(), leaseNamespace, "name=endpoint-observability-operator")
+	leaseController := lease.NewLeaseUpdater(c, leaseName, leaseNamespace, actual).
+		WithHubLeaseConfig(hubConfig, cfg.HubNamespace)
+	go leaseController.Start(ctx)
+	return nil
 }
@@ -4,46 +4,291 @@ package util
 
 import (
 	"context"
-	"time"
+	"sort"
+	"strings"
 
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// Condition types of the ObservabilityAddon status
+const (
+	ConditionAvailable         = "Available"
+	ConditionProgressing       = "Progressing"
+	ConditionDegraded          = "Degraded"
+	ConditionMetricsForwarding = "MetricsForwarding"
+	ConditionAlertForwarding   = "AlertForwarding"
+	ConditionHubConnected      = "HubConnected"
+	// ConditionMonitoringConfigDrift is True when changes made by others in the cluster monitoring config
+	// were reverted in the last minutes
+	ConditionMonitoringConfigDrift = "MonitoringConfigDrift"
+	// ConditionDisabled and ConditionNotSupported are the condition types of the former versions, which are still
+	// read by the hub. They are deprecated, and are kept True in the Disabled and NotSupported states.
+	ConditionDisabled     = "Disabled"
+	ConditionNotSupported = "NotSupported"
+	// ConditionHubForwardingPrefix is the prefix of the forwarding condition types of the additional hubs
+	ConditionHubForwardingPrefix = "HubForwarding/"
+)
+
+// HubConditionType returns the type of the forwarding condition of the additional hub
+func HubConditionType(name string) string {
+	return ConditionHubForwardingPrefix + name
+}
+
+// IsHubConditionType returns true if the condition type is the forwarding condition of an additional hub
+func IsHubConditionType(conditionType string) bool {
+	return strings.HasPrefix(conditionType, ConditionHubForwardingPrefix)
+}
+
+// conditionTypes are the condition types in the order they are listed in the status,
+// the conditions of other types are left by the former versions and removed
+var conditionTypes = []string{
+	ConditionAvailable,
+	ConditionProgressing,
+	ConditionDegraded,
+	ConditionMetricsForwarding,
+	ConditionAlertForwarding,
+	ConditionHubConnected,
+	ConditionMonitoringConfigDrift,
+	ConditionDisabled,
+	ConditionNotSupported,
+}
+
+// deprecatedConditionTypes are the condition types of the former versions, which are set True in the state
+// of the same name and removed otherwise
+var deprecatedConditionTypes = []string{ConditionDisabled, ConditionNotSupported}
+
+type stateCondition struct {
+	conditionType string
+	status        metav1.ConditionStatus
+}
+
+type state struct {
+	reason     string
+	message    string
+	conditions []stateCondition
+}
+
 var (
-	conditions = map[string]map[string]string{
-		"Deployed": map[string]string{
-			"type":    "Progressing",
-			"reason":  "Deployed",
-			"message": "Metrics collector deployed"},
-		"Disabled": map[string]string{
-			"type":    "Disabled",
-			"reason":  "Disabled",
-			"message": "enableMetrics is set to False"},
-		"Degraded": map[string]string{
-			"type":    "Degraded",
-			"reason":  "Degraded",
-			"message": "Metrics collector deployment not successful"},
-		"NotSupported": map[string]string{
-			"type":    "NotSupported",
-			"reason":  "NotSupported",
-			"message": "No Prometheus service found in this cluster"},
+	states = map[string]state{
+		// Deployed keeps Progressing True with the Deployed reason and message, which is how the former versions
+		// reported the deployed metrics collector and is still read by the hub, Available is the signal to use
+		"Deployed": {
+			reason:  "Deployed",
+			message: "Metrics collector deployed",
+			conditions: []stateCondition{
+				{ConditionAvailable, metav1.ConditionTrue},
+				{ConditionProgressing, metav1.ConditionTrue},
+				{ConditionDegraded, metav1.ConditionFalse},
+				{ConditionMetricsForwarding, metav1.ConditionTrue},
+			}},
+		"Progressing": {
+			reason:  "Progressing",
+			message: "Metrics collector is rolling out",
+			conditions: []stateCondition{
+				{ConditionProgressing, metav1.ConditionTrue},
+				{ConditionDegraded, metav1.ConditionFalse},
+			}},
+		"CollectorUnhealthy": {
+			reason:  "CollectorUnhealthy",
+			message: "Metrics collector is not healthy",
+			conditions: []stateCondition{
+				{ConditionAvailable, metav1.ConditionFalse},
+				{ConditionProgressing, metav1.ConditionFalse},
+				{ConditionDegraded, metav1.ConditionTrue},
+				{ConditionMetricsForwarding, metav1.ConditionFalse},
+			}},
+		"Disabled": {
+			reason:  "Disabled",
+			message: "enableMetrics is set to False",
+			conditions: []stateCondition{
+				{ConditionAvailable, metav1.ConditionTrue},
+				{ConditionProgressing, metav1.ConditionFalse},
+				{ConditionDegraded, metav1.ConditionFalse},
+				{ConditionMetricsForwarding, metav1.ConditionFalse},
+			}},
+		"Degraded": {
+			reason:  "Degraded",
+			message: "Metrics collector deployment not successful",
+			conditions: []stateCondition{
+				{ConditionAvailable, metav1.ConditionFalse},
+				{ConditionProgressing, metav1.ConditionFalse},
+				{ConditionDegraded, metav1.ConditionTrue},
+				{ConditionMetricsForwarding, metav1.ConditionFalse},
+			}},
+		"NotSupported": {
+			reason:  "NotSupported",
+			message: "No Prometheus service found in this cluster",
+			conditions: []stateCondition{
+				{ConditionAvailable, metav1.ConditionFalse},
+				{ConditionProgressing, metav1.ConditionFalse},
+				{ConditionDegraded, metav1.ConditionTrue},
+				{ConditionMetricsForwarding, metav1.ConditionFalse},
+			}},
+		// InvalidAllowlist only sets Degraded, the other conditions follow the health of the metrics collector
+		"InvalidAllowlist": {
+			reason:  "InvalidAllowlist",
+			message: "Metrics allowlist is invalid, the last valid one is in use",
+			conditions: []stateCondition{
+				{ConditionDegraded, metav1.ConditionTrue},
+			}},
+		"InvalidAddonConfig": {
+			reason:  "InvalidAddonConfig",
+			message: "Addon config is invalid",
+			conditions: []stateCondition{
+				{ConditionAvailable, metav1.ConditionFalse},
+				{ConditionProgressing, metav1.ConditionFalse},
+				{ConditionDegraded, metav1.ConditionTrue},
+				{ConditionMetricsForwarding, metav1.ConditionFalse},
+			}},
+		"MetricsSourceUnreachable": {
+			reason:  "MetricsSourceUnreachable",
+			message: "Metrics source is not reachable",
+			conditions: []stateCondition{
+				{ConditionAvailable, metav1.ConditionFalse},
+				{ConditionProgressing, metav1.ConditionFalse},
+				{ConditionDegraded, metav1.ConditionTrue},
+				{ConditionMetricsForwarding, metav1.ConditionFalse},
+			}},
 	}
 )
 
 func ReportStatus(ctx context.Context, client client.Client, i *oav1beta1.ObservabilityAddon, t string) {
-	i.Status.Conditions = []oav1beta1.StatusCondition{
-		{
-			Type:               conditions[t]["type"],
-			Status:             metav1.ConditionTrue,
-			LastTransitionTime: metav1.NewTime(time.Now()),
-			Reason:             conditions[t]["reason"],
-			Message:            conditions[t]["message"],
-		},
-	}
-	err := client.Status().Update(ctx, i)
+	ReportStatusWithMessage(ctx, client, i, t, "")
+}
+
+// ReportStatusWithMessage sets the conditions of the state with the details, e.g. the underlying error,
+// appended to the default message, and updates the status
+func ReportStatusWithMessage(ctx context.Context, client client.Client, i *oav1beta1.ObservabilityAddon,
+	t string, details string) {
+	SetState(i, t, details)
+	UpdateStatus(ctx, client, i)
+}
+
+// SetState sets the conditions of the state in the observabilityaddon without updating the status
+func SetState(i *oav1beta1.ObservabilityAddon, t string, details string) {
+	s := states[t]
+	message := s.message
+	if details != "" {
+		message = message + ": " + details
+	}
+	for _, c := range s.conditions {
+		SetStatusCondition(&i.Status.Conditions, oav1beta1.StatusCondition{
+			Type:    c.conditionType,
+			Status:  c.status,
+			Reason:  s.reason,
+			Message: message,
+		})
+	}
+	for _, conditionType := range deprecatedConditionTypes {
+		if conditionType != t {
+			RemoveStatusCondition(&i.Status.Conditions, conditionType)
+			continue
+		}
+		SetStatusCondition(&i.Status.Conditions, oav1beta1.StatusCondition{
+			Type:    conditionType,
+			Status:  metav1.ConditionTrue,
+			Reason:  s.reason,
+			Message: message,
+		})
+	}
+}
+
+// UpdateStatus updates the status of the observabilityaddon with the conditions set in it
+func UpdateStatus(ctx context.Context, client client.Client, i *oav1beta1.ObservabilityAddon) {
+	conditions := []oav1beta1.StatusCondition{}
+	for _, c := range i.Status.Conditions {
+		if conditionIndex(c.Type) != -1 || IsHubConditionType(c.Type) {
+			conditions = append(conditions, c)
+		}
+	}
+	// the conditions of the additional hubs are listed after the others sorted by type
+	sort.SliceStable(conditions, func(m, n int) bool {
+		im, in := conditionIndex(conditions[m].Type), conditionIndex(conditions[n].Type)
+		switch {
+		case im == -1 && in == -1:
+			return conditions[m].Type < conditions[n].Type
+		case im == -1 || in == -1:
+			return in == -1
+		}
+		return im < in
+	})
+	i.Status.Conditions = conditions
+	// the status is not written again if nothing is changed, e.g. when the reconciliation is triggered by a pod
+	found := &oav1beta1.ObservabilityAddon{}
+	err := client.Get(ctx, types.NamespacedName{Name: i.Name, Namespace: i.Namespace}, found)
+	if err == nil && equality.Semantic.DeepEqual(found.Status, i.Status) {
+		return
+	}
+	err = client.Status().Update(ctx, i)
 	if err != nil {
 		log.Error(err, "Failed to update status for observabilityaddon")
 	}
 }
+
+// SetStatusCondition sets the condition with the meta.SetStatusCondition semantics: the LastTransitionTime
+// is only updated when the status of the condition changes
+func SetStatusCondition(conditions *[]oav1beta1.StatusCondition, newCondition oav1beta1.StatusCondition) {
+	metaConditions := []metav1.Condition{}
+	for _, c := range *conditions {
+		metaConditions = append(metaConditions, metav1.Condition{
+			Type:               c.Type,
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+	}
+	meta.SetStatusCondition(&metaConditions, metav1.Condition{
+		Type:               newCondition.Type,
+		Status:             newCondition.Status,
+		LastTransitionTime: newCondition.LastTransitionTime,
+		Reason:             newCondition.Reason,
+		Message:            newCondition.Message,
+	})
+	result := []oav1beta1.StatusCondition{}
+	for _, c := range metaConditions {
+		result = append(result, oav1beta1.StatusCondition{
+			Type:               c.Type,
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+	}
+	*conditions = result
+}
+
+// RemoveStatusCondition removes the condition of the type
+func RemoveStatusCondition(conditions *[]oav1beta1.StatusCondition, conditionType string) {
+	result := []oav1beta1.StatusCondition{}
+	for _, c := range *conditions {
+		if c.Type != conditionType {
+			result = append(result, c)
+		}
+	}
+	*conditions = result
+}
+
+// FindStatusCondition returns the condition of the type, nil is returned if it's not found
+func FindStatusCondition(conditions []oav1beta1.StatusCondition, conditionType string) *oav1beta1.StatusCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func conditionIndex(conditionType string) int {
+	for i, t := range conditionTypes {
+		if t == conditionType {
+			return i
+		}
+	}
+	return -1
+}
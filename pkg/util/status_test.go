@@ -4,12 +4,13 @@ package util
 
 import (
 	"context"
-	"fmt"
 	"testing"
+	"time"
 
 	oav1beta1 "github.com/open-cluster-management/multicluster-observability-operator/api/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -30,41 +31,237 @@ func newObservabilityAddon(name string, ns string) *oav1beta1.ObservabilityAddon
 
 func TestReportStatus(t *testing.T) {
 	oa := newObservabilityAddon(name, testNamespace)
+	// the deprecated condition is only kept in the state of the same name
+	oa.Status.Conditions = []oav1beta1.StatusCondition{
+		{
+			Type:    "Disabled",
+			Status:  metav1.ConditionTrue,
+			Reason:  "Disabled",
+			Message: "enableMetrics is set to False",
+		},
+	}
 	objs := []runtime.Object{oa}
 	s := scheme.Scheme
 	if err := oav1beta1.AddToScheme(s); err != nil {
 		t.Fatalf("Unable to add oav1beta1 scheme: (%v)", err)
 	}
 
-	expectedStatus := []oav1beta1.StatusCondition{
+	caseList := []struct {
+		state    string
+		expected map[string]metav1.ConditionStatus
+		reason   string
+		// deprecated is the deprecated condition type set in the state
+		deprecated string
+	}{
 		{
-			Type:    "NotSupported",
-			Status:  metav1.ConditionTrue,
-			Reason:  "NotSupported",
-			Message: "No Prometheus service found in this cluster",
+			state: "NotSupported",
+			expected: map[string]metav1.ConditionStatus{
+				ConditionAvailable:         metav1.ConditionFalse,
+				ConditionDegraded:          metav1.ConditionTrue,
+				ConditionMetricsForwarding: metav1.ConditionFalse,
+			},
+			reason:     "NotSupported",
+			deprecated: ConditionNotSupported,
 		},
 		{
-			Type:    "Progressing",
-			Status:  metav1.ConditionTrue,
-			Reason:  "Deployed",
-			Message: "Metrics collector deployed",
+			state: "Deployed",
+			expected: map[string]metav1.ConditionStatus{
+				ConditionAvailable:         metav1.ConditionTrue,
+				ConditionProgressing:       metav1.ConditionTrue,
+				ConditionDegraded:          metav1.ConditionFalse,
+				ConditionMetricsForwarding: metav1.ConditionTrue,
+			},
+			reason: "Deployed",
 		},
 		{
-			Type:    "Disabled",
-			Status:  metav1.ConditionTrue,
-			Reason:  "Disabled",
-			Message: "enableMetrics is set to False",
+			state: "Disabled",
+			expected: map[string]metav1.ConditionStatus{
+				ConditionAvailable:         metav1.ConditionTrue,
+				ConditionDegraded:          metav1.ConditionFalse,
+				ConditionMetricsForwarding: metav1.ConditionFalse,
+			},
+			reason:     "Disabled",
+			deprecated: ConditionDisabled,
 		},
 	}
 
-	statusList := []string{"NotSupported", "Deployed", "Disabled"}
 	s.AddKnownTypes(oav1beta1.GroupVersion, oa)
 	c := fake.NewFakeClient(objs...)
-	for i := range statusList {
-		ReportStatus(context.TODO(), c, oa, statusList[i])
-		if oa.Status.Conditions[0].Message != expectedStatus[i].Message || oa.Status.Conditions[0].Reason != expectedStatus[i].Reason || oa.Status.Conditions[0].Status != expectedStatus[i].Status || oa.Status.Conditions[0].Type != expectedStatus[i].Type {
-			t.Errorf("Error: Status not updated. Expected: %s, Actual: %s", expectedStatus[i], fmt.Sprintf("%+v\n", oa.Status.Conditions[0]))
+	for _, cs := range caseList {
+		ReportStatus(context.TODO(), c, oa, cs.state)
+		for _, conditionType := range []string{ConditionDisabled, ConditionNotSupported} {
+			condition := FindStatusCondition(oa.Status.Conditions, conditionType)
+			if conditionType != cs.deprecated && condition != nil {
+				t.Fatalf("Deprecated condition %s not removed in %s: %+v", conditionType, cs.state,
+					oa.Status.Conditions)
+			}
+			if conditionType == cs.deprecated && (condition == nil || condition.Status != metav1.ConditionTrue) {
+				t.Fatalf("Deprecated condition %s not kept in %s: %+v", conditionType, cs.state,
+					oa.Status.Conditions)
+			}
+		}
+		for conditionType, status := range cs.expected {
+			condition := FindStatusCondition(oa.Status.Conditions, conditionType)
+			if condition == nil || condition.Status != status || condition.Reason != cs.reason {
+				t.Errorf("Error: Status not updated for %s. Expected: %s %s, Actual: %+v",
+					cs.state, conditionType, status, condition)
+			}
+		}
+	}
+	if oa.Status.Conditions[0].Type != ConditionAvailable {
+		t.Errorf("Conditions not sorted: %+v", oa.Status.Conditions)
+	}
+}
+
+func TestReportStatusDeployedCompatible(t *testing.T) {
+	oa := newObservabilityAddon(name, testNamespace)
+	s := scheme.Scheme
+	if err := oav1beta1.AddToScheme(s); err != nil {
+		t.Fatalf("Unable to add oav1beta1 scheme: (%v)", err)
+	}
+	c := fake.NewFakeClient(oa)
+
+	// the former versions reported the deployed metrics collector with this Progressing condition only
+	ReportStatus(context.TODO(), c, oa, "Deployed")
+	condition := FindStatusCondition(oa.Status.Conditions, ConditionProgressing)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != "Deployed" ||
+		condition.Message != "Metrics collector deployed" {
+		t.Fatalf("Deployed not reported as by the former versions: %+v", condition)
+	}
+
+	ReportStatus(context.TODO(), c, oa, "Progressing")
+	condition = FindStatusCondition(oa.Status.Conditions, ConditionProgressing)
+	if condition == nil || condition.Reason == "Deployed" {
+		t.Fatalf("Deployed still reported while rolling out: %+v", condition)
+	}
+}
+
+func TestReportStatusWithMessage(t *testing.T) {
+	oa := newObservabilityAddon(name, testNamespace)
+	s := scheme.Scheme
+	if err := oav1beta1.AddToScheme(s); err != nil {
+		t.Fatalf("Unable to add oav1beta1 scheme: (%v)", err)
+	}
+	c := fake.NewFakeClient(oa)
+
+	ReportStatusWithMessage(context.TODO(), c, oa, "InvalidAllowlist", "invalid metric name \"a-b\"")
+	expected := "Metrics allowlist is invalid, the last valid one is in use: invalid metric name \"a-b\""
+	condition := FindStatusCondition(oa.Status.Conditions, ConditionDegraded)
+	if condition == nil || condition.Reason != "InvalidAllowlist" || condition.Message != expected {
+		t.Errorf("Error: Status not updated. Expected message: %s, Actual: %+v", expected, condition)
+	}
+	// the invalid allowlist doesn't change the conditions reported for the metrics collector
+	for _, conditionType := range []string{ConditionAvailable, ConditionMetricsForwarding} {
+		if c := FindStatusCondition(oa.Status.Conditions, conditionType); c != nil {
+			t.Errorf("Error: %s set for the invalid allowlist: %+v", conditionType, c)
 		}
 	}
+}
+
+func TestSetStatusCondition(t *testing.T) {
+	transitionTime := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	conditions := []oav1beta1.StatusCondition{
+		{
+			Type:               ConditionHubConnected,
+			Status:             metav1.ConditionTrue,
+			LastTransitionTime: transitionTime,
+			Reason:             "HubConnected",
+			Message:            "Connected to the hub cluster",
+		},
+	}
+
+	// the transition time is kept if the status is not changed
+	SetStatusCondition(&conditions, oav1beta1.StatusCondition{
+		Type:    ConditionHubConnected,
+		Status:  metav1.ConditionTrue,
+		Reason:  "HubConnected",
+		Message: "Connected to the hub cluster again",
+	})
+	condition := FindStatusCondition(conditions, ConditionHubConnected)
+	if !condition.LastTransitionTime.Equal(&transitionTime) || condition.Message != "Connected to the hub cluster again" {
+		t.Fatalf("Wrong condition when the status is not changed: %+v", condition)
+	}
+
+	// the transition time is updated if the status is changed
+	SetStatusCondition(&conditions, oav1beta1.StatusCondition{
+		Type:    ConditionHubConnected,
+		Status:  metav1.ConditionFalse,
+		Reason:  "HubUnreachable",
+		Message: "connection refused",
+	})
+	condition = FindStatusCondition(conditions, ConditionHubConnected)
+	if condition.LastTransitionTime.Equal(&transitionTime) || condition.Status != metav1.ConditionFalse {
+		t.Fatalf("Wrong condition when the status is changed: %+v", condition)
+	}
+
+	SetStatusCondition(&conditions, oav1beta1.StatusCondition{
+		Type:   ConditionAlertForwarding,
+		Status: metav1.ConditionTrue,
+		Reason: "Configured",
+	})
+	if len(conditions) != 2 || FindStatusCondition(conditions, ConditionAlertForwarding).LastTransitionTime.IsZero() {
+		t.Fatalf("New condition not added: %+v", conditions)
+	}
+}
+
+func TestUpdateStatusHubConditions(t *testing.T) {
+	oa := newObservabilityAddon(name, testNamespace)
+	oa.Status.Conditions = []oav1beta1.StatusCondition{
+		{Type: HubConditionType("hub-b"), Status: metav1.ConditionTrue, Reason: "Pushed"},
+		{Type: ConditionMetricsForwarding, Status: metav1.ConditionTrue, Reason: "Pushed"},
+		{Type: HubConditionType("hub-a"), Status: metav1.ConditionFalse, Reason: "PushStale"},
+		{Type: ConditionAvailable, Status: metav1.ConditionTrue, Reason: "Deployed"},
+	}
+	s := scheme.Scheme
+	if err := oav1beta1.AddToScheme(s); err != nil {
+		t.Fatalf("Unable to add oav1beta1 scheme: (%v)", err)
+	}
+	c := fake.NewFakeClient(oa)
 
+	UpdateStatus(context.TODO(), c, oa)
+	expected := []string{ConditionAvailable, ConditionMetricsForwarding,
+		HubConditionType("hub-a"), HubConditionType("hub-b")}
+	if len(oa.Status.Conditions) != len(expected) {
+		t.Fatalf("Wrong conditions, expected types: %v, actual: %+v", expected, oa.Status.Conditions)
+	}
+	for i, conditionType := range expected {
+		if oa.Status.Conditions[i].Type != conditionType {
+			t.Fatalf("Wrong conditions, expected types: %v, actual: %+v", expected, oa.Status.Conditions)
+		}
+	}
+}
+
+func TestUpdateStatusUnchanged(t *testing.T) {
+	oa := newObservabilityAddon(name, testNamespace)
+	s := scheme.Scheme
+	if err := oav1beta1.AddToScheme(s); err != nil {
+		t.Fatalf("Unable to add oav1beta1 scheme: (%v)", err)
+	}
+	ctx := context.TODO()
+	c := fake.NewFakeClient(oa)
+
+	ReportStatus(ctx, c, oa, "Deployed")
+	found := &oav1beta1.ObservabilityAddon{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, found); err != nil {
+		t.Fatalf("Failed to get observabilityaddon: (%v)", err)
+	}
+	resourceVersion := found.ResourceVersion
+
+	// the same state does not update the status again
+	ReportStatus(ctx, c, found, "Deployed")
+	found = &oav1beta1.ObservabilityAddon{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, found); err != nil {
+		t.Fatalf("Failed to get observabilityaddon: (%v)", err)
+	}
+	if found.ResourceVersion != resourceVersion {
+		t.Fatal("Status updated without change")
+	}
+
+	ReportStatus(ctx, c, found, "Progressing")
+	if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, found); err != nil {
+		t.Fatalf("Failed to get observabilityaddon: (%v)", err)
+	}
+	if found.ResourceVersion == resourceVersion {
+		t.Fatal("Changed status not updated")
+	}
 }